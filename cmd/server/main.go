@@ -1,28 +1,109 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	_ "time/tzdata" // Schedules need time zones on hosts without zoneinfo
 
+	"minecrap_hoster/internal/audit"
+	"minecrap_hoster/internal/auth"
+	"minecrap_hoster/internal/backup"
+	"minecrap_hoster/internal/certs"
+	"minecrap_hoster/internal/cmdpolicy"
 	"minecrap_hoster/internal/handlers"
+	"minecrap_hoster/internal/hostmem"
+	"minecrap_hoster/internal/instances"
+	"minecrap_hoster/internal/jdk"
 	"minecrap_hoster/internal/minecraft"
+	"minecrap_hoster/internal/modpack"
+	"minecrap_hoster/internal/mods"
+	"minecrap_hoster/internal/oidc"
+	"minecrap_hoster/internal/provision"
+	"minecrap_hoster/internal/ratelimit"
+	"minecrap_hoster/internal/scheduler"
+	"minecrap_hoster/internal/sleeper"
 )
 
+// Rate limits of the route groups not set with -rate-limits
+const default_rate_limits = "login=10/m,command=60/m,write=120/m,read=1200/m"
+
 // Command-line flags
 var (
 	port          = flag.String("port", "8080", "HTTP server port")
-	java_path     = flag.String("java", "java", "Path to Java executable")
+	base_path     = flag.String("base-path", "", "Path prefix the dashboard is served under behind a reverse proxy, e.g. /mc")
+	proxies       = flag.String("trusted-proxies", "", "Reverse proxy IPs or CIDRs whose X-Forwarded-For and X-Forwarded-Proto headers are trusted, comma-separated")
+	tls_cert      = flag.String("tls-cert", "", "TLS certificate file; serves HTTPS on -port when set with -tls-key")
+	tls_key       = flag.String("tls-key", "", "TLS private key file")
+	tls_self      = flag.Bool("tls-self-signed", false, "Generate a self-signed certificate if the certificate files don't exist")
+	tls_hosts     = flag.String("tls-hosts", "", "Extra host names or IPs for the self-signed certificate, comma-separated")
+	redirect_port = flag.String("http-redirect-port", "", "Port for a plain HTTP listener redirecting to HTTPS (empty disables it)")
+	client_ca     = flag.String("tls-client-ca", "", "CA bundle for verifying client certificates (enables mTLS)")
+	client_auth   = flag.String("tls-client-auth", "optional", "With -tls-client-ca: optional verifies certificates clients present, require refuses clients without one")
+	java_path     = flag.String("java", "java", "Path to Java executable, or auto to pick one matching the Minecraft version")
+	java_dirs     = flag.String("java-dirs", "", "Extra directories to search for JDKs, comma-separated")
+	check_java    = flag.Bool("check-java", true, "Refuse to start when Java is too old for the Minecraft version")
 	jar_path      = flag.String("jar", "fabric-server-mc.1.20.1-loader.0.16.5-launcher.1.0.1.jar", "Path to server jar")
-	memory_mb     = flag.Int("memory", 8192, "Memory allocation in MB")
+	server_dir    = flag.String("dir", ".", "Directory the Minecraft server runs in")
+	memory_mb     = flag.String("memory", "8192", "Memory allocation in MB, or auto to size heaps from host memory")
+	headroom_mb   = flag.Int("memory-headroom", 1024, "Memory in MB left for the OS and the hoster when sizing heaps")
+	initial_mb    = flag.Int("initial-memory", 0, "Initial heap (-Xms) in MB (0 leaves it to the JVM)")
+	jvm_preset    = flag.String("jvm-preset", "", "JVM tuning preset: "+strings.Join(minecraft.JVMPresetNames(), ", "))
+	jvm_args      = flag.String("jvm-args", "", "Extra JVM flags, space-separated")
+	jvm_props     = flag.String("jvm-props", "", "Java system properties as key=value, comma-separated")
+	server_args   = flag.String("server-args", "", "Extra arguments passed to the server after nogui, space-separated")
 	max_log_lines = flag.Int("max-logs", 1000, "Maximum number of log lines to keep")
 	use_g1gc      = flag.Bool("g1gc", true, "Use G1 Garbage Collector")
 	jvm_server    = flag.Bool("jvm-server", true, "Use -server JVM flag")
+	restart_warn  = flag.String("restart-warnings", "10m,5m,1m,10s", "When to warn players before a graceful restart")
+	restart_msg   = flag.String("restart-message", "Server restarting in {time}", "Graceful restart warning; {time} is replaced")
+	restart_title = flag.Bool("restart-title", true, "Also show restart warnings as an on-screen title")
+	idle_timeout  = flag.Duration("idle-timeout", 0, "Stop the server after this long without players (0 disables)")
+	idle_listen   = flag.String("idle-listen", "", "Address to answer pings on while asleep (default from server.properties)")
+	idle_motd     = flag.String("idle-motd", sleeper.DefaultConfig().MOTD, "Server list message while asleep")
+	wake_message  = flag.String("wake-message", sleeper.DefaultConfig().WakeMessage, "Message shown to players who wake the server")
+	server_flavor = flag.String("flavor", "", "Server type: "+strings.Join(minecraft.FlavorNames(), ", ")+" (default: the provisioned type, else fabric)")
+	check_mods    = flag.Bool("check-mods", true, "Check mod dependencies and conflicts before starting")
+	backup_config = flag.String("backup-config", "backups.json", "Path to backup target configuration")
+	schedule_file = flag.String("schedules", "schedules.json", "Path to scheduled jobs and run history")
+	mod_api       = flag.String("mod-api", mods.DefaultUpdateAPI, "Modrinth-compatible API used to check for mod updates")
+	provision_at  = flag.String("provision", "", "Download this server if the jar is missing: flavor[:minecraft[:loader]]")
+	vanilla_meta  = flag.String("vanilla-manifest", provision.DefaultSources().Vanilla, "Mojang version manifest URL")
+	fabric_meta   = flag.String("fabric-meta", provision.DefaultSources().Fabric, "Fabric meta API URL")
+	quilt_meta    = flag.String("quilt-meta", provision.DefaultSources().Quilt, "Quilt meta API URL")
+	quilt_maven   = flag.String("quilt-maven", provision.DefaultSources().QuiltMaven, "Maven repository with the Quilt installer")
+	paper_api     = flag.String("paper-api", provision.DefaultSources().Paper, "PaperMC downloads API URL")
+	instance_file = flag.String("instances", "instances.json", "Path to proxy and extra backend instance definitions")
+	use_auth      = flag.Bool("auth", true, "Require dashboard accounts (false leaves every endpoint open)")
+	user_file     = flag.String("users", "users.json", "Path to dashboard accounts")
+	session_ttl   = flag.Duration("session-ttl", 12*time.Hour, "How long a dashboard login lasts")
+	token_file    = flag.String("tokens", "tokens.json", "Path to API tokens")
+	origins       = flag.String("allowed-origins", "", "Other origins allowed to call the API, comma-separated (e.g. https://panel.example.com)")
+	require_2fa   = flag.String("require-2fa", "admin", "Roles whose password accounts must use two-factor authentication (empty for none)")
+	totp_issuer   = flag.String("totp-issuer", "Minecrap Hoster", "Name authenticator apps show for the hoster")
+	rate_limits   = flag.String("rate-limits", default_rate_limits, "Requests allowed per route group (login, command, write, read) from each address and user, e.g. command=10/m; off disables a group")
+	policy_file   = flag.String("command-policy", "command-policy.json", "Path to the per-role console command policy")
+	audit_file    = flag.String("audit-log", "audit.jsonl", "Path to the append-only audit log (empty disables it)")
+	oidc_issuer   = flag.String("oidc-issuer", "", "OpenID Connect issuer URL for single sign-on (empty disables it)")
+	oidc_client   = flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidc_secret   = flag.String("oidc-client-secret", os.Getenv("MCH_OIDC_CLIENT_SECRET"), "OpenID Connect client secret (defaults to $MCH_OIDC_CLIENT_SECRET)")
+	oidc_redirect = flag.String("oidc-redirect-url", "", "Callback URL registered with the provider (default: derived from the request)")
+	oidc_scopes   = flag.String("oidc-scopes", "openid,profile,email", "Scopes requested from the provider")
+	oidc_user     = flag.String("oidc-username-claim", "preferred_username", "ID token claim used as the account name")
+	oidc_groups   = flag.String("oidc-groups-claim", "groups", "ID token claim listing the user's groups")
+	oidc_roles    = flag.String("oidc-roles", "", "Group to role mapping, e.g. mc-admins=admin,mc-ops=operator")
+	oidc_default  = flag.String("oidc-default-role", "", "Role for users in no mapped group (empty refuses them)")
+	pack_hosts    = flag.String("modpack-hosts", strings.Join(modpack.DefaultAllowedHosts, ","), "Hosts modpack files may be downloaded from (empty allows any)")
 )
 
 func main() {
@@ -32,85 +113,609 @@ func main() {
 	// Set up logging with timestamps
 	log.SetFlags(log.Ldate | log.Ltime | log.LUTC)
 
+	// Work out how much memory the servers may use
+	host, err := hostmem.Detect()
+	if err != nil {
+		log.Printf("Host memory unknown: %v", err)
+	}
+	memory, memory_budget, err := parseMemory(*memory_mb, host)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
 	// Initialize and validate configuration
-	config, err := buildConfig()
+	config, err := buildConfig(memory)
 	if err != nil {
 		log.Fatalf("Configuration error: %v", err)
 	}
 
 	// Create server instance
 	server := minecraft.NewServer(config)
+	registerChecks(server)
+
+	// Mod changes made while the server runs wait until it stops
+	mod_manager, err := mods.NewManager(config.WorkingDir, func() bool {
+		return server.Status != minecraft.Stopped
+	})
+	if err != nil {
+		log.Fatalf("Mod manager error: %v", err)
+	}
+	mod_manager.ApplyPending()
+	server.OnStop(mod_manager.ApplyPending)
+
+	// Modpack imports must finish before the server can start, even when forced
+	importer := modpack.NewImporter(nil, splitList(*pack_hosts))
+	server.OnStart(func() error {
+		if importer.Busy() {
+			return modpack.ErrBusy
+		}
+		return nil
+	})
+
+	// Installs must finish before the server can start, even when forced
+	provisioner := newProvisioner(config.JavaPath)
+	server.OnStart(func() error {
+		if provisioner.Busy() {
+			return provision.ErrBusy
+		}
+		return nil
+	})
+
+	// Load backup targets
+	backups, err := backup.NewManager(*backup_config)
+	if err != nil {
+		log.Fatalf("Backup configuration error: %v", err)
+	}
+
+	// The server can't start into a world that is being replaced
+	server.OnStart(func() error {
+		if backups.Restoring() {
+			return backup.ErrRestoring
+		}
+		return nil
+	})
+
+	// Load and start scheduled jobs
+	jobs, err := scheduler.NewScheduler(*schedule_file, server, backups)
+	if err != nil {
+		log.Fatalf("Scheduler error: %v", err)
+	}
+	jobs.Start()
+
+	// Put the server to sleep when nobody is playing
+	var idle *sleeper.Sleeper
+	if *idle_timeout > 0 {
+		idle = sleeper.New(server, sleeper.Config{
+			IdleTimeout: *idle_timeout,
+			ListenAddr:  *idle_listen,
+			MOTD:        *idle_motd,
+			WakeMessage: *wake_message,
+		})
+		idle.Start()
+	}
+
+	// Load the proxy and any extra backends
+	servers, err := instances.NewManager(*instance_file, server, instances.Options{
+		Java:           *java_path,
+		JavaDirs:       splitList(*java_dirs),
+		MemoryBudgetMB: memory_budget,
+		Setup:          registerChecks,
+	})
+	if err != nil {
+		log.Fatalf("Instance configuration error: %v", err)
+	}
+	warnMemory(host, servers.Heaps())
+
+	// Load dashboard accounts
+	var accounts *auth.Store
+	var tokens *auth.TokenStore
+	if *use_auth {
+		accounts, err = loadAccounts()
+		if err != nil {
+			log.Fatalf("Account configuration error: %v", err)
+		}
+		tokens, err = auth.NewTokenStore(*token_file, accounts)
+		if err != nil {
+			log.Fatalf("API token configuration error: %v", err)
+		}
+	} else if *oidc_issuer != "" {
+		log.Fatalf("Single sign-on needs -auth")
+	} else {
+		log.Printf("Warning: accounts are disabled; anyone who can reach port %s controls the server", *port)
+	}
+
+	// Roles that must use two-factor authentication
+	var two_factor_roles []auth.Role
+	for _, name := range splitList(*require_2fa) {
+		role, err := auth.ParseRole(name)
+		if err != nil {
+			log.Fatalf("Invalid -require-2fa: %v", err)
+		}
+		two_factor_roles = append(two_factor_roles, role)
+	}
+
+	// Set up single sign-on
+	var provider *oidc.Provider
+	if *oidc_issuer != "" {
+		provider, err = newOIDCProvider()
+		if err != nil {
+			log.Fatalf("Single sign-on configuration error: %v", err)
+		}
+		log.Printf("Single sign-on through %s enabled", *oidc_issuer)
+	}
+
+	// Open the audit log
+	var audit_log *audit.Log
+	if *audit_file != "" {
+		audit_log, err = audit.Open(*audit_file)
+		if err != nil {
+			log.Fatalf("Audit log error: %v", err)
+		}
+		defer audit_log.Close()
+	}
+
+	// Load the console command policy
+	command_policy, err := cmdpolicy.Load(*policy_file)
+	if err != nil {
+		log.Fatalf("Command policy error: %v", err)
+	}
+
+	trusted_proxies, err := parseCIDRs(*proxies)
+	if err != nil {
+		log.Fatalf("Invalid -trusted-proxies: %v", err)
+	}
+
+	rate_limit_config, err := parseRateLimits(*rate_limits)
+	if err != nil {
+		log.Fatalf("Invalid -rate-limits: %v", err)
+	}
+
+	// Load the TLS certificate
+	tls_config, err := loadTLS()
+	if err != nil {
+		log.Fatalf("TLS configuration error: %v", err)
+	}
 
 	// Create and configure HTTP handler
-	handler := handlers.NewHandler(server)
+	handler := handlers.NewHandler(server, handlers.Services{
+		Backups:     backups,
+		Scheduler:   jobs,
+		Sleeper:     idle,
+		Mods:        mod_manager,
+		Updates:     mods.NewUpdateClient(*mod_api, nil),
+		Modpack:     importer,
+		Provisioner: provisioner,
+		Instances:   servers,
+		JavaDirs:    splitList(*java_dirs),
+		Auth:        accounts,
+		Tokens:      tokens,
+		Audit:       audit_log,
+		OIDC:        provider,
+		Commands:    command_policy,
+
+		TwoFactorRoles: two_factor_roles,
+		TOTPIssuer:     *totp_issuer,
+		AllowedOrigins: splitList(*origins),
+		ClientCerts:    tls_config != nil && tls_config.ClientCAs != nil,
+		BasePath:       *base_path,
+		TrustedProxies: trusted_proxies,
+		RateLimits:     rate_limit_config,
+	})
 	mux := http.NewServeMux()
 	handler.RegisterRoutes(mux)
 
 	// Start HTTP server
 	server_addr := fmt.Sprintf(":%s", *port)
-	log.Printf("Starting server on http://localhost%s", server_addr)
+	if tls_config != nil && *redirect_port != "" {
+		go redirectToHTTPS(fmt.Sprintf(":%s", *redirect_port), *port)
+	}
 
-	if err := startServer(server_addr, mux); err != nil {
+	if err := startServer(server_addr, mux, tls_config); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 }
 
 // buildConfig creates and validates the server configuration.
-func buildConfig() (minecraft.ServerConfig, error) {
+func buildConfig(memory int) (minecraft.ServerConfig, error) {
 	config := minecraft.ServerConfig{
 		JavaPath:            *java_path,
 		ExecutablePath:      *jar_path, // Changed from server_path to jar_path
-		MemoryUtilizationMB: *memory_mb,
+		WorkingDir:          *server_dir,
+		MemoryUtilizationMB: memory,
 		MaxLogLines:         *max_log_lines,
 		UseG1GC:             *use_g1gc,
 		ServerFlag:          *jvm_server, // Changed from server_flag to jvm_server
+		InitialMemoryMB:     *initial_mb,
+		JVMPreset:           *jvm_preset,
+		JVMFlags:            strings.Fields(*jvm_args),
+		ServerArgs:          strings.Fields(*server_args),
 	}
 
+	props, err := parseProperties(*jvm_props)
+	if err != nil {
+		return config, err
+	}
+	config.SystemProperties = props
+
+	warnings, err := minecraft.ParseRestartWarnings(*restart_warn)
+	if err != nil {
+		return config, err
+	}
+	config.RestartWarnings = warnings
+	config.RestartMessage = *restart_msg
+	config.RestartTitle = *restart_title
+
 	// Ensure paths exist and are accessible
 	if err := validatePaths(&config); err != nil {
 		return config, err
 	}
+	if err := minecraft.ValidateJVMArgs(config); err != nil {
+		return config, err
+	}
 
 	// Log configuration
 	log.Printf("Configuration:")
+	log.Printf("  Flavor: %s", config.Flavor.Name())
 	log.Printf("  Java Path: %s", config.JavaPath)
 	log.Printf("  Server Jar: %s", config.ExecutablePath)
+	log.Printf("  Server Directory: %s", config.WorkingDir)
 	log.Printf("  Memory: %d MB", config.MemoryUtilizationMB)
 	log.Printf("  Max Log Lines: %d", config.MaxLogLines)
 	log.Printf("  Use G1GC: %v", config.UseG1GC)
 	log.Printf("  JVM Server Flag: %v", config.ServerFlag)
+	if config.JVMPreset != "" {
+		log.Printf("  JVM Preset: %s", config.JVMPreset)
+	}
+	log.Printf("  Restart Warnings: %v", config.RestartWarnings)
 
 	return config, nil
 }
 
+// loadAccounts opens the account store, creating an admin account with a
+// random password on first run.
+func loadAccounts() (*auth.Store, error) {
+	accounts, err := auth.NewStore(*user_file, *session_ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := accounts.Bootstrap()
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		log.Printf("Created dashboard account \"admin\" with password %s; change it after logging in", password)
+	}
+	return accounts, nil
+}
+
+// newOIDCProvider creates the single sign-on provider from the -oidc flags.
+func newOIDCProvider() (*oidc.Provider, error) {
+	roles, err := oidc.ParseRoles(*oidc_roles)
+	if err != nil {
+		return nil, err
+	}
+	var default_role auth.Role
+	if *oidc_default != "" {
+		if default_role, err = auth.ParseRole(*oidc_default); err != nil {
+			return nil, err
+		}
+	}
+	if len(roles) == 0 && default_role == "" {
+		return nil, fmt.Errorf("-oidc-roles or -oidc-default-role is required, or nobody could log in")
+	}
+
+	return oidc.New(oidc.Config{
+		Issuer:        *oidc_issuer,
+		ClientID:      *oidc_client,
+		ClientSecret:  *oidc_secret,
+		RedirectURL:   *oidc_redirect,
+		Scopes:        splitList(*oidc_scopes),
+		UsernameClaim: *oidc_user,
+		GroupsClaim:   *oidc_groups,
+		Roles:         roles,
+		DefaultRole:   default_role,
+	}, nil)
+}
+
+// registerChecks adds the preflight checks every server instance gets.
+func registerChecks(server *minecraft.MinecraftServer) {
+	if *check_mods && loadsFabricMods(server.Flavor()) {
+		registerModCheck(server)
+	}
+	if *check_java && server.Flavor().NeedsJava() {
+		registerJavaCheck(server)
+	}
+}
+
+// registerJavaCheck blocks starts when Java is too old for the server's
+// Minecraft version.
+func registerJavaCheck(server *minecraft.MinecraftServer) {
+	server.AddPreflightCheck("java", func() error {
+		// The jar and runtime can both change between starts
+		config := server.Config()
+		minecraft_version := provision.DetectMinecraftVersion(config.WorkingDir, config.ExecutablePath)
+		return jdk.Check(config.JavaPath, minecraft_version, splitList(*java_dirs))
+	})
+}
+
+// registerModCheck blocks starts when installed mods would fail to load.
+func registerModCheck(server *minecraft.MinecraftServer) {
+	mods_dir := filepath.Join(server.Dir(), mods.ModsDir)
+
+	server.AddPreflightCheck("mods", func() error {
+		// Read the jar each time, since an upgrade can switch it
+		report, err := mods.Check(mods_dir, mods.DetectGameVersions(server.Config().ExecutablePath))
+		if err != nil {
+			return err
+		}
+		for _, warning := range report.Warnings {
+			log.Printf("Mod check warning: %s", warning)
+		}
+		if report.HasErrors() {
+			return fmt.Errorf("%s", report.String())
+		}
+		return nil
+	})
+}
+
+// resolveServerJar picks the jar to run: an explicit -jar, else the last
+// provisioned jar, else the flavor's usual launch file or the default name,
+// downloading it with -provision when it is missing.
+func resolveServerJar(config *minecraft.ServerConfig) (string, error) {
+	server_path := filepath.Clean(config.ExecutablePath)
+
+	record, err := provision.LoadRecord(config.WorkingDir)
+	if err != nil {
+		return "", err
+	}
+	if !flagSet("jar") {
+		if record != nil && record.Flavor == config.Flavor.Name() {
+			server_path = filepath.Join(config.WorkingDir, record.Jar)
+		} else if found := minecraft.FindExecutable(config.Flavor, config.WorkingDir); found != "" {
+			server_path = found
+		}
+	}
+
+	_, err = os.Stat(server_path)
+	if err == nil {
+		return server_path, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("error accessing server.jar: %v", err)
+	}
+	if *provision_at == "" {
+		return "", fmt.Errorf("server.jar not found at %s (use -provision to download one)", server_path)
+	}
+
+	request, err := provision.ParseRequest(*provision_at)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Server jar not found, provisioning %s", *provision_at)
+	record, err = newProvisioner(config.JavaPath).Install(context.Background(), config.WorkingDir, request)
+	if err != nil {
+		return "", fmt.Errorf("provisioning failed: %v", err)
+	}
+	return filepath.Join(config.WorkingDir, record.Jar), nil
+}
+
+// resolveFlavor picks the server flavor from -flavor, the provisioned
+// server, or -provision, in that order, defaulting to Fabric.
+func resolveFlavor(work_dir string) (minecraft.ServerFlavor, error) {
+	if flagSet("flavor") {
+		return minecraft.FlavorByName(*server_flavor)
+	}
+
+	record, err := provision.LoadRecord(work_dir)
+	if err != nil {
+		return nil, err
+	}
+	if record != nil {
+		return minecraft.FlavorByName(record.Flavor)
+	}
+	if *provision_at != "" {
+		if request, err := provision.ParseRequest(*provision_at); err == nil {
+			return minecraft.FlavorByName(request.Flavor)
+		}
+	}
+	return minecraft.FlavorByName("fabric")
+}
+
+// loadsFabricMods reports whether the flavor loads mods from fabric.mod.json.
+func loadsFabricMods(flavor minecraft.ServerFlavor) bool {
+	return flavor.Name() == "fabric" || flavor.Name() == "quilt"
+}
+
+// newProvisioner creates a provisioner using the manifest URL flags.
+func newProvisioner(java_path string) *provision.Provisioner {
+	return provision.NewProvisioner(provision.Sources{
+		Vanilla:    *vanilla_meta,
+		Fabric:     *fabric_meta,
+		Quilt:      *quilt_meta,
+		QuiltMaven: *quilt_maven,
+		Paper:      *paper_api,
+	}, java_path, nil)
+}
+
+// flagSet reports whether a flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitList parses a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseCIDRs parses a comma-separated list of networks; bare addresses
+// stand for themselves.
+func parseCIDRs(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range splitList(value) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR", item)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseRateLimits reads group=rate pairs over the default limits.
+func parseRateLimits(value string) (map[string]ratelimit.Rate, error) {
+	limits := make(map[string]ratelimit.Rate)
+	for _, item := range append(splitList(default_rate_limits), splitList(value)...) {
+		group, rate, ok := strings.Cut(item, "=")
+		if !ok || !slices.Contains(handlers.RateGroups, group) {
+			return nil, fmt.Errorf("%q is not group=rate with a group of %s", item, strings.Join(handlers.RateGroups, ", "))
+		}
+		parsed, err := ratelimit.ParseRate(rate)
+		if err != nil {
+			return nil, err
+		}
+		limits[group] = parsed
+	}
+	return limits, nil
+}
+
+// parseMemory reads the -memory flag. With auto it returns the heap the main
+// server would get alone and the budget all servers share; otherwise the
+// budget is 0.
+func parseMemory(value string, host hostmem.Info) (int, int, error) {
+	if value != "auto" {
+		memory, err := strconv.Atoi(value)
+		if err != nil || memory <= 0 {
+			return 0, 0, fmt.Errorf("memory must be a positive number of MB or auto, got %q", value)
+		}
+		return memory, 0, nil
+	}
+
+	if host.TotalMB == 0 {
+		return 0, 0, fmt.Errorf("memory can't be sized automatically because host memory is unknown")
+	}
+	budget := host.LimitMB() - *headroom_mb
+	if minimum := hostmem.Footprint(hostmem.MinHeapMB); budget < minimum {
+		log.Printf("Warning: only %d MB is left after %d MB headroom; servers get the minimum heap", budget, *headroom_mb)
+		budget = minimum
+	}
+	log.Printf("Sizing heaps from %d MB of memory (%d MB limit, %d MB headroom)", budget, host.LimitMB(), *headroom_mb)
+	return hostmem.HeapFor(budget), budget, nil
+}
+
+// warnMemory logs when the configured heaps, with JVM overhead, don't fit
+// in the host's memory.
+func warnMemory(host hostmem.Info, heaps map[string]int) {
+	if host.TotalMB == 0 {
+		return
+	}
+
+	needed := 0
+	for _, heap := range heaps {
+		needed += hostmem.Footprint(heap)
+	}
+	available := host.LimitMB() - *headroom_mb
+	if needed > available {
+		log.Printf("Warning: the configured heaps of %d server(s) need about %d MB with JVM overhead, but only %d MB is available (%d MB limit, %d MB headroom)",
+			len(heaps), needed, available, host.LimitMB(), *headroom_mb)
+	}
+}
+
+// parseProperties parses comma-separated key=value system properties.
+func parseProperties(value string) (map[string]string, error) {
+	props := make(map[string]string)
+	for _, item := range splitList(value) {
+		key, val, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("system property %q must be key=value", item)
+		}
+		props[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return props, nil
+}
+
 // validatePaths ensures required files exist and are accessible.
 func validatePaths(config *minecraft.ServerConfig) error {
-	// Check Java executable
-	java_path, err := exec.LookPath(config.JavaPath)
+	// Check server directory
+	work_dir := filepath.Clean(config.WorkingDir)
+	if info, err := os.Stat(work_dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("server directory %s is not accessible", work_dir)
+	}
+	config.WorkingDir = work_dir
+
+	// Pick how the server is launched
+	flavor, err := resolveFlavor(work_dir)
 	if err != nil {
-		return fmt.Errorf("java executable not found: %v", err)
+		return err
 	}
-	config.JavaPath = java_path
+	config.Flavor = flavor
 
-	// Check server.jar
-	server_path := filepath.Clean(config.ExecutablePath)
-	if _, err := os.Stat(server_path); err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("server.jar not found at %s", server_path)
+	// Check Java executable; with -java auto the newest runtime is used
+	// until the jar, and so the Minecraft version, is known
+	if flavor.NeedsJava() {
+		java_path, err := jdk.Resolve(config.JavaPath, "", splitList(*java_dirs))
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("error accessing server.jar: %v", err)
+		config.JavaPath = java_path
+	}
+
+	// Check server.jar
+	server_path, err := resolveServerJar(config)
+	if err != nil {
+		return err
+	}
+	// The server runs in its own directory, so the jar path must not be relative
+	if abs_path, err := filepath.Abs(server_path); err == nil {
+		server_path = abs_path
 	}
 	config.ExecutablePath = server_path
 
+	// Pick the JDK matching the Minecraft version
+	if flavor.NeedsJava() && *java_path == jdk.Auto {
+		minecraft_version := provision.DetectMinecraftVersion(work_dir, server_path)
+		java_path, err := jdk.Resolve(jdk.Auto, minecraft_version, splitList(*java_dirs))
+		if err != nil {
+			return err
+		}
+		config.JavaPath = java_path
+	}
+
 	return nil
 }
 
-// startServer starts the HTTP server with the given configuration.
-func startServer(addr string, handler http.Handler) error {
+// startServer starts the HTTP server with the given configuration, serving
+// HTTPS when tls_config is set.
+func startServer(addr string, handler http.Handler, tls_config *tls.Config) error {
 	// Change from ":8080" to "0.0.0.0:8080" to listen on all interfaces
 	server := &http.Server{
 		Addr:           "0.0.0.0" + addr, // Changed this line
 		Handler:        handler,
+		TLSConfig:      tls_config,
 		ReadTimeout:    15 * time.Second,
 		WriteTimeout:   15 * time.Second,
 		IdleTimeout:    60 * time.Second,
@@ -122,9 +727,101 @@ func startServer(addr string, handler http.Handler) error {
 		return fmt.Errorf("failed to create static directory: %v", err)
 	}
 
+	scheme := "http"
+	if tls_config != nil {
+		scheme = "https"
+	}
+	log.Printf("Starting server on %s://localhost%s", scheme, addr)
 	log.Printf("Server accessible at:")
-	log.Printf("  Local: http://localhost%s", addr)
-	log.Printf("  Network: http://<server-ip>%s", addr)
+	log.Printf("  Local: %s://localhost%s", scheme, addr)
+	log.Printf("  Network: %s://<server-ip>%s", scheme, addr)
 
+	if tls_config != nil {
+		// The certificate comes from TLSConfig.GetCertificate
+		return server.ListenAndServeTLS("", "")
+	}
 	return server.ListenAndServe()
 }
+
+// loadTLS builds the HTTPS configuration from the -tls flags, generating a
+// self-signed certificate if asked to. Returns nil when TLS is off.
+func loadTLS() (*tls.Config, error) {
+	cert_file, key_file := *tls_cert, *tls_key
+	if *tls_self {
+		if cert_file == "" {
+			cert_file = filepath.Join("tls", "cert.pem")
+		}
+		if key_file == "" {
+			key_file = filepath.Join("tls", "key.pem")
+		}
+		hosts := append(certs.DefaultHosts(), splitList(*tls_hosts)...)
+		created, err := certs.EnsureSelfSigned(cert_file, key_file, hosts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate a self-signed certificate: %v", err)
+		}
+		if created {
+			log.Printf("Generated a self-signed certificate for %s in %s", strings.Join(hosts, ", "), cert_file)
+		}
+	}
+	if cert_file == "" && key_file == "" {
+		if *client_ca != "" || *redirect_port != "" {
+			return nil, fmt.Errorf("-tls-client-ca and -http-redirect-port need -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	if cert_file == "" || key_file == "" {
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+
+	reloader, err := certs.NewReloader(cert_file, key_file)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Serving HTTPS with %s (expires %s)", cert_file, reloader.Expires().Format(time.DateOnly))
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if *client_ca != "" {
+		pool, err := certs.LoadCAPool(*client_ca)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		switch *client_auth {
+		case "optional":
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		case "require":
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		default:
+			return nil, fmt.Errorf("invalid -tls-client-auth %q (expected optional or require)", *client_auth)
+		}
+	}
+	return config, nil
+}
+
+// redirectToHTTPS runs a plain HTTP listener sending every request to the
+// same URL over HTTPS on https_port.
+func redirectToHTTPS(addr string, https_port string) {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			host = name
+		}
+		if https_port != "443" {
+			host = net.JoinHostPort(host, https_port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+
+	server := &http.Server{
+		Addr:              "0.0.0.0" + addr,
+		Handler:           redirect,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("Redirecting HTTP on %s to HTTPS", addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("HTTP redirect listener stopped: %v", err)
+	}
+}
@@ -1,3 +1,3 @@
 module minecrap_hoster
 
-go 1.23.0
+go 1.24.0
@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Longest line read back from the log; entries are far smaller
+const maxLineSize = 1 << 20
+
+// Entry records one control action
+type Entry struct {
+	Time     time.Time         `json:"time"`
+	Actor    string            `json:"actor,omitempty"` // Account name; empty when nobody was logged in
+	Token    string            `json:"token,omitempty"` // ID of the API token used, if any
+	IP       string            `json:"ip"`
+	Action   string            `json:"action"` // Endpoint path, or an event name such as "login.locked"
+	Instance string            `json:"instance,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+	Status   int               `json:"status"` // HTTP status of the response
+	Error    string            `json:"error,omitempty"`
+}
+
+// Reports whether the action was refused or failed
+func (e Entry) Failed() bool {
+	return e.Status >= 400
+}
+
+// Filter selects entries; zero fields match everything
+type Filter struct {
+	Actor    string
+	Action   string // Matches actions starting with this
+	Instance string
+	IP       string
+	Since    time.Time
+	Until    time.Time
+	Failed   bool // Only refused or failed actions
+	Limit    int  // Newest entries kept; 0 keeps all
+}
+
+// Reports whether an entry passes the filter
+func (f Filter) Matches(e Entry) bool {
+	switch {
+	case f.Actor != "" && e.Actor != f.Actor:
+		return false
+	case f.Action != "" && !strings.HasPrefix(e.Action, f.Action):
+		return false
+	case f.Instance != "" && e.Instance != f.Instance:
+		return false
+	case f.IP != "" && e.IP != f.IP:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	case f.Failed && !e.Failed():
+		return false
+	}
+	return true
+}
+
+// Log is an append-only JSON Lines file of entries. Entries are never
+// rewritten or removed by the hoster.
+type Log struct {
+	mutex sync.Mutex
+	path  string
+	file  *os.File
+}
+
+// Opens the log at path for appending, creating it if needed
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	return &Log{path: path, file: file}, nil
+}
+
+// Appends an entry, stamping it with the current time if it has none
+func (l *Log) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// One write per entry, so concurrent records never interleave
+	if _, err := l.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
+
+// Returns matching entries, newest first
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	var entries []Entry
+	err := l.scan(filter, func(entry Entry) {
+		entries = append(entries, entry)
+		if filter.Limit > 0 && len(entries) > filter.Limit {
+			entries = entries[1:]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// Writes matching entries as JSON Lines, oldest first
+func (l *Log) Export(w io.Writer, filter Filter) error {
+	entries, err := l.Query(filter)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := encoder.Encode(entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Closes the log file
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+// Reads the log from the start, passing matching entries to fn in order.
+// Lines that don't parse, such as one cut short by a crash, are skipped.
+func (l *Log) scan(filter Filter, fn func(Entry)) error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if filter.Matches(entry) {
+			fn(entry)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,404 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_.@-]{1,64}$`)
+
+// Store keeps the dashboard accounts in a JSON file and the login sessions
+// in memory, so restarting the hoster logs everyone out.
+type Store struct {
+	mutex    sync.Mutex
+	path     string
+	ttl      time.Duration
+	users    map[string]*User
+	sessions map[string]session // Keyed by the SHA-256 of the session token
+
+	challenges map[string]*challenge  // Logins waiting for a second factor, keyed like sessions
+	failures   map[string][]time.Time // Recent wrong second-factor codes per user
+
+	dummyHash string // Verified against when a user doesn't exist, so timing doesn't reveal names
+}
+
+// Loads the accounts from path; sessions last for ttl
+func NewStore(path string, ttl time.Duration) (*Store, error) {
+	dummy, err := HashPassword("not a real password")
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		path:       path,
+		ttl:        ttl,
+		users:      make(map[string]*User),
+		sessions:   make(map[string]session),
+		challenges: make(map[string]*challenge),
+		failures:   make(map[string][]time.Time),
+		dummyHash:  dummy,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users: %v", err)
+	}
+
+	var users []*User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("failed to parse users: %v", err)
+	}
+	for _, user := range users {
+		if _, err := ParseRole(string(user.Role)); err != nil {
+			return nil, fmt.Errorf("user %q: %v", user.Name, err)
+		}
+		s.users[user.Name] = user
+	}
+	return s, nil
+}
+
+// Creates an "admin" account with a random password when there are no
+// accounts yet, returning the password; returns "" otherwise
+func (s *Store) Bootstrap() (string, error) {
+	s.mutex.Lock()
+	empty := len(s.users) == 0
+	s.mutex.Unlock()
+	if !empty {
+		return "", nil
+	}
+
+	password, err := randomToken(12)
+	if err != nil {
+		return "", err
+	}
+	if err := s.AddUser("admin", password, RoleAdmin); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// Checks a name and password
+func (s *Store) Authenticate(name, password string) (User, error) {
+	s.mutex.Lock()
+	user, ok := s.users[name]
+	ok = ok && user.Local()
+	hash := s.dummyHash
+	if ok {
+		hash = user.PasswordHash
+	}
+	s.mutex.Unlock()
+
+	if !VerifyPassword(hash, password) || !ok {
+		return User{}, ErrInvalidCredentials
+	}
+	return redacted(user), nil
+}
+
+// Starts a session for a user, returning its token and expiry
+func (s *Store) NewSession(name string) (string, time.Time, error) {
+	token, err := randomToken(tokenSize)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().Add(s.ttl)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Drop expired sessions so abandoned ones don't pile up
+	now := time.Now()
+	for key, existing := range s.sessions {
+		if now.After(existing.expires) {
+			delete(s.sessions, key)
+		}
+	}
+
+	s.sessions[hashToken(token)] = session{user: name, expires: expires}
+	return token, expires, nil
+}
+
+// Returns the user a session token belongs to, with their current role
+func (s *Store) Session(token string) (User, bool) {
+	if token == "" {
+		return User{}, false
+	}
+	key := hashToken(token)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, ok := s.sessions[key]
+	if !ok {
+		return User{}, false
+	}
+	user, exists := s.users[current.user]
+	if !exists || time.Now().After(current.expires) {
+		delete(s.sessions, key)
+		return User{}, false
+	}
+	return redacted(user), true
+}
+
+// Returns the CSRF token bound to a session token. It is derived rather than
+// stored, and doesn't reveal the session token.
+func CSRFToken(sessionToken string) string {
+	return hashToken("csrf:" + sessionToken)
+}
+
+// Ends a session
+func (s *Store) EndSession(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessions, hashToken(token))
+}
+
+// Returns an account, without its password hash
+func (s *Store) User(name string) (User, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return User{}, false
+	}
+	return redacted(user), true
+}
+
+// Lists the accounts, without password hashes
+func (s *Store) Users() []User {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, redacted(user))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// Creates an account
+func (s *Store) AddUser(name, password string, role Role) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid user name %q", name)
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.users[name]; exists {
+		return fmt.Errorf("user %q already exists", name)
+	}
+	s.users[name] = &User{Name: name, Role: role, PasswordHash: hash, Created: time.Now().UTC()}
+	if err := s.save(); err != nil {
+		delete(s.users, name)
+		return err
+	}
+	log.Printf("Created %s account %s", role, name)
+	return nil
+}
+
+// Creates or updates an account signed in by an identity provider, giving
+// it the role the provider's claims map to. Local accounts are never taken over.
+func (s *Store) SyncExternal(name, source string, role Role) (User, error) {
+	if !namePattern.MatchString(name) {
+		return User{}, fmt.Errorf("invalid user name %q", name)
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return User{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, exists := s.users[name]
+	if exists && user.Source != source {
+		return User{}, fmt.Errorf("account %q already exists and doesn't belong to %s", name, source)
+	}
+	if exists && user.Role == role {
+		return redacted(user), nil
+	}
+
+	if !exists {
+		user = &User{Name: name, Source: source, Created: time.Now().UTC()}
+		s.users[name] = user
+	}
+	previous := user.Role
+	user.Role = role
+	if err := s.save(); err != nil {
+		if exists {
+			user.Role = previous
+		} else {
+			delete(s.users, name)
+		}
+		return User{}, err
+	}
+	if exists {
+		log.Printf("Changed role of %s account %s to %s", source, name, role)
+	} else {
+		log.Printf("Created %s account %s via %s", role, name, source)
+	}
+	return redacted(user), nil
+}
+
+// Deletes an account and ends its sessions; the last admin can't be removed
+func (s *Store) RemoveUser(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return fmt.Errorf("unknown user %q", name)
+	}
+	if user.Role == RoleAdmin && user.Local() && s.adminCount() == 1 {
+		return fmt.Errorf("the last local admin account can't be removed")
+	}
+
+	delete(s.users, name)
+	if err := s.save(); err != nil {
+		s.users[name] = user
+		return err
+	}
+	s.endSessions(name)
+	log.Printf("Removed account %s", name)
+	return nil
+}
+
+// Changes an account's role; the last admin can't be demoted
+func (s *Store) SetRole(name string, role Role) error {
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return fmt.Errorf("unknown user %q", name)
+	}
+	if user.Role == RoleAdmin && role != RoleAdmin && user.Local() && s.adminCount() == 1 {
+		return fmt.Errorf("the last local admin account can't be demoted")
+	}
+
+	previous := user.Role
+	user.Role = role
+	if err := s.save(); err != nil {
+		user.Role = previous
+		return err
+	}
+	log.Printf("Changed role of %s to %s", name, role)
+	return nil
+}
+
+// Changes an account's password and ends all of its sessions
+func (s *Store) SetPassword(name, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return fmt.Errorf("unknown user %q", name)
+	}
+	if !user.Local() {
+		return fmt.Errorf("%s signs in through %s and has no password", name, user.Source)
+	}
+
+	previous := user.PasswordHash
+	user.PasswordHash = hash
+	if err := s.save(); err != nil {
+		user.PasswordHash = previous
+		return err
+	}
+	s.endSessions(name)
+	log.Printf("Changed password of %s", name)
+	return nil
+}
+
+// Counts the admins with a local password, who can still log in when the
+// identity provider is down. Must be called with the mutex held.
+func (s *Store) adminCount() int {
+	count := 0
+	for _, user := range s.users {
+		if user.Role == RoleAdmin && user.Local() {
+			count++
+		}
+	}
+	return count
+}
+
+// Ends a user's sessions and pending logins. Must be called with the mutex held.
+func (s *Store) endSessions(name string) {
+	for key, existing := range s.sessions {
+		if existing.user == name {
+			delete(s.sessions, key)
+		}
+	}
+	for key, existing := range s.challenges {
+		if existing.user == name {
+			delete(s.challenges, key)
+		}
+	}
+}
+
+// Must be called with the mutex held
+func (s *Store) save() error {
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save users: %v", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func redacted(user *User) User {
+	clean := *user
+	clean.PasswordHash = ""
+	clean.TOTPSecret = ""
+	clean.TOTPPending = ""
+	clean.TOTPStep = 0
+	clean.RecoveryCodes = nil
+	return clean
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
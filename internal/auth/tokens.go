@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope limits what an API token may do, on top of its owner's role
+type Scope string
+
+const (
+	ScopeLogsRead      Scope = "logs:read"      // Logs and status
+	ScopeServerControl Scope = "server:control" // Start, stop, restart and shutdown
+	ScopeConsoleWrite  Scope = "console:write"  // Console commands
+	ScopeConfigRead    Scope = "config:read"    // Instances, mods, backups and schedules
+	ScopeConfigWrite   Scope = "config:write"   // Changing any of those
+	ScopeBackupsWrite  Scope = "backups:write"  // Creating and restoring backups
+	ScopeAuditRead     Scope = "audit:read"     // Reading the audit log
+)
+
+var scopes = []Scope{ScopeLogsRead, ScopeServerControl, ScopeConsoleWrite, ScopeConfigRead, ScopeConfigWrite, ScopeBackupsWrite, ScopeAuditRead}
+
+// Returned for tokens that are malformed, revoked, expired or belong to a deleted user
+var ErrInvalidToken = errors.New("invalid or expired API token")
+
+const (
+	tokenPrefix   = "mch_"
+	tokenIDSize   = 8
+	lastUsedDelay = time.Minute // How stale a saved last-used time may get
+)
+
+// Token is an API token for scripts, sent as "Authorization: Bearer <token>"
+type Token struct {
+	ID       string     `json:"id"`
+	User     string     `json:"user"`
+	Name     string     `json:"name"`
+	Scopes   []Scope    `json:"scopes"`
+	Instance string     `json:"instance,omitempty"` // Only this instance may be used, if set
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+	Hash     string     `json:"hash,omitempty"` // SHA-256 of the secret part
+}
+
+// Reports whether the token carries a scope
+func (t Token) Allows(scope Scope) bool {
+	for _, granted := range t.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses a comma-separated scope list
+func ParseScopes(text string) ([]Scope, error) {
+	var parsed []Scope
+	for _, name := range strings.Split(text, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		scope := Scope(name)
+		known := false
+		for _, candidate := range scopes {
+			known = known || candidate == scope
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown scope %q", name)
+		}
+		parsed = append(parsed, scope)
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return parsed, nil
+}
+
+// TokenStore keeps API tokens, hashed, in a JSON file
+type TokenStore struct {
+	mutex  sync.Mutex
+	path   string
+	users  *Store
+	tokens map[string]*Token // Keyed by ID
+	saved  time.Time         // When last-used times were last written
+}
+
+// Loads the tokens from path; tokens of users missing from users are rejected
+func NewTokenStore(path string, users *Store) (*TokenStore, error) {
+	s := &TokenStore{path: path, users: users, tokens: make(map[string]*Token)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens: %v", err)
+	}
+
+	var tokens []*Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens: %v", err)
+	}
+	for _, token := range tokens {
+		s.tokens[token.ID] = token
+	}
+	return s, nil
+}
+
+// Creates a token for a user and returns it with its secret, which is not stored
+func (s *TokenStore) Create(user, name string, scopes []Scope, instance string, ttl time.Duration) (Token, string, error) {
+	if name == "" || len(name) > 64 {
+		return Token{}, "", fmt.Errorf("token name must be 1 to 64 characters")
+	}
+	if _, ok := s.users.User(user); !ok {
+		return Token{}, "", fmt.Errorf("unknown user %q", user)
+	}
+
+	id, err := randomToken(tokenIDSize)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret, err := randomToken(tokenSize)
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	token := &Token{
+		ID:       id,
+		User:     user,
+		Name:     name,
+		Scopes:   scopes,
+		Instance: instance,
+		Created:  time.Now().UTC(),
+		Hash:     hashToken(secret),
+	}
+	if ttl > 0 {
+		expires := token.Created.Add(ttl)
+		token.Expires = &expires
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tokens[id] = token
+	if err := s.save(); err != nil {
+		delete(s.tokens, id)
+		return Token{}, "", err
+	}
+	log.Printf("Created API token %s (%s) for %s", id, name, user)
+	return redactedToken(token), tokenPrefix + id + "." + secret, nil
+}
+
+// Checks a bearer token and returns it with its owner, recording its use
+func (s *TokenStore) Authenticate(bearer string) (Token, User, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(bearer, tokenPrefix), ".")
+	if !ok || !strings.HasPrefix(bearer, tokenPrefix) {
+		return Token{}, User{}, ErrInvalidToken
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, exists := s.tokens[id]
+	if !exists || subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(token.Hash)) != 1 {
+		return Token{}, User{}, ErrInvalidToken
+	}
+	now := time.Now().UTC()
+	if token.Expires != nil && now.After(*token.Expires) {
+		return Token{}, User{}, ErrInvalidToken
+	}
+	user, ok := s.users.User(token.User)
+	if !ok {
+		return Token{}, User{}, ErrInvalidToken
+	}
+
+	token.LastUsed = &now
+	if now.Sub(s.saved) > lastUsedDelay {
+		if err := s.save(); err != nil {
+			log.Printf("Failed to save token last-used times: %v", err)
+		}
+	}
+	return redactedToken(token), user, nil
+}
+
+// Lists a user's tokens, or everyone's when user is empty
+func (s *TokenStore) List(user string) []Token {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var tokens []Token
+	for _, token := range s.tokens {
+		if user == "" || token.User == user {
+			tokens = append(tokens, redactedToken(token))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Created.Before(tokens[j].Created) })
+	return tokens
+}
+
+// Returns a token by ID
+func (s *TokenStore) Get(id string) (Token, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return Token{}, false
+	}
+	return redactedToken(token), true
+}
+
+// Deletes a token
+func (s *TokenStore) Revoke(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return fmt.Errorf("unknown token %q", id)
+	}
+	delete(s.tokens, id)
+	if err := s.save(); err != nil {
+		s.tokens[id] = token
+		return err
+	}
+	log.Printf("Revoked API token %s (%s) of %s", id, token.Name, token.User)
+	return nil
+}
+
+// Must be called with the mutex held
+func (s *TokenStore) save() error {
+	tokens := make([]*Token, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Created.Before(tokens[j].Created) })
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save tokens: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.saved = time.Now()
+	return nil
+}
+
+func redactedToken(token *Token) Token {
+	clean := *token
+	clean.Hash = ""
+	clean.Scopes = append([]Scope(nil), token.Scopes...)
+	return clean
+}
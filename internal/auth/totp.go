@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, the ones every authenticator app supports
+const (
+	totpPeriod      = 30 * time.Second
+	totpDigits      = 6
+	totpSkew        = 1  // Steps accepted either side of now, for clock drift
+	totpSecretSize  = 20 // Bytes, as RFC 4226 recommends
+	recoveryCodes   = 10
+	challengeTTL    = 5 * time.Minute
+	challengeTries  = 5                // Wrong codes before a login challenge is dropped
+	secondFactorMax = 5                // Wrong codes per user before further tries are refused
+	secondFactorWin = 15 * time.Minute // Window those failures are counted in
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Returned when a TOTP or recovery code doesn't match
+var ErrInvalidCode = errors.New("invalid two-factor code")
+
+// Returned when a login waiting for its second factor is unknown, expired or used up
+var ErrLoginExpired = errors.New("the login has expired; log in again")
+
+// LockedError is returned while too many recent failures block an account
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed attempts; try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+// A login that passed the password check and waits for a second factor
+type challenge struct {
+	user    string
+	expires time.Time
+	tries   int
+}
+
+// Returns the otpauth:// URI authenticator apps import, usually from a QR code
+func ProvisioningURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(int(totpPeriod.Seconds()))},
+	}
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Returns the TOTP code of a base32 secret for a time step
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// Checks a code against the secret around now, returning the matching time
+// step. Steps at or before last were already used and are rejected.
+func checkTOTP(secret, code string, last int64, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= last {
+			continue
+		}
+		want, err := totpCode(secret, step)
+		if err == nil && subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Returns a new random TOTP secret in base32
+func newTOTPSecret() (string, error) {
+	buf := make([]byte, totpSecretSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(buf), nil
+}
+
+// Returns fresh recovery codes, formatted like "abcd-efgh", and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodes)
+	hashes := make([]string, recoveryCodes)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(buf))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashToken(normalizeCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+// Strips the spaces and dashes people type into codes
+func normalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Starts a login challenge for a user who passed the password check and
+// must now give a second factor, returning its token and expiry
+func (s *Store) NewChallenge(name string) (string, time.Time, error) {
+	token, err := randomToken(tokenSize)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().Add(challengeTTL)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for key, existing := range s.challenges {
+		if now.After(existing.expires) {
+			delete(s.challenges, key)
+		}
+	}
+	s.challenges[hashToken(token)] = &challenge{user: name, expires: expires}
+	return token, expires, nil
+}
+
+// Checks the second factor of a login challenge. The returned user carries
+// the name even on failure, so the attempt can be attributed.
+func (s *Store) CompleteChallenge(token, code string) (User, error) {
+	key := hashToken(token)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pending, ok := s.challenges[key]
+	if !ok || time.Now().After(pending.expires) {
+		delete(s.challenges, key)
+		return User{}, ErrLoginExpired
+	}
+	user, exists := s.users[pending.user]
+	if !exists || !user.TwoFactor {
+		delete(s.challenges, key)
+		return User{}, ErrLoginExpired
+	}
+
+	if err := s.verifySecondFactor(user, code); err != nil {
+		pending.tries++
+		if pending.tries >= challengeTries {
+			delete(s.challenges, key)
+		}
+		return User{Name: user.Name}, err
+	}
+	delete(s.challenges, key)
+	return redacted(user), nil
+}
+
+// Checks a TOTP or recovery code of a user with two-factor authentication
+func (s *Store) VerifySecondFactor(name, code string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[name]
+	if !ok || !user.TwoFactor {
+		return fmt.Errorf("%s has no two-factor authentication", name)
+	}
+	return s.verifySecondFactor(user, code)
+}
+
+// Creates a TOTP secret for a user to add to their authenticator app. It
+// takes effect once EnableTOTP confirms a code from it.
+func (s *Store) BeginTOTP(name string) (string, error) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return "", fmt.Errorf("unknown user %q", name)
+	}
+	if !user.Local() {
+		return "", fmt.Errorf("%s signs in through %s, which handles two-factor authentication", name, user.Source)
+	}
+	if user.TwoFactor {
+		return "", fmt.Errorf("two-factor authentication is already on; turn it off first")
+	}
+
+	user.TOTPPending = secret
+	if err := s.save(); err != nil {
+		user.TOTPPending = ""
+		return "", err
+	}
+	return secret, nil
+}
+
+// Turns on two-factor authentication once a code from the pending secret
+// checks out, returning the recovery codes
+func (s *Store) EnableTOTP(name, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[name]
+	if !ok || user.TOTPPending == "" {
+		return nil, fmt.Errorf("start the two-factor setup first")
+	}
+	if err := s.lockedOut(name); err != nil {
+		return nil, err
+	}
+	step, ok := checkTOTP(user.TOTPPending, normalizeCode(code), 0, time.Now())
+	if !ok {
+		s.failures[name] = append(s.failures[name], time.Now())
+		return nil, ErrInvalidCode
+	}
+
+	previous := *user
+	user.TwoFactor = true
+	user.TOTPSecret = user.TOTPPending
+	user.TOTPPending = ""
+	user.TOTPStep = step
+	user.RecoveryCodes = hashes
+	if err := s.save(); err != nil {
+		*user = previous
+		return nil, err
+	}
+	delete(s.failures, name)
+	log.Printf("Turned on two-factor authentication for %s", name)
+	return codes, nil
+}
+
+// Turns off two-factor authentication, for a user who proved it's them or
+// an admin resetting a lost authenticator
+func (s *Store) DisableTOTP(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return fmt.Errorf("unknown user %q", name)
+	}
+
+	previous := *user
+	user.TwoFactor = false
+	user.TOTPSecret = ""
+	user.TOTPPending = ""
+	user.TOTPStep = 0
+	user.RecoveryCodes = nil
+	if err := s.save(); err != nil {
+		*user = previous
+		return err
+	}
+	log.Printf("Turned off two-factor authentication for %s", name)
+	return nil
+}
+
+// Replaces a user's recovery codes
+func (s *Store) NewRecoveryCodes(name string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[name]
+	if !ok || !user.TwoFactor {
+		return nil, fmt.Errorf("%s has no two-factor authentication", name)
+	}
+	previous := user.RecoveryCodes
+	user.RecoveryCodes = hashes
+	if err := s.save(); err != nil {
+		user.RecoveryCodes = previous
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Accepts a current TOTP code or an unused recovery code, which is then
+// used up. Must be called with the mutex held.
+func (s *Store) verifySecondFactor(user *User, code string) error {
+	if err := s.lockedOut(user.Name); err != nil {
+		return err
+	}
+	code = normalizeCode(code)
+
+	if step, ok := checkTOTP(user.TOTPSecret, code, user.TOTPStep, time.Now()); ok {
+		previous := user.TOTPStep
+		user.TOTPStep = step
+		if err := s.save(); err != nil {
+			user.TOTPStep = previous
+			return err
+		}
+		delete(s.failures, user.Name)
+		return nil
+	}
+
+	hash := hashToken(code)
+	for i, candidate := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(candidate)) != 1 {
+			continue
+		}
+		previous := user.RecoveryCodes
+		user.RecoveryCodes = append(append([]string(nil), previous[:i]...), previous[i+1:]...)
+		if err := s.save(); err != nil {
+			user.RecoveryCodes = previous
+			return err
+		}
+		delete(s.failures, user.Name)
+		log.Printf("%s used a recovery code, %d left", user.Name, len(user.RecoveryCodes))
+		return nil
+	}
+
+	s.failures[user.Name] = append(s.failures[user.Name], time.Now())
+	return ErrInvalidCode
+}
+
+// Returns a LockedError when the user had too many wrong codes recently.
+// Must be called with the mutex held.
+func (s *Store) lockedOut(name string) error {
+	cutoff := time.Now().Add(-secondFactorWin)
+	recent := s.failures[name][:0]
+	for _, failed := range s.failures[name] {
+		if failed.After(cutoff) {
+			recent = append(recent, failed)
+		}
+	}
+	if len(recent) == 0 {
+		delete(s.failures, name)
+		return nil
+	}
+	s.failures[name] = recent
+	if len(recent) < secondFactorMax {
+		return nil
+	}
+	return &LockedError{RetryAfter: time.Until(recent[0].Add(secondFactorWin))}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Role decides which endpoints a user may call
+type Role string
+
+// Roles in increasing order of privilege; each includes the ones before it
+const (
+	RoleViewer   Role = "viewer"   // Logs and status
+	RoleOperator Role = "operator" // Start, stop and console commands
+	RoleAdmin    Role = "admin"    // Configuration, shutdown and users
+)
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// Returned when a name and password don't match an account
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+const (
+	minPasswordLength = 8
+	kdfIterations     = 600000
+	saltSize          = 16
+	keySize           = 32
+	tokenSize         = 32
+)
+
+// Parses a role name
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown role %q (available: viewer, operator, admin)", name)
+	}
+	return role, nil
+}
+
+// Reports whether the role includes the required one
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// User is a dashboard account
+type User struct {
+	Name         string    `json:"name"`
+	Role         Role      `json:"role"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Source       string    `json:"source,omitempty"` // Identity provider that signs the user in; empty for password accounts
+	TwoFactor    bool      `json:"two_factor"`       // Whether logins need a TOTP or recovery code
+	Created      time.Time `json:"created"`
+
+	TOTPSecret    string   `json:"totp_secret,omitempty"`
+	TOTPPending   string   `json:"totp_pending,omitempty"`   // Secret being set up, until a code confirms it
+	TOTPStep      int64    `json:"totp_step,omitempty"`      // Last accepted time step, so codes can't be replayed
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // SHA-256 hashes of the unused recovery codes
+}
+
+// Reports whether the account logs in with a local password
+func (u User) Local() bool {
+	return u.Source == ""
+}
+
+// A logged-in browser
+type session struct {
+	user    string
+	expires time.Time
+}
+
+type (
+	contextKey      struct{}
+	tokenContextKey struct{}
+)
+
+// Returns a context carrying the authenticated user
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// Returns the authenticated user of a request context, if any
+func UserFrom(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(contextKey{}).(User)
+	return user, ok
+}
+
+// Returns a context recording the API token a request was made with
+func WithToken(ctx context.Context, token Token) context.Context {
+	return context.WithValue(ctx, tokenContextKey{}, token)
+}
+
+// Returns the API token of a request context, if it used one
+func TokenFrom(ctx context.Context) (Token, bool) {
+	token, ok := ctx.Value(tokenContextKey{}).(Token)
+	return token, ok
+}
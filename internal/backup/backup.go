@@ -0,0 +1,357 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"minecrap_hoster/internal/minecraft"
+	"minecrap_hoster/internal/mods"
+)
+
+// Manager owns the configured backup targets and the archives stored in them
+type Manager struct {
+	mutex      sync.Mutex
+	configPath string
+	targets    map[string]Target
+	restoring  atomic.Bool
+}
+
+// Loads backup targets from configPath, falling back to a local target
+func NewManager(configPath string) (*Manager, error) {
+	m := &Manager{
+		configPath: configPath,
+		targets:    make(map[string]Target),
+	}
+
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		target := DefaultTarget()
+		m.targets[target.Name] = target
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup config: %v", err)
+	}
+
+	var targets []Target
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, fmt.Errorf("failed to parse backup config: %v", err)
+	}
+
+	for _, target := range targets {
+		if err := validateTarget(target); err != nil {
+			return nil, fmt.Errorf("backup target %q: %v", target.Name, err)
+		}
+		m.targets[target.Name] = target
+	}
+	return m, nil
+}
+
+func validateTarget(target Target) error {
+	if target.Name == "" || strings.ContainsAny(target.Name, `/\`) {
+		return fmt.Errorf("invalid target name")
+	}
+	if target.Path == "" {
+		return fmt.Errorf("target path must be non-empty")
+	}
+	return validateEncryption(target.Encryption)
+}
+
+// Returns all targets with secrets removed
+func (m *Manager) Targets() []Target {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	targets := make([]Target, 0, len(m.targets))
+	for _, target := range m.targets {
+		target.Encryption.Passphrase = redact(target.Encryption.Passphrase)
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	return targets
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
+
+// Adds or replaces a target and persists the configuration
+func (m *Manager) SaveTarget(target Target) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Keep the stored passphrase when a redacted target is sent back
+	if existing, ok := m.targets[target.Name]; ok && target.Encryption.Passphrase == redact("x") {
+		target.Encryption.Passphrase = existing.Encryption.Passphrase
+	}
+
+	if err := validateTarget(target); err != nil {
+		return err
+	}
+
+	m.targets[target.Name] = target
+	return m.persist()
+}
+
+func (m *Manager) persist() error {
+	targets := make([]Target, 0, len(m.targets))
+	for _, target := range m.targets {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+
+	data, err := json.MarshalIndent(targets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The file can contain passphrases
+	tmp := m.configPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write backup config: %v", err)
+	}
+	return os.Rename(tmp, m.configPath)
+}
+
+func (m *Manager) target(name string) (Target, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	target, ok := m.targets[name]
+	if !ok {
+		return Target{}, fmt.Errorf("unknown backup target %q", name)
+	}
+	return target, nil
+}
+
+// Archives srcDir into the named target, encrypting it if the target requires
+func (m *Manager) Create(targetName, label, srcDir string) (Archive, error) {
+	target, err := m.target(targetName)
+	if err != nil {
+		return Archive{}, err
+	}
+
+	if err := os.MkdirAll(target.Path, 0755); err != nil {
+		return Archive{}, fmt.Errorf("failed to create target directory: %v", err)
+	}
+
+	name := fmt.Sprintf("%s-%s%s", label, time.Now().UTC().Format("20060102-150405"), archiveExt)
+	if target.Encryption.Enabled {
+		name += encryptedExt
+	}
+	path := filepath.Join(target.Path, name)
+
+	log.Printf("Creating backup %s in target %s", name, target.Name)
+	if err := writeBackupFile(path, srcDir, target.Encryption); err != nil {
+		return Archive{}, fmt.Errorf("failed to create backup: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Archive{}, err
+	}
+	return archiveFromInfo(target.Name, info), nil
+}
+
+// Writes the archive to a new file at path, removing it again on failure. An
+// existing file, such as another backup made the same second, is left alone.
+func writeBackupFile(path, srcDir string, config EncryptionConfig) (err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	var dest io.WriteCloser = nopWriteCloser{file}
+	if config.Enabled {
+		dest, err = newEncryptWriter(file, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writeArchive(dest, srcDir); err != nil {
+		return err
+	}
+	if err := dest.Close(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// Lists the archives in a target, newest first
+func (m *Manager) List(targetName string) ([]Archive, error) {
+	target, err := m.target(targetName)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(target.Path)
+	if os.IsNotExist(err) {
+		return []Archive{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	archives := make([]Archive, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, archiveExt) || strings.HasSuffix(name, archiveExt+encryptedExt)) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		archives = append(archives, archiveFromInfo(target.Name, info))
+	}
+
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Created.After(archives[j].Created) })
+	return archives, nil
+}
+
+func archiveFromInfo(target string, info os.FileInfo) Archive {
+	return Archive{
+		Target:    target,
+		Name:      info.Name(),
+		Size:      info.Size(),
+		Created:   info.ModTime().UTC(),
+		Encrypted: isEncryptedName(info.Name()),
+		Kind:      archiveKind(info.Name()),
+	}
+}
+
+func archiveKind(name string) string {
+	if strings.HasPrefix(name, ModsLabel+"-") {
+		return KindMods
+	}
+	return KindWorld
+}
+
+// Replaces destDir with the contents of an archive. The archive is fully
+// extracted and verified before destDir is touched; the previous contents
+// are kept alongside as destDir.before-restore-<timestamp>.
+func (m *Manager) Restore(targetName, archiveName, destDir string) error {
+	target, err := m.target(targetName)
+	if err != nil {
+		return err
+	}
+	if archiveName != filepath.Base(archiveName) {
+		return fmt.Errorf("invalid archive name")
+	}
+
+	file, err := os.Open(filepath.Join(target.Path, archiveName))
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %v", err)
+	}
+	defer file.Close()
+
+	var src io.Reader = file
+	if isEncryptedName(archiveName) {
+		if !target.Encryption.Enabled {
+			return fmt.Errorf("backup is encrypted but target %q has no key configured", target.Name)
+		}
+		src, err = newDecryptReader(file, target.Encryption)
+		if err != nil {
+			return err
+		}
+	}
+
+	staging := destDir + ".restoring"
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := extractArchive(src, staging); err != nil {
+		os.RemoveAll(staging)
+		return fmt.Errorf("failed to restore backup: %v", err)
+	}
+
+	if _, err := os.Stat(destDir); err == nil {
+		previous := fmt.Sprintf("%s.before-restore-%s", destDir, time.Now().UTC().Format("20060102-150405"))
+		if err := os.Rename(destDir, previous); err != nil {
+			os.RemoveAll(staging)
+			return fmt.Errorf("failed to move current directory aside: %v", err)
+		}
+		log.Printf("Previous contents of %s moved to %s", destDir, previous)
+	}
+
+	if err := os.Rename(staging, destDir); err != nil {
+		return fmt.Errorf("failed to move restored directory into place: %v", err)
+	}
+
+	log.Printf("Restored %s from %s", destDir, archiveName)
+	return nil
+}
+
+// Backs up the server's world, flushing it to disk first if the server is running
+func (m *Manager) BackupWorld(server *minecraft.MinecraftServer, targetName string) (Archive, error) {
+	if server.Status == minecraft.Running {
+		if err := server.SaveWorld(60 * time.Second); err != nil {
+			return Archive{}, fmt.Errorf("failed to save world: %v", err)
+		}
+		defer server.ResumeSaving()
+	}
+
+	worldPath := server.WorldPath()
+	return m.Create(targetName, filepath.Base(worldPath), worldPath)
+}
+
+// Restores the server's world; the server must be stopped
+func (m *Manager) RestoreWorld(server *minecraft.MinecraftServer, targetName, archiveName string) error {
+	return m.restoreKind(server, targetName, archiveName, KindWorld, server.WorldPath())
+}
+
+// Backs up the server's mods directory ahead of a change to it
+func (m *Manager) BackupMods(server *minecraft.MinecraftServer, targetName string) (Archive, error) {
+	return m.Create(targetName, ModsLabel, filepath.Join(server.Dir(), mods.ModsDir))
+}
+
+// Restores the server's mods directory from a mods archive; the server must be stopped
+func (m *Manager) RestoreMods(server *minecraft.MinecraftServer, targetName, archiveName string) error {
+	return m.restoreKind(server, targetName, archiveName, KindMods, filepath.Join(server.Dir(), mods.ModsDir))
+}
+
+func (m *Manager) restoreKind(server *minecraft.MinecraftServer, targetName, archiveName, kind, destDir string) error {
+	// Taken before the status check, so a start from then on sees it
+	if !m.restoring.CompareAndSwap(false, true) {
+		return ErrRestoring
+	}
+	defer m.restoring.Store(false)
+
+	if server.Status != minecraft.Stopped {
+		return fmt.Errorf("server must be stopped to restore a backup")
+	}
+	if got := archiveKind(archiveName); got != kind {
+		return fmt.Errorf("%s is a %s backup, not a %s backup", archiveName, got, kind)
+	}
+	return m.Restore(targetName, archiveName, destDir)
+}
+
+// Reports whether a world or mods restore is running
+func (m *Manager) Restoring() bool {
+	return m.restoring.Load()
+}
@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"bytes"
+	"minecrap_hoster/internal/minecraft"
+	"minecrap_hoster/internal/mods"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Returns a stopped server whose directory holds the test manager's world
+func newTestServer(world string) *minecraft.MinecraftServer {
+	return minecraft.NewServer(minecraft.ServerConfig{
+		JavaPath:            "java",
+		ExecutablePath:      "server.jar",
+		WorkingDir:          filepath.Dir(world),
+		MemoryUtilizationMB: 1024,
+		MaxLogLines:         10,
+	})
+}
+
+func TestModsArchivesRestoreOnlyToMods(t *testing.T) {
+	m, world := newTestManager(t, keyFileConfig(t))
+	server := newTestServer(world)
+	modsDir := filepath.Join(server.Dir(), mods.ModsDir)
+	jar := randomBytes(t, 1000)
+	if err := os.MkdirAll(modsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(modsDir, "example.jar"), jar, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	modsArchive, err := m.BackupMods(server, "enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	worldArchive, err := m.BackupWorld(server, "enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modsArchive.Kind != KindMods || worldArchive.Kind != KindWorld {
+		t.Fatalf("wrong kinds: mods archive %q, world archive %q", modsArchive.Kind, worldArchive.Kind)
+	}
+
+	if err := m.RestoreWorld(server, "enc", modsArchive.Name); err == nil {
+		t.Fatal("a mods archive was restored over the world")
+	}
+	if _, err := os.Stat(filepath.Join(world, "level.dat")); err != nil {
+		t.Fatalf("world touched by the refused restore: %v", err)
+	}
+	if err := m.RestoreMods(server, "enc", worldArchive.Name); err == nil {
+		t.Fatal("a world archive was restored over the mods")
+	}
+
+	if err := os.Remove(filepath.Join(modsDir, "example.jar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RestoreMods(server, "enc", modsArchive.Name); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(modsDir, "example.jar"))
+	if err != nil || !bytes.Equal(got, jar) {
+		t.Errorf("mods not restored intact: %v", err)
+	}
+}
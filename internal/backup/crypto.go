@@ -0,0 +1,390 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encrypted archives start with a fixed header followed by a sequence of
+// length-prefixed AES-256-GCM chunks. Each chunk is authenticated together
+// with the header, its index and a final-chunk flag, so reordering,
+// truncation and header tampering are all detected on restore.
+//
+//	magic[8] kdf[1] iterations[4] chunkSize[4] salt[16] nonce[12] keyCheck[16]
+//	{ length[4] ciphertext[length] } ...
+var encMagic = [8]byte{'M', 'C', 'H', 'B', 'A', 'K', 0, 1}
+
+const (
+	kdfPassphrase byte = 1
+	kdfKeyFile    byte = 2
+
+	headerSize   = 8 + 1 + 4 + 4 + 16 + 12 + 16
+	saltSize     = 16
+	nonceSize    = 12
+	keyCheckSize = 16
+)
+
+type encHeader struct {
+	kdf        byte
+	iterations uint32
+	chunkSize  uint32
+	salt       [saltSize]byte
+	nonce      [nonceSize]byte
+	keyCheck   [keyCheckSize]byte
+}
+
+func (h *encHeader) marshal() []byte {
+	buf := make([]byte, 0, headerSize)
+	buf = append(buf, encMagic[:]...)
+	buf = append(buf, h.kdf)
+	buf = binary.BigEndian.AppendUint32(buf, h.iterations)
+	buf = binary.BigEndian.AppendUint32(buf, h.chunkSize)
+	buf = append(buf, h.salt[:]...)
+	buf = append(buf, h.nonce[:]...)
+	buf = append(buf, h.keyCheck[:]...)
+	return buf
+}
+
+func parseHeader(buf []byte) (*encHeader, error) {
+	if len(buf) != headerSize || !bytes.Equal(buf[:8], encMagic[:]) {
+		return nil, fmt.Errorf("not an encrypted backup")
+	}
+
+	h := &encHeader{kdf: buf[8]}
+	h.iterations = binary.BigEndian.Uint32(buf[9:13])
+	h.chunkSize = binary.BigEndian.Uint32(buf[13:17])
+	copy(h.salt[:], buf[17:33])
+	copy(h.nonce[:], buf[33:45])
+	copy(h.keyCheck[:], buf[45:61])
+
+	if h.chunkSize == 0 || h.chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d", h.chunkSize)
+	}
+	if h.kdf == kdfPassphrase && (h.iterations < kdfIterations || h.iterations > maxKDFIterations) {
+		return nil, fmt.Errorf("invalid key derivation iterations %d", h.iterations)
+	}
+	return h, nil
+}
+
+// Resolves the passphrase or key file configured for a target
+func (c EncryptionConfig) keyMaterial() (byte, []byte, error) {
+	switch {
+	case c.KeyFile != "":
+		data, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to read key file: %v", err)
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) < 32 {
+			return 0, nil, fmt.Errorf("key file must contain at least 32 bytes")
+		}
+		return kdfKeyFile, data, nil
+
+	case c.PassphraseEnv != "":
+		passphrase := os.Getenv(c.PassphraseEnv)
+		if passphrase == "" {
+			return 0, nil, fmt.Errorf("environment variable %s is empty", c.PassphraseEnv)
+		}
+		return kdfPassphrase, []byte(passphrase), nil
+
+	case c.Passphrase != "":
+		return kdfPassphrase, []byte(c.Passphrase), nil
+	}
+	return 0, nil, fmt.Errorf("encryption enabled but no passphrase or key file configured")
+}
+
+func (c EncryptionConfig) chunkSize() int {
+	if c.ChunkSize <= 0 {
+		return defaultChunkSize
+	}
+	if c.ChunkSize > maxChunkSize {
+		return maxChunkSize
+	}
+	return c.ChunkSize
+}
+
+func validateEncryption(c EncryptionConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	_, _, err := c.keyMaterial()
+	return err
+}
+
+// Derives the encryption key and key-check value from the header parameters
+func deriveKeys(kdf byte, material []byte, h *encHeader) ([]byte, []byte, error) {
+	var master []byte
+	switch kdf {
+	case kdfPassphrase:
+		key, err := pbkdf2.Key(sha256.New, string(material), h.salt[:], int(h.iterations), 32)
+		if err != nil {
+			return nil, nil, err
+		}
+		master = key
+	case kdfKeyFile:
+		mac := hmac.New(sha256.New, h.salt[:])
+		mac.Write(material)
+		master = mac.Sum(nil)
+	default:
+		return nil, nil, fmt.Errorf("unknown key derivation %d", kdf)
+	}
+
+	return subkey(master, "encrypt"), subkey(master, "key-check")[:keyCheckSize], nil
+}
+
+func subkey(master []byte, label string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte("minecrap_hoster backup " + label))
+	return mac.Sum(nil)
+}
+
+func chunkNonce(base [nonceSize]byte, index uint64) []byte {
+	nonce := base
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], index)
+	for i := range counter {
+		nonce[nonceSize-8+i] ^= counter[i]
+	}
+	return nonce[:]
+}
+
+func chunkAAD(header []byte, index uint64, final bool) []byte {
+	aad := make([]byte, 0, len(header)+9)
+	aad = append(aad, header...)
+	aad = binary.BigEndian.AppendUint64(aad, index)
+	if final {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptWriter buffers plaintext and writes it out as sealed chunks
+type encryptWriter struct {
+	dest   io.Writer
+	aead   cipher.AEAD
+	header []byte
+	nonce  [nonceSize]byte
+	buf    []byte
+	index  uint64
+	closed bool
+}
+
+// Wraps dest so that everything written is encrypted with the target's key
+func newEncryptWriter(dest io.Writer, config EncryptionConfig) (io.WriteCloser, error) {
+	kdf, material, err := config.keyMaterial()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &encHeader{kdf: kdf, chunkSize: uint32(config.chunkSize())}
+	if kdf == kdfPassphrase {
+		h.iterations = kdfIterations
+	}
+	if _, err := rand.Read(h.salt[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(h.nonce[:]); err != nil {
+		return nil, err
+	}
+
+	key, check, err := deriveKeys(kdf, material, h)
+	if err != nil {
+		return nil, err
+	}
+	copy(h.keyCheck[:], check)
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := h.marshal()
+	if _, err := dest.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		dest:   dest,
+		aead:   aead,
+		header: header,
+		nonce:  h.nonce,
+		buf:    make([]byte, 0, h.chunkSize),
+	}, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to closed encrypt writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := min(cap(w.buf)-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		// Only flush once more data arrives, so the last chunk can be marked final
+		if len(w.buf) == cap(w.buf) && len(p) > 0 {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *encryptWriter) flush(final bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.nonce, w.index), w.buf, chunkAAD(w.header, w.index, final))
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := w.dest.Write(length[:]); err != nil {
+		return err
+	}
+	if _, err := w.dest.Write(sealed); err != nil {
+		return err
+	}
+
+	w.index++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Seals the final chunk; the underlying writer is left open
+func (w *encryptWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+// decryptReader verifies and decrypts chunks as they are read
+type decryptReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	header []byte
+	nonce  [nonceSize]byte
+	max    int
+	buf    []byte
+	index  uint64
+	done   bool
+}
+
+// Opens an encrypted archive stream, failing with ErrWrongKey on a key mismatch
+func newDecryptReader(src io.Reader, config EncryptionConfig) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %v", err)
+	}
+
+	h, err := parseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	kdf, material, err := config.keyMaterial()
+	if err != nil {
+		return nil, err
+	}
+	if kdf != h.kdf {
+		if h.kdf == kdfKeyFile {
+			return nil, fmt.Errorf("%w: backup was encrypted with a key file", ErrWrongKey)
+		}
+		return nil, fmt.Errorf("%w: backup was encrypted with a passphrase", ErrWrongKey)
+	}
+
+	key, check, err := deriveKeys(kdf, material, h)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(check, h.keyCheck[:]) {
+		return nil, ErrWrongKey
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		src:    src,
+		aead:   aead,
+		header: header,
+		nonce:  h.nonce,
+		max:    int(h.chunkSize) + aead.Overhead(),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *decryptReader) nextChunk() error {
+	var length [4]byte
+	if _, err := io.ReadFull(r.src, length[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("backup is truncated")
+		}
+		return err
+	}
+
+	size := int(binary.BigEndian.Uint32(length[:]))
+	if size < r.aead.Overhead() || size > r.max {
+		return fmt.Errorf("corrupt chunk %d", r.index)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r.src, sealed); err != nil {
+		return fmt.Errorf("backup is truncated")
+	}
+
+	nonce := chunkNonce(r.nonce, r.index)
+	plain, err := r.aead.Open(nil, nonce, sealed, chunkAAD(r.header, r.index, false))
+	if err != nil {
+		plain, err = r.aead.Open(nil, nonce, sealed, chunkAAD(r.header, r.index, true))
+		if err != nil {
+			return fmt.Errorf("chunk %d failed authentication", r.index)
+		}
+		r.done = true
+	}
+
+	r.index++
+	r.buf = plain
+	return nil
+}
+
+func isEncryptedName(name string) bool {
+	return strings.HasSuffix(name, encryptedExt)
+}
@@ -0,0 +1,368 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Small chunks so a few kilobytes span many of them
+const testChunkSize = 256
+
+func passphraseConfig(passphrase string) EncryptionConfig {
+	return EncryptionConfig{Enabled: true, Passphrase: passphrase, ChunkSize: testChunkSize}
+}
+
+// Returns a config using a fresh random key file
+func keyFileConfig(t *testing.T) EncryptionConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "backup.key")
+	if err := os.WriteFile(path, randomBytes(t, 48), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return EncryptionConfig{Enabled: true, KeyFile: path, ChunkSize: testChunkSize}
+}
+
+func randomBytes(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encrypt(t *testing.T, plain []byte, config EncryptionConfig) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newEncryptWriter(&buf, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(data []byte, config EncryptionConfig) ([]byte, error) {
+	r, err := newDecryptReader(bytes.NewReader(data), config)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// Returns the offset of each length-prefixed chunk after the header
+func chunkOffsets(t *testing.T, data []byte) []int {
+	t.Helper()
+	var offsets []int
+	for offset := headerSize; offset < len(data); {
+		offsets = append(offsets, offset)
+		offset += 4 + int(binary.BigEndian.Uint32(data[offset:]))
+	}
+	return offsets
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	config := keyFileConfig(t)
+	for _, size := range []int{0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, 10*testChunkSize + 17} {
+		plain := randomBytes(t, size)
+		got, err := decrypt(encrypt(t, plain, config), config)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: decrypted data differs", size)
+		}
+	}
+}
+
+func TestEncryptRoundTripPassphrase(t *testing.T) {
+	config := passphraseConfig("correct horse battery staple")
+	plain := randomBytes(t, 3*testChunkSize)
+
+	data := encrypt(t, plain, config)
+	if bytes.Contains(data, plain[:32]) {
+		t.Fatal("plaintext appears in the archive")
+	}
+	got, err := decrypt(data, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Error("decrypted data differs")
+	}
+}
+
+func TestDecryptRejectsWrongKey(t *testing.T) {
+	passphrase := encrypt(t, []byte("world"), passphraseConfig("right"))
+	keyFile := encrypt(t, []byte("world"), keyFileConfig(t))
+
+	tests := []struct {
+		name   string
+		data   []byte
+		config EncryptionConfig
+	}{
+		{"wrong passphrase", passphrase, passphraseConfig("wrong")},
+		{"key file for a passphrase archive", passphrase, keyFileConfig(t)},
+		{"wrong key file", keyFile, keyFileConfig(t)},
+		{"passphrase for a key file archive", keyFile, passphraseConfig("right")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decrypt(test.data, test.config); !errors.Is(err, ErrWrongKey) {
+				t.Fatalf("expected ErrWrongKey, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecryptDetectsTampering(t *testing.T) {
+	config := keyFileConfig(t)
+	original := encrypt(t, randomBytes(t, 4*testChunkSize+10), config)
+	offsets := chunkOffsets(t, original)
+	if len(offsets) != 5 {
+		t.Fatalf("expected 5 chunks, got %d", len(offsets))
+	}
+
+	flip := func(offset int) []byte {
+		data := bytes.Clone(original)
+		data[offset] ^= 0x01
+		return data
+	}
+
+	// Seals the same plaintext without marking the last chunk final
+	unfinished := func() []byte {
+		var buf bytes.Buffer
+		w, err := newEncryptWriter(&buf, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(randomBytes(t, 2*testChunkSize+10))
+		if err := w.(*encryptWriter).flush(false); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"flipped magic", flip(0)},
+		{"flipped chunk size", flip(16)},
+		{"flipped salt", flip(20)},
+		{"flipped nonce", flip(40)},
+		{"flipped key check", flip(50)},
+		{"flipped chunk length", flip(offsets[1] + 3)},
+		{"flipped ciphertext", flip(offsets[2] + 10)},
+		{"flipped tag of the final chunk", flip(len(original) - 1)},
+		{"swapped chunks", func() []byte {
+			first := original[offsets[0]:offsets[1]]
+			second := original[offsets[1]:offsets[2]]
+			data := bytes.Clone(original[:offsets[0]])
+			data = append(data, second...)
+			data = append(data, first...)
+			return append(data, original[offsets[2]:]...)
+		}()},
+		{"last chunk dropped", original[:offsets[4]]},
+		{"cut inside a chunk", original[:offsets[2]+20]},
+		{"cut inside the header", original[:headerSize-1]},
+		{"final chunk not marked final", unfinished()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decrypt(test.data, config); err == nil {
+				t.Fatal("tampered archive decrypted without error")
+			}
+		})
+	}
+}
+
+func TestParseHeaderBoundsIterations(t *testing.T) {
+	tests := []struct {
+		kdf        byte
+		iterations uint32
+		valid      bool
+	}{
+		{kdfPassphrase, kdfIterations, true},
+		{kdfPassphrase, maxKDFIterations, true},
+		{kdfPassphrase, kdfIterations - 1, false},
+		{kdfPassphrase, maxKDFIterations + 1, false},
+		{kdfPassphrase, 0, false},
+		{kdfPassphrase, ^uint32(0), false},
+		{kdfKeyFile, 0, true},
+	}
+	for _, test := range tests {
+		h := encHeader{kdf: test.kdf, iterations: test.iterations, chunkSize: testChunkSize}
+		_, err := parseHeader(h.marshal())
+		if (err == nil) != test.valid {
+			t.Errorf("kdf %d with %d iterations: got %v", test.kdf, test.iterations, err)
+		}
+	}
+}
+
+func TestDecryptRejectsExcessiveIterations(t *testing.T) {
+	config := passphraseConfig("right")
+	data := encrypt(t, []byte("world"), config)
+	binary.BigEndian.PutUint32(data[9:13], ^uint32(0))
+
+	// Refused from the header alone, before deriving a key with that many rounds
+	_, err := decrypt(data, config)
+	if err == nil || !strings.Contains(err.Error(), "invalid key derivation iterations") {
+		t.Fatalf("expected the iteration count to be refused, got %v", err)
+	}
+}
+
+// Creates a manager with one encrypted target and a world to back up
+func newTestManager(t *testing.T, config EncryptionConfig) (*Manager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	m, err := NewManager(filepath.Join(dir, "backups.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SaveTarget(Target{Name: "enc", Path: filepath.Join(dir, "archives"), Encryption: config}); err != nil {
+		t.Fatal(err)
+	}
+
+	world := filepath.Join(dir, "world")
+	files := map[string][]byte{
+		"level.dat":          randomBytes(t, 500),
+		"region/r.0.0.mca":   randomBytes(t, 5000),
+		"data/raids.dat":     []byte("raids"),
+		"playerdata/a.dat":   randomBytes(t, 300),
+		"region/r.-1.0.mca":  randomBytes(t, 2000),
+		"datapacks/pack.zip": nil,
+	}
+	for name, content := range files {
+		path := filepath.Join(world, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m, world
+}
+
+func TestRestoreRoundTrip(t *testing.T) {
+	m, world := newTestManager(t, keyFileConfig(t))
+	archive, err := m.Create("enc", "world", world)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !archive.Encrypted {
+		t.Fatal("archive in an encrypted target is not encrypted")
+	}
+
+	dest := filepath.Join(t.TempDir(), "restored")
+	if err := m.Restore("enc", archive.Name, dest); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"level.dat", "region/r.0.0.mca", "data/raids.dat", "playerdata/a.dat", "region/r.-1.0.mca", "datapacks/pack.zip"} {
+		want, _ := os.ReadFile(filepath.Join(world, filepath.FromSlash(name)))
+		got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s not restored intact: %v", name, err)
+		}
+	}
+}
+
+func TestRestoreTruncatedLeavesDestination(t *testing.T) {
+	m, world := newTestManager(t, keyFileConfig(t))
+	archive, err := m.Create("enc", "world", world)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(filepath.Dir(world), "archives", archive.Name)
+	original, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := chunkOffsets(t, original)
+	last := offsets[len(offsets)-1]
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"header only", headerSize},
+		{"cut inside the first chunk", offsets[0] + 50},
+		{"cut inside the last chunk", last + 10},
+		{"last chunk dropped", last},
+		{"last byte dropped", len(original) - 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := os.WriteFile(path, original[:test.size], 0600); err != nil {
+				t.Fatal(err)
+			}
+			dest := filepath.Join(t.TempDir(), "world")
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dest, "level.dat"), []byte("current"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := m.Restore("enc", archive.Name, dest); err == nil {
+				t.Fatal("truncated archive restored without error")
+			}
+			if data, err := os.ReadFile(filepath.Join(dest, "level.dat")); err != nil || string(data) != "current" {
+				t.Errorf("current world was touched: %q %v", data, err)
+			}
+			entries, _ := os.ReadDir(filepath.Dir(dest))
+			if len(entries) != 1 {
+				t.Errorf("restore left %d entries beside the world", len(entries)-1)
+			}
+		})
+	}
+}
+
+// Dropping a final chunk that holds only the end of the gzip stream leaves a
+// complete tarball, so the restore must read the archive to its end
+func TestRestoreDetectsDroppedTrailer(t *testing.T) {
+	config := keyFileConfig(t)
+	m, world := newTestManager(t, config)
+
+	var compressed bytes.Buffer
+	if err := writeArchive(&compressed, world); err != nil {
+		t.Fatal(err)
+	}
+	gzipTrailer := 8
+	config.ChunkSize = compressed.Len() - gzipTrailer
+	data := encrypt(t, compressed.Bytes(), config)
+	offsets := chunkOffsets(t, data)
+	if len(offsets) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(offsets))
+	}
+
+	name := "world-20260101-000000" + archiveExt + encryptedExt
+	path := filepath.Join(filepath.Dir(world), "archives", name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:offsets[1]], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "world")
+	if err := m.Restore("enc", name, dest); err == nil {
+		t.Fatal("archive without its final chunk restored without error")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("restore created the destination")
+	}
+}
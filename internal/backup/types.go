@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"errors"
+	"time"
+)
+
+// Returned when an encrypted archive is opened with the wrong key
+var ErrWrongKey = errors.New("wrong encryption key for this backup")
+
+// Returned when a restore is started while another one is running; the
+// server can't start until it is done
+var ErrRestoring = errors.New("a backup restore is in progress")
+
+// EncryptionConfig describes how archives written to a target are encrypted
+type EncryptionConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Passphrase    string `json:"passphrase,omitempty"`     // Passphrase stretched with PBKDF2
+	PassphraseEnv string `json:"passphrase_env,omitempty"` // Environment variable holding the passphrase
+	KeyFile       string `json:"key_file,omitempty"`       // File with at least 32 bytes of key material
+	ChunkSize     int    `json:"chunk_size,omitempty"`     // Plaintext bytes per encrypted chunk
+}
+
+// Target is a destination directory that backups are written to
+type Target struct {
+	Name       string           `json:"name"`
+	Path       string           `json:"path"`
+	Encryption EncryptionConfig `json:"encryption"`
+}
+
+// Archive describes a single backup stored in a target
+type Archive struct {
+	Target    string    `json:"target"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Created   time.Time `json:"created"`
+	Encrypted bool      `json:"encrypted"`
+	Kind      string    `json:"kind"` // What the archive holds: KindWorld or KindMods
+}
+
+// Archive kinds, told apart by their label
+const (
+	KindWorld = "world"
+	KindMods  = "mods"
+)
+
+// Label of the mods archives taken before mod updates
+const ModsLabel = "mods-pre-update"
+
+const (
+	archiveExt   = ".tar.gz"
+	encryptedExt = ".enc"
+
+	defaultChunkSize = 1 << 20 // 1MB
+	maxChunkSize     = 16 << 20
+	kdfIterations    = 600000
+	maxKDFIterations = 10 * kdfIterations // Bounds the work a tampered header can cause on restore
+)
+
+// DefaultTarget returns the target used when no configuration file exists
+func DefaultTarget() Target {
+	return Target{
+		Name: "local",
+		Path: "backups",
+	}
+}
@@ -0,0 +1,118 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the certificate files are checked for changes, at most
+const checkInterval = 10 * time.Second
+
+// Reloader serves a certificate and key from disk, picking up new files as
+// soon as they change, so externally renewed certificates apply without a restart
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mutex    sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time // Modification times of the loaded files
+	keyTime  time.Time
+	checked  time.Time
+}
+
+// Loads the certificate and key
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Returns the current certificate, for tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if time.Since(r.checked) >= checkInterval {
+		r.checked = time.Now()
+		if r.changed() {
+			// A failed reload, say while the files are half written, keeps the old certificate
+			if err := r.loadLocked(); err != nil {
+				log.Printf("Failed to reload TLS certificate, keeping the old one: %v", err)
+			} else {
+				log.Printf("Reloaded TLS certificate from %s", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// Returns when the current certificate expires
+func (r *Reloader) Expires() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.cert.Leaf.NotAfter
+}
+
+func (r *Reloader) load() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.loadLocked()
+}
+
+// Must be called with the mutex held
+func (r *Reloader) loadLocked() error {
+	certInfo, err1 := os.Stat(r.certFile)
+	keyInfo, err2 := os.Stat(r.keyFile)
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("TLS certificate or key missing: %s, %s", r.certFile, r.keyFile)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse TLS certificate: %v", err)
+		}
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		log.Printf("Warning: TLS certificate %s expired on %s", r.certFile, cert.Leaf.NotAfter.Format(time.DateOnly))
+	}
+
+	r.cert = &cert
+	r.certTime = certInfo.ModTime()
+	r.keyTime = keyInfo.ModTime()
+	return nil
+}
+
+// Reports whether either file changed since it was loaded. Must be called with the mutex held.
+func (r *Reloader) changed() bool {
+	certInfo, err1 := os.Stat(r.certFile)
+	keyInfo, err2 := os.Stat(r.keyFile)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(r.certTime) || !keyInfo.ModTime().Equal(r.keyTime)
+}
+
+// Loads a PEM bundle of certificate authorities for verifying client certificates
+func LoadCAPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
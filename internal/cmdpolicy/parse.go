@@ -0,0 +1,107 @@
+package cmdpolicy
+
+import "strings"
+
+// A single command split into its root and argument string
+type command struct {
+	root string
+	args string
+}
+
+func (c command) String() string {
+	if c.args == "" {
+		return c.root
+	}
+	return c.root + " " + c.args
+}
+
+// Splits console input into the commands it runs. Each line is a command of
+// its own, since the server reads its console line by line, and
+// "execute ... run" and "return run" hand the rest of the line to another
+// command, which is checked as well.
+func expand(input string) []command {
+	var commands []command
+	for _, line := range strings.FieldsFunc(input, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		commands = append(commands, expandLine(line)...)
+	}
+	return commands
+}
+
+func expandLine(line string) []command {
+	line = strings.TrimLeft(strings.TrimSpace(line), "/")
+	fields, balanced := tokenize(line)
+	if !balanced {
+		// The server won't parse this either, but check every "run" in it to be safe
+		fields = strings.Fields(line)
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	root := strings.ToLower(fields[0])
+	if _, name, ok := strings.Cut(root, ":"); ok {
+		root = name // minecraft:op runs op
+	}
+
+	var runs []int
+	if root == "execute" || root == "return" {
+		for i := 1; i < len(fields); i++ {
+			if strings.EqualFold(fields[i], "run") {
+				runs = append(runs, i)
+				if balanced {
+					break
+				}
+			}
+		}
+	}
+	if len(runs) == 0 {
+		return []command{{root: root, args: strings.Join(fields[1:], " ")}}
+	}
+
+	commands := []command{{root: root, args: strings.Join(fields[1:runs[0]], " ")}}
+	for _, run := range runs {
+		commands = append(commands, expandLine(strings.Join(fields[run+1:], " "))...)
+	}
+	return commands
+}
+
+// Splits a command on spaces outside quotes, selectors like @e[name="a b"]
+// and NBT or JSON like {"text":"a b"}, so a "run" inside them isn't mistaken
+// for a subcommand. Reports whether every quote and bracket was closed.
+func tokenize(line string) ([]string, bool) {
+	var fields []string
+	var current strings.Builder
+	depth := 0
+	var quote rune
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if r == '\\' {
+				escaped = true
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[' || r == '{':
+			depth++
+		case (r == ']' || r == '}') && depth > 0:
+			depth--
+		case (r == ' ' || r == '\t') && depth == 0:
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+	return fields, depth == 0 && quote == 0
+}
@@ -0,0 +1,122 @@
+package cmdpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"minecrap_hoster/internal/auth"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Policy decides which console commands each role may send. Roles without
+// rules may send anything.
+type Policy struct {
+	roles map[auth.Role]RolePolicy
+}
+
+// Returns the policy used without a policy file: operators can't change who
+// is an operator or stop the server from the console
+func Default() *Policy {
+	policy, err := build(map[auth.Role]RolePolicy{
+		auth.RoleOperator: {Rules: []Rule{
+			{Action: Deny, Command: "op"},
+			{Action: Deny, Command: "deop"},
+			{Action: Deny, Command: "stop"},
+		}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+// Loads a policy file, a JSON object of role names to their rules. A missing
+// file gives the default policy.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Default(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command policy: %v", err)
+	}
+
+	var roles map[auth.Role]RolePolicy
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, fmt.Errorf("failed to parse command policy: %v", err)
+	}
+	return build(roles)
+}
+
+// Validates the rules and compiles their argument patterns
+func build(roles map[auth.Role]RolePolicy) (*Policy, error) {
+	for role, policy := range roles {
+		if _, err := auth.ParseRole(string(role)); err != nil {
+			return nil, err
+		}
+		if policy.Default != "" && policy.Default != Allow && policy.Default != Deny {
+			return nil, fmt.Errorf("%s: default must be allow or deny, not %q", role, policy.Default)
+		}
+
+		rules := make([]Rule, len(policy.Rules))
+		for i, rule := range policy.Rules {
+			if rule.Action != Allow && rule.Action != Deny {
+				return nil, fmt.Errorf("%s rule %d: action must be allow or deny, not %q", role, i+1, rule.Action)
+			}
+			rule.Command = strings.ToLower(strings.TrimLeft(strings.TrimSpace(rule.Command), "/"))
+			if _, name, ok := strings.Cut(rule.Command, ":"); ok {
+				rule.Command = name
+			}
+			if rule.Command == "" {
+				return nil, fmt.Errorf("%s rule %d: no command", role, i+1)
+			}
+			if rule.Args != "" {
+				pattern, err := regexp.Compile("^(?:" + rule.Args + ")$")
+				if err != nil {
+					return nil, fmt.Errorf("%s rule %d: invalid args pattern: %v", role, i+1, err)
+				}
+				rule.args = pattern
+			}
+			rules[i] = rule
+		}
+		policy.Rules = rules
+		roles[role] = policy
+	}
+	return &Policy{roles: roles}, nil
+}
+
+// Checks console input sent by a user with the given role. Every command it
+// runs, including those nested in execute chains, must be allowed.
+func (p *Policy) Check(role auth.Role, input string) Decision {
+	policy, ok := p.roles[role]
+	if !ok {
+		return Decision{Allowed: true}
+	}
+
+	for _, cmd := range expand(input) {
+		decision := policy.decide(cmd)
+		if !decision.Allowed {
+			return decision
+		}
+	}
+	return Decision{Allowed: true}
+}
+
+// Applies the first matching rule, or the default, to one command
+func (p RolePolicy) decide(cmd command) Decision {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.matches(cmd) {
+			return Decision{Allowed: rule.Action == Allow, Command: cmd.String(), Rule: rule, Index: i + 1}
+		}
+	}
+	return Decision{Allowed: p.Default != Deny, Command: cmd.String()}
+}
+
+func (r *Rule) matches(cmd command) bool {
+	if r.Command != "*" && r.Command != cmd.root {
+		return false
+	}
+	return r.args == nil || r.args.MatchString(cmd.args)
+}
@@ -0,0 +1,44 @@
+package cmdpolicy
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// What a rule does with the commands it matches
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// Rule matches console commands by their root and, optionally, their arguments
+type Rule struct {
+	Action  Action `json:"action"`
+	Command string `json:"command"`        // Command root without slash or namespace, or "*" for any
+	Args    string `json:"args,omitempty"` // Regular expression the whole argument string must match; empty matches any
+
+	args *regexp.Regexp
+}
+
+// The rules of one role, tried in order; the first match decides
+type RolePolicy struct {
+	Default Action `json:"default,omitempty"` // For commands no rule matches; allow when empty
+	Rules   []Rule `json:"rules"`
+}
+
+// Decision is the outcome of checking a command
+type Decision struct {
+	Allowed bool
+	Command string // The command, or the part of an execute chain, that decided
+	Rule    *Rule  // The rule that matched; nil when the role's default applied
+	Index   int    // Position of the rule in the role's list, from 1
+}
+
+func (r Rule) String() string {
+	if r.Args == "" {
+		return fmt.Sprintf("%s %s", r.Action, r.Command)
+	}
+	return fmt.Sprintf("%s %s %s", r.Action, r.Command, r.Args)
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"minecrap_hoster/internal/audit"
+	"minecrap_hoster/internal/auth"
+	"minecrap_hoster/internal/instances"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+	maxAuditValue     = 1000 // Longest parameter value kept, in bytes
+	maxAuditError     = 300  // Longest error message kept, in bytes
+)
+
+// Parameters whose values never reach the audit log
+var secretParams = map[string]bool{
+	"password":   true,
+	"current":    true,
+	"code":       true,
+	"passphrase": true,
+}
+
+type auditKey struct{}
+
+// The audit entry of a request in progress; later middleware and handlers fill it in
+type auditRecord struct {
+	entry    audit.Entry
+	captured bool              // Whether the parameters were read after the handler ran
+	body     map[string]string // Fields of a JSON body, noted by its handler
+}
+
+// Middleware recording requests that change something in the audit log,
+// including ones refused before reaching their handler
+func (h *Handler) auditRequest(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.audit == nil || isSafeMethod(r.Method) {
+			next(w, r)
+			return
+		}
+
+		record := &auditRecord{entry: audit.Entry{
+			Time:   time.Now().UTC(),
+			IP:     clientIP(r),
+			Action: r.URL.Path,
+		}}
+		recorder := &statusRecorder{ResponseWriter: w}
+		next(recorder, r.WithContext(context.WithValue(r.Context(), auditKey{}, record)))
+
+		// Refused requests never reached the handler, so their body is still unread
+		if !record.captured {
+			r.ParseForm()
+			record.capture(r)
+		}
+
+		entry := record.entry
+		entry.Status = recorder.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		if entry.Failed() {
+			entry.Error = truncate(strings.TrimSpace(string(recorder.body)), maxAuditError)
+		}
+		entry.Instance = entry.Params["instance"]
+		if entry.Instance == "" && strings.HasPrefix(entry.Action, "/api/server/") {
+			entry.Instance = instances.MainInstance
+		}
+
+		if err := h.audit.Record(entry); err != nil {
+			log.Printf("Failed to record audit entry for %s: %v", entry.Action, err)
+		}
+	}
+}
+
+// Middleware saving the parameters the handler parsed into the audit entry
+func auditParams(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r)
+		if record, ok := r.Context().Value(auditKey{}).(*auditRecord); ok {
+			record.capture(r)
+		}
+	}
+}
+
+// Records who is making a request in its audit entry, if it has one
+func noteActor(r *http.Request, user auth.User, token *auth.Token) {
+	record, ok := r.Context().Value(auditKey{}).(*auditRecord)
+	if !ok {
+		return
+	}
+	record.entry.Actor = user.Name
+	if token != nil {
+		record.entry.Token = token.ID
+	}
+}
+
+// Records fields of a JSON body in the request's audit entry, since only form
+// values are captured otherwise. Empty fields are left out.
+func noteParams(r *http.Request, params map[string]string) {
+	record, ok := r.Context().Value(auditKey{}).(*auditRecord)
+	if !ok {
+		return
+	}
+	record.body = make(map[string]string)
+	for name, value := range params {
+		if value != "" {
+			record.body[name] = value
+		}
+	}
+}
+
+// Copies the request's form values, upload names and noted body fields, hiding secrets
+func (record *auditRecord) capture(r *http.Request) {
+	record.captured = true
+
+	params := make(map[string]string)
+	for name, values := range r.Form {
+		params[name] = strings.Join(values, ",")
+	}
+	for name, value := range record.body {
+		params[name] = value
+	}
+	for name, value := range params {
+		if secretParams[name] {
+			params[name] = "[redacted]"
+		} else {
+			params[name] = truncate(value, maxAuditValue)
+		}
+	}
+	if r.MultipartForm != nil {
+		for name, files := range r.MultipartForm.File {
+			var names []string
+			for _, file := range files {
+				names = append(names, fmt.Sprintf("%s (%d bytes)", file.Filename, file.Size))
+			}
+			params[name] = strings.Join(names, ",")
+		}
+	}
+	if len(params) > 0 {
+		record.entry.Params = params
+	}
+}
+
+// Returns audit entries, newest first, or all of them as JSON Lines with format=jsonl
+func (h *Handler) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+	if h.audit == nil {
+		http.Error(w, "The audit log is disabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:    query.Get("actor"),
+		Action:   query.Get("action"),
+		Instance: query.Get("instance"),
+		IP:       query.Get("ip"),
+		Failed:   query.Get("failed") == "true",
+	}
+
+	var err error
+	if filter.Since, err = parseAuditTime(query.Get("since")); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid since: %v", err), http.StatusBadRequest)
+		return
+	}
+	if filter.Until, err = parseAuditTime(query.Get("until")); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid until: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("format") == "jsonl" {
+		filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.jsonl"`, time.Now().UTC().Format("20060102-150405")))
+		if err := h.audit.Export(w, filter); err != nil {
+			log.Printf("Failed to export audit log: %v", err)
+		}
+		return
+	}
+
+	filter.Limit = defaultAuditLimit
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		filter.Limit = min(limit, maxAuditLimit)
+	}
+	entries, err := h.audit.Query(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, entries)
+}
+
+// Parses an RFC 3339 time, or a duration meaning that long ago
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Response writer keeping the status and, for errors, the start of the body
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(data []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	if s.status >= 400 && len(s.body) < maxAuditError {
+		s.body = append(s.body, data...)
+	}
+	return s.ResponseWriter.Write(data)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func truncate(text string, size int) string {
+	if len(text) <= size {
+		return text
+	}
+	return text[:size] + "..."
+}
+
+// Reports whether the request is being recorded in the audit log by auditRequest
+func audited(r *http.Request) bool {
+	_, ok := r.Context().Value(auditKey{}).(*auditRecord)
+	return ok
+}
+
+// Records an event that isn't a mutating request, such as a single sign-on
+// login, in the audit log
+func (h *Handler) recordEvent(r *http.Request, action, actor string, status int, failure error) {
+	if h.audit == nil {
+		return
+	}
+	entry := audit.Entry{
+		Actor:  actor,
+		IP:     clientIP(r),
+		Action: action,
+		Status: status,
+	}
+	if failure != nil {
+		entry.Error = truncate(failure.Error(), maxAuditError)
+	}
+	if err := h.audit.Record(entry); err != nil {
+		log.Printf("Failed to record audit entry for %s: %v", action, err)
+	}
+}
@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"minecrap_hoster/internal/auth"
+	"minecrap_hoster/internal/instances"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cookie holding the login session token
+const sessionCookie = "mch_session"
+
+// Middleware requiring a logged-in user, or an API token with the given
+// scope, with at least the given role. An empty role leaves the route public
+// and an empty scope keeps tokens out. Does nothing when accounts are disabled.
+func (h *Handler) authorize(role auth.Role, scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.auth == nil || role == "" {
+			if user, ok := h.currentUser(r); ok {
+				noteActor(r, user, nil)
+				r = r.WithContext(auth.WithUser(r.Context(), user))
+			}
+			next(w, r)
+			return
+		}
+
+		if header := r.Header.Get("Authorization"); header != "" {
+			h.authorizeToken(w, r, header, role, scope, next)
+			return
+		}
+
+		user, ok := h.currentUser(r)
+		if !ok {
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+		noteActor(r, user, nil)
+		if !h.limitUser(w, r, user) {
+			return
+		}
+		if !user.Role.Allows(role) {
+			http.Error(w, fmt.Sprintf("Requires the %s role", role), http.StatusForbidden)
+			return
+		}
+		if h.needsTwoFactor(user) && !twoFactorSetupRoutes[r.URL.Path] {
+			http.Error(w, fmt.Sprintf("Set up two-factor authentication first; it is required for the %s role", user.Role), http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(auth.WithUser(r.Context(), user)))
+	}
+}
+
+// Checks a bearer token against the route's scope and its owner's role
+func (h *Handler) authorizeToken(w http.ResponseWriter, r *http.Request, header string, role auth.Role, scope auth.Scope, next http.HandlerFunc) {
+	bearer, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || h.tokens == nil {
+		http.Error(w, "Unsupported authorization header", http.StatusUnauthorized)
+		return
+	}
+
+	token, user, err := h.tokens.Authenticate(strings.TrimSpace(bearer))
+	if err != nil {
+		log.Printf("Rejected API token from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	noteActor(r, user, &token)
+	if !h.limitUser(w, r, user) {
+		return
+	}
+	if scope == "" {
+		http.Error(w, "API tokens can't be used here", http.StatusForbidden)
+		return
+	}
+	if !token.Allows(scope) {
+		http.Error(w, fmt.Sprintf("Token lacks the %s scope", scope), http.StatusForbidden)
+		return
+	}
+	if !user.Role.Allows(role) {
+		http.Error(w, fmt.Sprintf("Requires the %s role", role), http.StatusForbidden)
+		return
+	}
+	// Server routes check the instance themselves; everything else acts on the main server
+	if token.Instance != "" && token.Instance != instances.MainInstance && !strings.HasPrefix(r.URL.Path, "/api/server/") {
+		http.Error(w, fmt.Sprintf("Token is restricted to instance %s", token.Instance), http.StatusForbidden)
+		return
+	}
+
+	ctx := auth.WithToken(auth.WithUser(r.Context(), user), token)
+	next(w, r.WithContext(ctx))
+}
+
+// Returns the user whose session cookie came with the request
+func (h *Handler) currentUser(r *http.Request) (auth.User, bool) {
+	if h.auth == nil {
+		return auth.User{}, false
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if user, ok := h.auth.Session(cookie.Value); ok {
+			return user, true
+		}
+	}
+	return h.certificateUser(r)
+}
+
+// Returns the account named by the common name of the request's verified
+// client certificate, when client certificates are trusted
+func (h *Handler) certificateUser(r *http.Request) (auth.User, bool) {
+	if !h.clientCerts || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return auth.User{}, false
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if name == "" {
+		return auth.User{}, false
+	}
+	return h.auth.User(name)
+}
+
+// Checks a name and password and starts a session
+func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+	if h.auth == nil {
+		http.Error(w, "Accounts are disabled", http.StatusNotFound)
+		return
+	}
+
+	name := r.FormValue("username")
+	key := loginKey(r, name)
+	if wait := h.logins.Locked(key); wait > 0 {
+		respondWithRetryAfter(w, (&auth.LockedError{RetryAfter: wait}).Error(), wait)
+		return
+	}
+
+	user, err := h.auth.Authenticate(name, r.FormValue("password"))
+	if err != nil {
+		log.Printf("Failed login for %q from %s", name, r.RemoteAddr)
+		if lock := h.logins.Fail(key); lock > 0 {
+			log.Printf("Locked out logins for %q from %s for %s", name, r.RemoteAddr, lock)
+			h.recordEvent(r, "/api/auth/login", name, http.StatusTooManyRequests, fmt.Errorf("logins from %s locked out for %s after repeated failures", clientIP(r), lock))
+		}
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	h.logins.Reset(key)
+
+	noteActor(r, user, nil)
+	if user.TwoFactor {
+		if err := h.startChallenge(w, r, user.Name); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start login: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := h.startSession(w, r, user.Name); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to start session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %s logged in from %s", user.Name, r.RemoteAddr)
+	respondWithJSON(w, user)
+}
+
+// Sets a new session cookie for a user
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, name string) error {
+	token, expires, err := h.auth.NewSession(name)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     h.path("/"),
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	h.setCSRFCookie(w, r, token)
+	return nil
+}
+
+// Ends the current session
+func (h *Handler) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil && h.auth != nil {
+		h.auth.EndSession(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     h.path("/"),
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Path: h.path("/"), MaxAge: -1})
+
+	respondWithMessage(w, "Logged out", http.StatusOK)
+}
+
+// Returns the logged-in user
+func (h *Handler) HandleMe(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	user, ok := auth.UserFrom(r.Context())
+	if !ok {
+		// Accounts are disabled, so everyone is in charge
+		user = auth.User{Name: "anonymous", Role: auth.RoleAdmin}
+	}
+	// Scripts using the session cookie need the CSRF token for their POSTs
+	var csrf string
+	if cookie, err := r.Cookie(sessionCookie); err == nil && ok {
+		csrf = auth.CSRFToken(cookie.Value)
+	}
+	respondWithJSON(w, struct {
+		auth.User
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		CSRFToken         string `json:"csrf_token,omitempty"`
+	}{user, h.needsTwoFactor(user), csrf})
+}
+
+// Changes the logged-in user's password after checking the current one
+func (h *Handler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	user, ok := auth.UserFrom(r.Context())
+	if !ok {
+		http.Error(w, "Accounts are disabled", http.StatusNotFound)
+		return
+	}
+	if !user.Local() {
+		http.Error(w, fmt.Sprintf("Your password is managed by %s", user.Source), http.StatusBadRequest)
+		return
+	}
+	if _, err := h.auth.Authenticate(user.Name, r.FormValue("current")); err != nil {
+		http.Error(w, "Current password is wrong", http.StatusForbidden)
+		return
+	}
+	if err := h.auth.SetPassword(user.Name, r.FormValue("password")); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to change password: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Changing the password ended every session, this one included
+	if err := h.startSession(w, r, user.Name); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to start session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	respondWithMessage(w, "Password changed", http.StatusOK)
+}
+
+// Lists the accounts
+func (h *Handler) HandleUsers(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+	if err := h.requireAccounts(w); err != nil {
+		return
+	}
+
+	respondWithJSON(w, h.auth.Users())
+}
+
+// Creates an account
+func (h *Handler) HandleAddUser(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+	if err := h.requireAccounts(w); err != nil {
+		return
+	}
+
+	role, err := auth.ParseRole(r.FormValue("role"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.auth.AddUser(r.FormValue("username"), r.FormValue("password"), role); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to add user: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	respondWithMessage(w, "User added", http.StatusOK)
+}
+
+// Deletes an account
+func (h *Handler) HandleRemoveUser(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+	if err := h.requireAccounts(w); err != nil {
+		return
+	}
+
+	if err := h.auth.RemoveUser(r.FormValue("username")); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to remove user: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	respondWithMessage(w, "User removed", http.StatusOK)
+}
+
+// Changes an account's role, resets its password when one is given, or
+// turns off its two-factor authentication with reset_2fa=true
+func (h *Handler) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+	if err := h.requireAccounts(w); err != nil {
+		return
+	}
+
+	name := r.FormValue("username")
+	if value := r.FormValue("role"); value != "" {
+		role, err := auth.ParseRole(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.auth.SetRole(name, role); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to change role: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if password := r.FormValue("password"); password != "" {
+		if err := h.auth.SetPassword(name, password); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to reset password: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if r.FormValue("reset_2fa") == "true" {
+		if err := h.auth.DisableTOTP(name); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to reset two-factor authentication: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	respondWithMessage(w, "User updated", http.StatusOK)
+}
+
+func (h *Handler) requireAccounts(w http.ResponseWriter) error {
+	if h.auth == nil {
+		err := errors.New("accounts are disabled")
+		http.Error(w, "Accounts are disabled", http.StatusNotFound)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"minecrap_hoster/internal/backup"
+	"minecrap_hoster/internal/minecraft"
+	"net/http"
+	"strconv"
+)
+
+// Lists the archives stored in a backup target
+func (h *Handler) HandleListBackups(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		target = backup.DefaultTarget().Name
+	}
+
+	archives, err := h.backups.List(target)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list backups: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	respondWithJSON(w, archives)
+}
+
+// Creates a backup of the world in the requested target, in the background
+func (h *Handler) HandleCreateBackup(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	target := formValueOr(r, "target", backup.DefaultTarget().Name)
+	if !h.knownTarget(target) {
+		http.Error(w, fmt.Sprintf("Unknown backup target %q", target), http.StatusBadRequest)
+		return
+	}
+	if !h.backupRunning.CompareAndSwap(false, true) {
+		http.Error(w, "A backup or restore is already running", http.StatusConflict)
+		return
+	}
+
+	// Saving and compressing the world can take far longer than the HTTP write timeout
+	go func() {
+		defer h.backupRunning.Store(false)
+		h.server.AddLog(fmt.Sprintf("Backing up the world to %s...", target))
+		archive, err := h.backups.BackupWorld(h.server, target)
+		if err != nil {
+			log.Printf("Failed to create backup: %v", err)
+			h.server.AddLog(fmt.Sprintf("Backup failed: %v", err))
+			return
+		}
+		h.server.AddLog(fmt.Sprintf("Backup %s created in %s", archive.Name, archive.Target))
+	}()
+
+	respondWithJSON(w, map[string]string{"status": "backing up"})
+}
+
+// Restores the world from a backup while the server is stopped, in the background
+func (h *Handler) HandleRestoreBackup(w http.ResponseWriter, r *http.Request) {
+	h.handleRestore(w, r, "world", h.backups.RestoreWorld, nil)
+}
+
+// Restores the mods directory from a mods backup while the server is stopped, in the background
+func (h *Handler) HandleRestoreMods(w http.ResponseWriter, r *http.Request) {
+	if h.modUpdating.Load() {
+		http.Error(w, "A mod update is running", http.StatusConflict)
+		return
+	}
+	h.handleRestore(w, r, "mods", h.backups.RestoreMods, h.mods.Rehash)
+}
+
+func (h *Handler) handleRestore(w http.ResponseWriter, r *http.Request, what string,
+	restore func(*minecraft.MinecraftServer, string, string) error, after func() error) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	target := formValueOr(r, "target", backup.DefaultTarget().Name)
+	archive := r.FormValue("archive")
+	if archive == "" {
+		http.Error(w, "Archive cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if !h.knownTarget(target) {
+		http.Error(w, fmt.Sprintf("Unknown backup target %q", target), http.StatusBadRequest)
+		return
+	}
+	if h.server.Status != minecraft.Stopped {
+		http.Error(w, "Stop the server before restoring a backup", http.StatusConflict)
+		return
+	}
+	if !h.backupRunning.CompareAndSwap(false, true) {
+		http.Error(w, "A backup or restore is already running", http.StatusConflict)
+		return
+	}
+
+	// Decrypting and extracting a large archive can take far longer than the HTTP write timeout
+	go func() {
+		defer h.backupRunning.Store(false)
+		h.server.AddLog(fmt.Sprintf("Restoring %s from %s...", what, archive))
+		err := restore(h.server, target, archive)
+		if err == nil && after != nil {
+			err = after()
+		}
+		if err != nil {
+			log.Printf("Failed to restore backup: %v", err)
+			h.server.AddLog(fmt.Sprintf("Restore failed: %v", err))
+			return
+		}
+		h.server.AddLog(fmt.Sprintf("Restored %s from %s", what, archive))
+	}()
+
+	respondWithJSON(w, map[string]string{"status": "restoring"})
+}
+
+func (h *Handler) knownTarget(name string) bool {
+	for _, target := range h.backups.Targets() {
+		if target.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Lists backup targets, or adds/updates one from a JSON body
+func (h *Handler) HandleBackupTargets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		respondWithJSON(w, h.backups.Targets())
+
+	case http.MethodPost:
+		var target backup.Target
+		if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+			http.Error(w, "Invalid target JSON", http.StatusBadRequest)
+			return
+		}
+		noteParams(r, map[string]string{
+			"name":           target.Name,
+			"path":           target.Path,
+			"encryption":     strconv.FormatBool(target.Encryption.Enabled),
+			"passphrase":     target.Encryption.Passphrase, // Only shows that one was set
+			"passphrase_env": target.Encryption.PassphraseEnv,
+			"key_file":       target.Encryption.KeyFile,
+		})
+		if err := h.backups.SaveTarget(target); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to save target: %v", err), http.StatusBadRequest)
+			return
+		}
+		respondWithMessage(w, "Target saved", http.StatusOK)
+
+	default:
+		http.Error(w, "Only GET and POST methods allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func formValueOr(r *http.Request, key, fallback string) string {
+	if value := r.FormValue(key); value != "" {
+		return value
+	}
+	return fallback
+}
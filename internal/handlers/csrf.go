@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"minecrap_hoster/internal/auth"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	csrfCookie = "mch_csrf"     // Readable by the dashboard's scripts, unlike the session cookie
+	csrfHeader = "X-CSRF-Token" // Header the dashboard copies the cookie into
+)
+
+// Headers cross-origin clients may send
+const corsAllowHeaders = "Authorization, Content-Type, " + csrfHeader
+
+// Middleware guarding against cross-site requests. Browsers may only change
+// state from this origin or an allowed one, and requests riding on a session
+// cookie must also carry the session's CSRF token. Allowed origins get CORS
+// headers; everyone else gets none.
+func (h *Handler) protect(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && h.allowedOrigins[origin]
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+
+		// CORS preflight
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if isSafeMethod(r.Method) || r.Header.Get("Authorization") != "" {
+			// API tokens aren't sent by browsers on their own, so they can't be forged
+			next(w, r)
+			return
+		}
+
+		if source, ok := requestSource(r); ok && !sameHost(source, r.Host) && !h.allowedOrigins[source] {
+			log.Printf("Blocked cross-site %s %s from %s (origin %s)", r.Method, r.URL.Path, r.RemoteAddr, source)
+			http.Error(w, "Cross-site request blocked", http.StatusForbidden)
+			return
+		}
+
+		if cookie, err := r.Cookie(sessionCookie); err == nil && h.auth != nil {
+			if _, ok := h.auth.Session(cookie.Value); ok {
+				want := auth.CSRFToken(cookie.Value)
+				if subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(want)) != 1 {
+					http.Error(w, "Missing or invalid CSRF token; reload the page", http.StatusForbidden)
+					return
+				}
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// Sets the cookie the dashboard reads its CSRF token from
+func (h *Handler) setCSRFCookie(w http.ResponseWriter, r *http.Request, sessionToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    auth.CSRFToken(sessionToken),
+		Path:     h.path("/"),
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// Returns the origin a browser says the request came from: the Origin
+// header, else the origin of the Referer. Clients that send neither, like
+// scripts, report nothing.
+func requestSource(r *http.Request) (string, bool) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin, true
+	}
+	if referer := r.Header.Get("Referer"); referer != "" {
+		parsed, err := url.Parse(referer)
+		if err != nil || parsed.Host == "" {
+			return referer, true
+		}
+		return parsed.Scheme + "://" + parsed.Host, true
+	}
+	return "", false
+}
+
+// Reports whether an origin points at the host the request was sent to
+func sameHost(origin, host string) bool {
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	return strings.EqualFold(parsed.Host, host)
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -1,58 +1,259 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"minecrap_hoster/internal/audit"
+	"minecrap_hoster/internal/auth"
+	"minecrap_hoster/internal/backup"
+	"minecrap_hoster/internal/cmdpolicy"
+	"minecrap_hoster/internal/instances"
 	"minecrap_hoster/internal/minecraft"
+	"minecrap_hoster/internal/modpack"
+	"minecrap_hoster/internal/mods"
+	"minecrap_hoster/internal/oidc"
+	"minecrap_hoster/internal/provision"
+	"minecrap_hoster/internal/ratelimit"
+	"minecrap_hoster/internal/scheduler"
+	"minecrap_hoster/internal/sleeper"
+	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // Represents the core HTTP request handler with associated Minecraft server instance
 type Handler struct {
-	server *minecraft.MinecraftServer
+	server      *minecraft.MinecraftServer
+	backups     *backup.Manager
+	scheduler   *scheduler.Scheduler
+	sleeper     *sleeper.Sleeper
+	mods        *mods.Manager
+	updates     *mods.UpdateClient
+	modpack     *modpack.Importer
+	provisioner *provision.Provisioner
+	instances   *instances.Manager
+	javaDirs    []string
+	auth        *auth.Store
+	tokens      *auth.TokenStore
+	audit       *audit.Log
+	oidc        *oidc.Provider
+	commands    *cmdpolicy.Policy
+
+	twoFactorRoles map[auth.Role]bool
+	totpIssuer     string
+	allowedOrigins map[string]bool
+	clientCerts    bool
+	basePath       string
+	trustedProxies []*net.IPNet
+	rateLimits     map[string]rateLimit
+	logins         *ratelimit.Lockout
+	modUpdating    atomic.Bool // Whether a mod update is running in the background
+	backupRunning  atomic.Bool // Whether a backup or restore requested here is running
+}
+
+// Optional subsystems exposed through the HTTP API
+type Services struct {
+	Backups     *backup.Manager
+	Scheduler   *scheduler.Scheduler
+	Sleeper     *sleeper.Sleeper // Optional; nil when idle shutdown is disabled
+	Mods        *mods.Manager
+	Updates     *mods.UpdateClient
+	Modpack     *modpack.Importer
+	Provisioner *provision.Provisioner
+	Instances   *instances.Manager
+	JavaDirs    []string          // Extra directories searched for JDKs
+	Auth        *auth.Store       // Optional; nil when accounts are disabled and every route is open
+	Tokens      *auth.TokenStore  // Optional; nil disables API tokens
+	Audit       *audit.Log        // Optional; nil disables the audit log
+	OIDC        *oidc.Provider    // Optional; nil disables single sign-on
+	Commands    *cmdpolicy.Policy // Optional; nil lets every role send any console command
+
+	TwoFactorRoles []auth.Role               // Roles whose password accounts must use two-factor authentication
+	TOTPIssuer     string                    // Name authenticator apps show for the hoster
+	AllowedOrigins []string                  // Other origins allowed to call the API, such as "https://panel.example.com"
+	ClientCerts    bool                      // Whether verified TLS client certificates log in the account named by their common name
+	BasePath       string                    // Path prefix the hoster is served under, such as "/mc"; empty for the root
+	TrustedProxies []*net.IPNet              // Reverse proxies whose X-Forwarded-For and X-Forwarded-Proto headers are believed
+	RateLimits     map[string]ratelimit.Rate // Requests per route group allowed from each address and each user; groups left out are unlimited
 }
 
 // Creates a new handler instance with server validation
-func NewHandler(server *minecraft.MinecraftServer) *Handler {
+func NewHandler(server *minecraft.MinecraftServer, services Services) *Handler {
 	if server == nil {
 		panic("Server must not be nil.")
 	}
+	if services.Backups == nil {
+		panic("Backup manager must not be nil.")
+	}
+	if services.Scheduler == nil {
+		panic("Scheduler must not be nil.")
+	}
+	if services.Mods == nil {
+		panic("Mod manager must not be nil.")
+	}
+	if services.Updates == nil {
+		panic("Mod update client must not be nil.")
+	}
+	if services.Modpack == nil {
+		panic("Modpack importer must not be nil.")
+	}
+	if services.Provisioner == nil {
+		panic("Provisioner must not be nil.")
+	}
+	if services.Instances == nil {
+		panic("Instance manager must not be nil.")
+	}
+	twoFactorRoles := make(map[auth.Role]bool)
+	for _, role := range services.TwoFactorRoles {
+		twoFactorRoles[role] = true
+	}
+	allowedOrigins := make(map[string]bool)
+	for _, origin := range services.AllowedOrigins {
+		allowedOrigins[strings.TrimRight(origin, "/")] = true
+	}
+
+	basePath := strings.TrimRight(services.BasePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+
+	rateLimits := make(map[string]rateLimit)
+	for group, rate := range services.RateLimits {
+		if rate.Count > 0 {
+			rateLimits[group] = rateLimit{perIP: ratelimit.New(rate), perUser: ratelimit.New(rate)}
+		}
+	}
+
 	log.Printf("Handler created with server instance")
-	return &Handler{server: server}
+	return &Handler{
+		server:      server,
+		backups:     services.Backups,
+		scheduler:   services.Scheduler,
+		sleeper:     services.Sleeper,
+		mods:        services.Mods,
+		updates:     services.Updates,
+		modpack:     services.Modpack,
+		provisioner: services.Provisioner,
+		instances:   services.Instances,
+		javaDirs:    services.JavaDirs,
+		auth:        services.Auth,
+		tokens:      services.Tokens,
+		audit:       services.Audit,
+		oidc:        services.OIDC,
+		commands:    services.Commands,
+
+		twoFactorRoles: twoFactorRoles,
+		totpIssuer:     services.TOTPIssuer,
+		allowedOrigins: allowedOrigins,
+		clientCerts:    services.ClientCerts,
+		basePath:       basePath,
+		trustedProxies: services.TrustedProxies,
+		rateLimits:     rateLimits,
+		logins:         ratelimit.NewLockout(loginFailuresFree, loginLockoutBase, loginLockoutMax),
+	}
 }
 
 type routeConfig struct {
 	path    string
 	handler http.HandlerFunc
 	logMsg  string
+	role    auth.Role  // Least role allowed to call the route; empty for public routes
+	scope   auth.Scope // Scope an API token needs; empty if tokens can't be used
 }
 
 // Registers all HTTP routes and their corresponding handlers
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	log.Printf("Registering routes...")
 
-	// Static file handler
-	mux.HandleFunc("/", h.logRequest(http.FileServer(http.Dir("static")).ServeHTTP, "Static file request"))
+	// Under a base path the routes live on their own mux, reached with the prefix removed
+	if h.basePath != "" {
+		routes := http.NewServeMux()
+		mux.Handle("/", h.stripBasePath(routes))
+		mux = routes
+		log.Printf("Serving under %s/", h.basePath)
+	}
+
+	// Static file handler; public so the login page loads, the data comes from the API
+	mux.HandleFunc("/", h.fromProxy(h.authorize("", "", h.logRequest(http.FileServer(http.Dir("static")).ServeHTTP, "Static file request"))))
 
 	// Define routes configuration
 	routes := []routeConfig{
-		{"/api/server/start", h.HandleStart, "Start endpoint"},
-		{"/api/server/stop", h.HandleStop, "Stop endpoint"},
-		{"/api/server/force-stop", h.HandleForceStop, "Force stop endpoint"},
-		{"/api/server/status", h.HandleStatus, "Status endpoint"},
-		{"/api/server/logs", h.HandleLogs, "Logs SSE endpoint"},
-		{"/api/server/command", h.HandleCommand, "Command endpoint"},
-		{"/api/server/restart", h.HandleRestart, "Restart endpoint"},
-		{"/api/server/auto-restart", h.HandleToggleAutoRestart, "Auto-restart toggle endpoint"},
-		{"/api/server/auto-restart/status", h.HandleGetAutoRestart, "Auto-restart status endpoint"},
-		{"/api/hoster/shutdown", h.HandleShutdownHoster, "Hoster shutdown endpoint"},
-	}
-
-	// Register routes with logging middleware
+		{"/api/auth/login", h.HandleLogin, "Login endpoint", "", ""},
+		{"/api/auth/logout", h.HandleLogout, "Logout endpoint", "", ""},
+		{"/api/auth/providers", h.HandleAuthProviders, "Login providers endpoint", "", ""},
+		{"/api/auth/oidc/login", h.HandleOIDCLogin, "Single sign-on endpoint", "", ""},
+		{"/api/auth/oidc/callback", h.HandleOIDCCallback, "Single sign-on callback endpoint", "", ""},
+		{"/api/auth/me", h.HandleMe, "Current user endpoint", auth.RoleViewer, ""},
+		{"/api/auth/password", h.HandleChangePassword, "Password change endpoint", auth.RoleViewer, ""},
+		{"/api/auth/2fa/verify", h.HandleVerifyTwoFactor, "Two-factor login endpoint", "", ""},
+		{"/api/auth/2fa/setup", h.HandleSetupTwoFactor, "Two-factor setup endpoint", auth.RoleViewer, ""},
+		{"/api/auth/2fa/enable", h.HandleEnableTwoFactor, "Two-factor enable endpoint", auth.RoleViewer, ""},
+		{"/api/auth/2fa/disable", h.HandleDisableTwoFactor, "Two-factor disable endpoint", auth.RoleViewer, ""},
+		{"/api/auth/2fa/recovery-codes", h.HandleRecoveryCodes, "Recovery codes endpoint", auth.RoleViewer, ""},
+		{"/api/users", h.HandleUsers, "User list endpoint", auth.RoleAdmin, ""},
+		{"/api/users/add", h.HandleAddUser, "User add endpoint", auth.RoleAdmin, ""},
+		{"/api/users/remove", h.HandleRemoveUser, "User remove endpoint", auth.RoleAdmin, ""},
+		{"/api/users/update", h.HandleUpdateUser, "User update endpoint", auth.RoleAdmin, ""},
+		{"/api/tokens", h.HandleTokens, "Token list endpoint", auth.RoleViewer, ""},
+		{"/api/tokens/create", h.HandleCreateToken, "Token create endpoint", auth.RoleViewer, ""},
+		{"/api/tokens/revoke", h.HandleRevokeToken, "Token revoke endpoint", auth.RoleViewer, ""},
+		{"/api/audit", h.HandleAudit, "Audit log endpoint", auth.RoleAdmin, auth.ScopeAuditRead},
+		{"/api/server/start", h.HandleStart, "Start endpoint", auth.RoleOperator, auth.ScopeServerControl},
+		{"/api/server/stop", h.HandleStop, "Stop endpoint", auth.RoleOperator, auth.ScopeServerControl},
+		{"/api/server/force-stop", h.HandleForceStop, "Force stop endpoint", auth.RoleOperator, auth.ScopeServerControl},
+		{"/api/server/status", h.HandleStatus, "Status endpoint", auth.RoleViewer, auth.ScopeLogsRead},
+		{"/api/server/logs", h.HandleLogs, "Logs SSE endpoint", auth.RoleViewer, auth.ScopeLogsRead},
+		{"/api/server/command", h.HandleCommand, "Command endpoint", auth.RoleOperator, auth.ScopeConsoleWrite},
+		{"/api/server/restart", h.HandleRestart, "Restart endpoint", auth.RoleOperator, auth.ScopeServerControl},
+		{"/api/server/restart/cancel", h.HandleCancelRestart, "Restart cancel endpoint", auth.RoleOperator, auth.ScopeServerControl},
+		{"/api/server/auto-restart", h.HandleToggleAutoRestart, "Auto-restart toggle endpoint", auth.RoleOperator, auth.ScopeServerControl},
+		{"/api/server/auto-restart/status", h.HandleGetAutoRestart, "Auto-restart status endpoint", auth.RoleViewer, auth.ScopeLogsRead},
+		{"/api/server/command-line", h.HandleCommandLine, "Command line preview endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/instances", h.HandleInstances, "Instances endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/instances/add", h.HandleAddInstance, "Instance add endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/instances/remove", h.HandleRemoveInstance, "Instance remove endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/instances/start-all", h.HandleStartAll, "Start all instances endpoint", auth.RoleOperator, auth.ScopeServerControl},
+		{"/api/instances/stop-all", h.HandleStopAll, "Stop all instances endpoint", auth.RoleOperator, auth.ScopeServerControl},
+		{"/api/java", h.HandleJava, "Java runtimes endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/java/select", h.HandleSelectJava, "Java select endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/hoster/shutdown", h.HandleShutdownHoster, "Hoster shutdown endpoint", auth.RoleAdmin, auth.ScopeServerControl},
+		{"/api/backups", h.HandleListBackups, "Backup list endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/backups/create", h.HandleCreateBackup, "Backup create endpoint", auth.RoleOperator, auth.ScopeBackupsWrite},
+		{"/api/backups/restore", h.HandleRestoreBackup, "Backup restore endpoint", auth.RoleAdmin, auth.ScopeBackupsWrite},
+		{"/api/backups/targets", h.HandleBackupTargets, "Backup targets endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/mods", h.HandleMods, "Mods endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/mods/check", h.HandleCheckMods, "Mod check endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/mods/disabled", h.HandleDisabledMods, "Disabled mods endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/mods/pending", h.HandlePendingMods, "Pending mod changes endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/mods/upload", h.HandleUploadMod, "Mod upload endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/mods/enable", h.HandleEnableMod, "Mod enable endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/mods/disable", h.HandleDisableMod, "Mod disable endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/mods/delete", h.HandleDeleteMod, "Mod delete endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/mods/updates", h.HandleModUpdates, "Mod updates endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/mods/update", h.HandleApplyModUpdate, "Mod update endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/mods/restore", h.HandleRestoreMods, "Mod restore endpoint", auth.RoleAdmin, auth.ScopeBackupsWrite},
+		{"/api/modpack", h.HandleModpack, "Modpack endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/modpack/import", h.HandleImportModpack, "Modpack import endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/provision", h.HandleProvision, "Provision endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/provision/versions", h.HandleProvisionVersions, "Provision versions endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/provision/install", h.HandleProvisionInstall, "Provision install endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/schedules", h.HandleSchedules, "Schedules endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+		{"/api/schedules/create", h.HandleCreateSchedule, "Schedule create endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/schedules/update", h.HandleUpdateSchedule, "Schedule update endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/schedules/delete", h.HandleDeleteSchedule, "Schedule delete endpoint", auth.RoleAdmin, auth.ScopeConfigWrite},
+		{"/api/schedules/run", h.HandleRunSchedule, "Schedule run endpoint", auth.RoleOperator, auth.ScopeServerControl},
+		{"/api/schedules/history", h.HandleScheduleHistory, "Schedule history endpoint", auth.RoleViewer, auth.ScopeConfigRead},
+	}
+
+	// Register routes with proxy header handling, rate limiting, auditing, cross-site protection, access control and logging middleware
 	for _, route := range routes {
-		mux.HandleFunc(route.path, h.logRequest(route.handler, route.logMsg))
+		mux.HandleFunc(route.path, h.fromProxy(h.limitClient(h.auditRequest(h.protect(h.authorize(route.role, route.scope, h.logRequest(auditParams(route.handler), route.logMsg)))))))
 	}
 
 	log.Printf("All routes registered")
@@ -61,19 +262,63 @@ func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 // Middleware function to log incoming requests
 func (h *Handler) logRequest(next http.HandlerFunc, logMsg string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s: %s from %s", logMsg, r.URL.Path, r.RemoteAddr)
+		if user, ok := auth.UserFrom(r.Context()); ok {
+			log.Printf("%s: %s from %s (%s)", logMsg, r.URL.Path, r.RemoteAddr, user.Name)
+		} else {
+			log.Printf("%s: %s from %s", logMsg, r.URL.Path, r.RemoteAddr)
+		}
 		next(w, r)
 	}
 }
 
+// Returns the address the request came from, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Checks console input against the command policy for the requesting user's
+// role, answering 403 with the rule that refused it. Returns whether the
+// command may be sent.
+func (h *Handler) allowCommand(w http.ResponseWriter, r *http.Request, command string) bool {
+	user, ok := auth.UserFrom(r.Context())
+	if !ok || h.commands == nil {
+		return true
+	}
+	decision := h.commands.Check(user.Role, command)
+	if decision.Allowed {
+		return true
+	}
+
+	reason := "its default rule"
+	if decision.Rule != nil {
+		reason = fmt.Sprintf("rule %d (%s)", decision.Index, decision.Rule)
+	}
+	log.Printf("Refused command %q from %s: denied for the %s role by %s", command, user.Name, user.Role, reason)
+	http.Error(w, fmt.Sprintf("Command %q is denied for the %s role by %s", decision.Command, user.Role, reason), http.StatusForbidden)
+	return false
+}
+
 // Processes and executes server commands
 func (h *Handler) HandleCommand(w http.ResponseWriter, r *http.Request) {
 	if err := validateCommandRequest(w, r); err != nil {
 		return
 	}
 
+	server, err := h.serverFor(w, r)
+	if err != nil {
+		return
+	}
+
 	command := r.PostForm.Get("command")
-	if err := h.server.ExecuteCommand(command); err != nil {
+	if !h.allowCommand(w, r, command) {
+		return
+	}
+
+	if err := server.ExecuteCommand(command); err != nil {
 		log.Printf("Failed to execute command: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to execute command: %v", err), http.StatusInternalServerError)
 		return
@@ -106,9 +351,19 @@ func (h *Handler) HandleStart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.server.Start(); err != nil {
+	server, err := h.serverFor(w, r)
+	if err != nil {
+		return
+	}
+
+	start := server.Start
+	if r.FormValue("force") == "true" {
+		start = server.StartForced
+	}
+
+	if err := start(); err != nil {
 		log.Printf("Failed to start server: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to start server: %v", err), http.StatusInternalServerError)
+		respondWithStartError(w, "Failed to start server", err)
 		return
 	}
 
@@ -121,7 +376,12 @@ func (h *Handler) HandleStop(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.server.Stop(); err != nil {
+	server, err := h.serverFor(w, r)
+	if err != nil {
+		return
+	}
+
+	if err := server.Stop(); err != nil {
 		log.Printf("Failed to stop server: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to stop server: %v", err), http.StatusInternalServerError)
 		return
@@ -136,7 +396,12 @@ func (h *Handler) HandleForceStop(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.server.ForceStop(); err != nil {
+	server, err := h.serverFor(w, r)
+	if err != nil {
+		return
+	}
+
+	if err := server.ForceStop(); err != nil {
 		log.Printf("Failed to force-stop server: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to force-stop server: %v", err), http.StatusInternalServerError)
 		return
@@ -151,22 +416,77 @@ func (h *Handler) HandleRestart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.server.Restart(); err != nil {
+	server, err := h.serverFor(w, r)
+	if err != nil {
+		return
+	}
+
+	if r.FormValue("graceful") == "true" {
+		h.handleGracefulRestart(w, r, server)
+		return
+	}
+
+	if err := server.Restart(); err != nil {
 		log.Printf("Failed to restart server: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to restart server: %v", err), http.StatusInternalServerError)
+		respondWithStartError(w, "Failed to restart server", err)
 		return
 	}
 
 	respondWithMessage(w, "Server restarting...", http.StatusOK)
 }
 
+// Starts a countdown restart; an optional delay such as "5m" overrides the default
+func (h *Handler) handleGracefulRestart(w http.ResponseWriter, r *http.Request, server *minecraft.MinecraftServer) {
+	var delay time.Duration
+	if text := r.FormValue("delay"); text != "" {
+		parsed, err := time.ParseDuration(text)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid delay", http.StatusBadRequest)
+			return
+		}
+		delay = parsed
+	}
+
+	if err := server.GracefulRestart(delay); err != nil {
+		log.Printf("Failed to schedule restart: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to schedule restart: %v", err), http.StatusConflict)
+		return
+	}
+
+	respondWithMessage(w, "Restart countdown started", http.StatusOK)
+}
+
+// Cancels a pending countdown restart
+func (h *Handler) HandleCancelRestart(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	server, err := h.serverFor(w, r)
+	if err != nil {
+		return
+	}
+
+	if err := server.CancelCountdown(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to cancel restart: %v", err), http.StatusConflict)
+		return
+	}
+
+	respondWithMessage(w, "Restart cancelled", http.StatusOK)
+}
+
 // Toggles the auto-restart feature and returns the new state
 func (h *Handler) HandleToggleAutoRestart(w http.ResponseWriter, r *http.Request) {
 	if err := AssertMethodPost(w, r); err != nil {
 		return
 	}
 
-	enabled := h.server.ToggleAutoRestart()
+	server, err := h.serverFor(w, r)
+	if err != nil {
+		return
+	}
+
+	enabled := server.ToggleAutoRestart()
 	respondWithJSON(w, map[string]bool{"enabled": enabled})
 }
 
@@ -176,37 +496,33 @@ func (h *Handler) HandleGetAutoRestart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	enabled := h.server.GetAutoRestart()
+	server, err := h.serverFor(w, r)
+	if err != nil {
+		return
+	}
+
+	enabled := server.GetAutoRestart()
 	respondWithJSON(w, map[string]bool{"enabled": enabled})
 }
 
-// Initiates a graceful shutdown of both the Minecraft server and the hoster
+// Initiates a graceful shutdown of every server instance and the hoster
 func (h *Handler) HandleShutdownHoster(w http.ResponseWriter, r *http.Request) {
 	if err := AssertMethodPost(w, r); err != nil {
 		return
 	}
 
-	if err := h.initiateShutdown(); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to stop server: %v", err), http.StatusInternalServerError)
-		return
-	}
-
 	respondWithMessage(w, "Initiating shutdown sequence...", http.StatusOK)
-	go h.waitForShutdown()
+	go h.shutdown()
 }
 
-func (h *Handler) initiateShutdown() error {
-	if h.server.Status == minecraft.Running || h.server.Status == minecraft.Starting {
-		return h.server.Stop()
-	}
-	return nil
-}
-
-func (h *Handler) waitForShutdown() {
-	for h.server.Status != minecraft.Stopped {
-		time.Sleep(500 * time.Millisecond)
+// Stops backends before the proxy, then exits once everything is down
+func (h *Handler) shutdown() {
+	if err := h.instances.StopAll(); err != nil {
+		log.Printf("Shutdown aborted, failed to stop servers: %v", err)
+		h.server.AddLog(fmt.Sprintf("Shutdown aborted: %v", err))
+		return
 	}
-	log.Printf("Minecraft server stopped, shutting down hoster...")
+	log.Printf("Minecraft servers stopped, shutting down hoster...")
 	os.Exit(0)
 }
 
@@ -216,9 +532,44 @@ func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status := h.server.Status
-	log.Printf("Current server status: %d", status)
-	respondWithHTML(w, getStatusHTML(status))
+	server, err := h.serverFor(w, r)
+	if err != nil {
+		return
+	}
+
+	log.Printf("Current server status: %d", server.Status)
+	respondWithHTML(w, h.statusHTML(server))
+}
+
+// Returns the status badges: server state, or sleeping, plus any mod changes pending restart
+func (h *Handler) statusHTML(server *minecraft.MinecraftServer) string {
+	status := getStatusHTML(server.Status)
+	if server != h.server {
+		return status // Idle shutdown and mod management apply to the main server only
+	}
+	if h.sleeper != nil && h.sleeper.Asleep() {
+		status = `<span class="px-2 py-1 bg-indigo-100 text-indigo-800 rounded-full">Sleeping</span>`
+	}
+
+	if pending := len(h.mods.Pending()); pending > 0 {
+		status += fmt.Sprintf(` <span class="px-2 py-1 bg-amber-100 text-amber-800 rounded-full">%d mod change(s) pending restart</span>`, pending)
+	}
+	return status
+}
+
+// Resolves the "instance" parameter to a server, defaulting to the main one
+func (h *Handler) serverFor(w http.ResponseWriter, r *http.Request) (*minecraft.MinecraftServer, error) {
+	instance, err := h.instances.Get(r.FormValue("instance"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, err
+	}
+	if token, ok := auth.TokenFrom(r.Context()); ok && token.Instance != "" && token.Instance != instance.Name {
+		err := fmt.Errorf("token is restricted to instance %s", token.Instance)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil, err
+	}
+	return instance.Server, nil
 }
 
 // Converts server status to styled HTML representation
@@ -245,6 +596,15 @@ func getStatusHTML(status uint8) string {
 		config.class, config.class, config.text)
 }
 
+// Converts the time left before a graceful restart to HTML; empty when none is pending
+func getCountdownHTML(remaining time.Duration) string {
+	if remaining <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(`<span class="px-2 py-1 bg-orange-100 text-orange-800 rounded-full">Restarting in %s</span>`,
+		minecraft.FormatDuration(remaining.Round(time.Second)))
+}
+
 // HTTP method validation helpers
 func AssertMethodPost(w http.ResponseWriter, r *http.Request) error {
 	return assertMethod(w, r, http.MethodPost)
@@ -268,10 +628,28 @@ func respondWithMessage(w http.ResponseWriter, message string, status int) {
 	fmt.Fprint(w, message)
 }
 
+// Refuses a request with 429, telling the client when to try again
+func respondWithRetryAfter(w http.ResponseWriter, message string, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
+// Reports a blocked start with the readable preflight report
+func respondWithStartError(w http.ResponseWriter, message string, err error) {
+	var preflight *minecraft.PreflightError
+	if errors.As(err, &preflight) {
+		http.Error(w, fmt.Sprintf("%s: %s check failed\n%s", message, preflight.Check, preflight.Report), http.StatusConflict)
+		return
+	}
+	http.Error(w, fmt.Sprintf("%s: %v", message, err), http.StatusInternalServerError)
+}
+
 func respondWithJSON(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"enabled":%v}`, data.(map[string]bool)["enabled"])
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
 }
 
 func respondWithHTML(w http.ResponseWriter, html string) {
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"minecrap_hoster/internal/instances"
+	"net/http"
+)
+
+// Lists every server instance with its role and state
+func (h *Handler) HandleInstances(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	respondWithJSON(w, h.instances.List())
+}
+
+// Adds a proxy or backend instance from a JSON body
+func (h *Handler) HandleAddInstance(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	var config instances.Config
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, "Invalid instance JSON", http.StatusBadRequest)
+		return
+	}
+	noteParams(r, map[string]string{
+		"instance": config.Name,
+		"role":     config.Role,
+		"dir":      config.Dir,
+		"jar":      config.Jar,
+		"flavor":   config.Flavor,
+	})
+
+	if err := h.instances.Add(config); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to add instance: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	respondWithMessage(w, "Instance added", http.StatusOK)
+}
+
+// Removes a stopped instance
+func (h *Handler) HandleRemoveInstance(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	if err := h.instances.Remove(r.FormValue("name")); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to remove instance: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	respondWithMessage(w, "Instance removed", http.StatusOK)
+}
+
+// Starts the proxy and then every backend, in the background
+func (h *Handler) HandleStartAll(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	go func() {
+		if err := h.instances.StartAll(); err != nil {
+			log.Printf("Failed to start all instances: %v", err)
+			h.server.AddLog(fmt.Sprintf("Failed to start all instances: %v", err))
+		}
+	}()
+
+	respondWithMessage(w, "Starting all instances...", http.StatusOK)
+}
+
+// Stops every backend and then the proxy, in the background
+func (h *Handler) HandleStopAll(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	go func() {
+		if err := h.instances.StopAll(); err != nil {
+			log.Printf("Failed to stop all instances: %v", err)
+			h.server.AddLog(fmt.Sprintf("Failed to stop all instances: %v", err))
+		}
+	}()
+
+	respondWithMessage(w, "Stopping all instances...", http.StatusOK)
+}
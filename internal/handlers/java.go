@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"minecrap_hoster/internal/jdk"
+	"minecrap_hoster/internal/provision"
+	"net/http"
+	"strings"
+)
+
+// Java runtime of one instance and whether it suits the server's Minecraft version
+type instanceJava struct {
+	Name      string `json:"name"`
+	Java      string `json:"java"`
+	Version   string `json:"version,omitempty"`
+	Minecraft string `json:"minecraft,omitempty"`
+	Required  int    `json:"required,omitempty"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Lists the installed Java runtimes and the one each instance uses
+func (h *Handler) HandleJava(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	var used []instanceJava
+	for _, status := range h.instances.List() {
+		instance, err := h.instances.Get(status.Name)
+		if err != nil || !instance.Server.Flavor().NeedsJava() {
+			continue
+		}
+
+		config := instance.Server.Config()
+		entry := instanceJava{
+			Name:      status.Name,
+			Java:      config.JavaPath,
+			Minecraft: provision.DetectMinecraftVersion(config.WorkingDir, config.ExecutablePath),
+		}
+		entry.Required = jdk.RequiredMajor(entry.Minecraft)
+		if runtime, err := jdk.Probe(config.JavaPath); err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.Version = runtime.Version
+			entry.OK = runtime.Major >= entry.Required
+		}
+		used = append(used, entry)
+	}
+
+	respondWithJSON(w, map[string]interface{}{
+		"runtimes":  jdk.Discover(h.javaDirs),
+		"instances": used,
+	})
+}
+
+// Switches an instance to another Java runtime: a path, a command or "auto"
+func (h *Handler) HandleSelectJava(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	java := r.FormValue("java")
+	if java == "" {
+		http.Error(w, "java is required", http.StatusBadRequest)
+		return
+	}
+
+	path, err := h.instances.SetJava(r.FormValue("instance"), java)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to select Java: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	respondWithMessage(w, fmt.Sprintf("Java runtime set to %s", path), http.StatusOK)
+}
+
+// Shows the exact command line the server would be started with
+func (h *Handler) HandleCommandLine(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	server, err := h.serverFor(w, r)
+	if err != nil {
+		return
+	}
+
+	args := server.CommandLine()
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+
+	respondWithJSON(w, map[string]interface{}{
+		"args":    args,
+		"command": strings.Join(quoted, " "),
+		"dir":     server.Dir(),
+	})
+}
+
+// Quotes an argument for a POSIX shell when it needs it
+func shellQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
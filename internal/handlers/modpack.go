@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"minecrap_hoster/internal/minecraft"
+	"minecrap_hoster/internal/modpack"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Returns the modpack installed in the server directory and whether an import is running
+func (h *Handler) HandleModpack(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	record, err := modpack.LoadRecord(h.server.Dir())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read modpack record: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, map[string]interface{}{"pack": record, "importing": h.modpack.Busy()})
+}
+
+// Accepts a multipart "file" upload of a .mrpack and imports it in the background
+func (h *Handler) HandleImportModpack(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	if h.server.Status != minecraft.Stopped {
+		http.Error(w, "Stop the server before importing a modpack", http.StatusConflict)
+		return
+	}
+	if h.modpack.Busy() {
+		http.Error(w, modpack.ErrBusy.Error(), http.StatusConflict)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, modpack.MaxPackSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing .mrpack upload", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if !strings.HasSuffix(strings.ToLower(header.Filename), ".mrpack") {
+		http.Error(w, "Modpack file must be a .mrpack", http.StatusBadRequest)
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "import-*.mrpack")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to store upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		http.Error(w, fmt.Sprintf("Failed to store upload: %v", err), http.StatusBadRequest)
+		return
+	}
+	tmp.Close()
+
+	// Downloads can take far longer than the HTTP write timeout
+	go h.importModpack(tmp.Name(), header.Filename)
+
+	respondWithJSON(w, map[string]string{"status": "importing"})
+}
+
+func (h *Handler) importModpack(path, name string) {
+	defer os.Remove(path)
+
+	h.server.AddLog(fmt.Sprintf("Importing modpack %s...", name))
+	result, err := h.modpack.Import(context.Background(), h.server.Dir(), path)
+	if err != nil {
+		log.Printf("Modpack import failed: %v", err)
+		h.server.AddLog(fmt.Sprintf("Modpack import failed: %v", err))
+		return
+	}
+
+	h.server.AddLog(fmt.Sprintf("Imported %s %s: %d files installed, %d client-only skipped, %d stale removed",
+		result.Record.Name, result.Record.VersionID, len(result.Downloaded), len(result.Skipped), len(result.Removed)))
+}
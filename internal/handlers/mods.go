@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"minecrap_hoster/internal/backup"
+	"minecrap_hoster/internal/mods"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Lists the Fabric mods installed in the server's mods directory
+func (h *Handler) HandleMods(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	installed, err := h.mods.Installed()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to scan mods: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, installed)
+}
+
+// Lists the mods in the disabled directory
+func (h *Handler) HandleDisabledMods(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	disabled, err := h.mods.Disabled()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to scan disabled mods: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, disabled)
+}
+
+// Lists mod changes waiting for a restart
+func (h *Handler) HandlePendingMods(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	respondWithJSON(w, h.mods.Pending())
+}
+
+// Runs the dependency and conflict check without starting the server
+func (h *Handler) HandleCheckMods(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	game := mods.DetectGameVersions(h.server.Config().ExecutablePath)
+	report, err := mods.Check(filepath.Join(h.server.Dir(), mods.ModsDir), game)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to check mods: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, report)
+}
+
+// Accepts a multipart "file" upload of a Fabric mod jar
+func (h *Handler) HandleUploadMod(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, mods.MaxUploadSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing mod jar upload", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	mod, queued, err := h.mods.Upload(filepath.Base(header.Filename), file)
+	if err != nil {
+		log.Printf("Rejected mod upload %s: %v", header.Filename, err)
+		http.Error(w, fmt.Sprintf("Failed to upload mod: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	respondWithJSON(w, map[string]interface{}{"mod": mod, "pending_restart": queued})
+}
+
+// Moves a mod to the disabled directory
+func (h *Handler) HandleDisableMod(w http.ResponseWriter, r *http.Request) {
+	h.handleModChange(w, r, h.mods.Disable)
+}
+
+// Moves a disabled mod back into the mods directory
+func (h *Handler) HandleEnableMod(w http.ResponseWriter, r *http.Request) {
+	h.handleModChange(w, r, h.mods.Enable)
+}
+
+// Deletes a mod jar
+func (h *Handler) HandleDeleteMod(w http.ResponseWriter, r *http.Request) {
+	h.handleModChange(w, r, h.mods.Delete)
+}
+
+func (h *Handler) handleModChange(w http.ResponseWriter, r *http.Request, change func(string) (bool, error)) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	queued, err := change(r.FormValue("file"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to change mod: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	respondWithJSON(w, map[string]bool{"pending_restart": queued})
+}
+
+// Lists newer compatible versions of the installed mods
+func (h *Handler) HandleModUpdates(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	updates, err := h.checkModUpdates(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to check for updates: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	respondWithJSON(w, updates)
+}
+
+// Installs the available update for one mod after backing up the mods
+// directory, in the background, reporting progress in the console log
+func (h *Handler) HandleApplyModUpdate(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	file := r.FormValue("file")
+	if file == "" {
+		http.Error(w, "Missing mod file", http.StatusBadRequest)
+		return
+	}
+	target := formValueOr(r, "target", backup.DefaultTarget().Name)
+	if h.backups.Restoring() {
+		http.Error(w, backup.ErrRestoring.Error(), http.StatusConflict)
+		return
+	}
+
+	if !h.modUpdating.CompareAndSwap(false, true) {
+		http.Error(w, "A mod update is already running", http.StatusConflict)
+		return
+	}
+
+	// Hashing, the backup and the download can take far longer than the HTTP write timeout
+	go func() {
+		defer h.modUpdating.Store(false)
+		h.applyModUpdate(file, target)
+	}()
+
+	respondWithJSON(w, map[string]string{"status": "updating"})
+}
+
+func (h *Handler) applyModUpdate(file, target string) {
+	fail := func(message string, err error) {
+		log.Printf("Mod update of %s failed: %s: %v", file, message, err)
+		h.server.AddLog(fmt.Sprintf("Mod update of %s failed: %s: %v", file, message, err))
+	}
+
+	h.server.AddLog(fmt.Sprintf("Checking for an update to %s...", file))
+	ctx := context.Background()
+	updates, err := h.checkModUpdates(ctx)
+	if err != nil {
+		fail("checking for updates", err)
+		return
+	}
+
+	var update *mods.Update
+	for i := range updates {
+		if updates[i].File == file {
+			update = &updates[i]
+			break
+		}
+	}
+	if update == nil {
+		h.server.AddLog(fmt.Sprintf("No update available for %s", file))
+		return
+	}
+
+	h.server.AddLog(fmt.Sprintf("Backing up mods before updating %s to %s...", file, update.VersionNumber))
+	archive, err := h.backups.BackupMods(h.server, target)
+	if err != nil {
+		fail("pre-update backup", err)
+		return
+	}
+
+	path, err := h.updates.Fetch(ctx, *update)
+	if err != nil {
+		fail("download", err)
+		return
+	}
+	defer os.Remove(path)
+
+	jar, err := os.Open(path)
+	if err != nil {
+		fail("reading the download", err)
+		return
+	}
+	defer jar.Close()
+
+	_, queued, err := h.mods.Replace(update.File, update.Download.Filename, jar)
+	if err != nil {
+		fail("install", err)
+		return
+	}
+
+	message := fmt.Sprintf("Updated %s to %s (backup %s)", update.File, update.VersionNumber, archive.Name)
+	if queued {
+		message += "; applies when the server stops"
+	}
+	h.server.AddLog(message)
+}
+
+func (h *Handler) checkModUpdates(ctx context.Context) ([]mods.Update, error) {
+	game := mods.DetectGameVersions(h.server.Config().ExecutablePath)
+	return h.updates.Check(ctx, filepath.Join(h.server.Dir(), mods.ModsDir), game)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"minecrap_hoster/internal/oidc"
+	"net/http"
+	"net/url"
+)
+
+// Cookie tying a single sign-on attempt to the browser that started it
+const oidcStateCookie = "mch_oidc_state"
+
+// Tells the login page which sign-in methods are available
+func (h *Handler) HandleAuthProviders(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	respondWithJSON(w, map[string]bool{
+		"password": h.auth != nil,
+		"oidc":     h.auth != nil && h.oidc != nil,
+	})
+}
+
+// Sends the browser to the identity provider
+func (h *Handler) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+	if h.auth == nil || h.oidc == nil {
+		http.Error(w, "Single sign-on is disabled", http.StatusNotFound)
+		return
+	}
+
+	target, state, err := h.oidc.AuthURL(r.Context(), h.oidcRedirectURL(r))
+	if err != nil {
+		log.Printf("Failed to start single sign-on: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to start single sign-on: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	// Lax so the cookie comes back on the provider's top-level redirect
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     h.path("/api/auth/oidc/"),
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// Completes single sign-on and starts a session for the mapped account
+func (h *Handler) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+	if h.auth == nil || h.oidc == nil {
+		http.Error(w, "Single sign-on is disabled", http.StatusNotFound)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: h.path("/api/auth/oidc/"), MaxAge: -1, HttpOnly: true})
+
+	query := r.URL.Query()
+	if message := query.Get("error"); message != "" {
+		if description := query.Get("error_description"); description != "" {
+			message += ": " + description
+		}
+		h.failOIDCLogin(w, r, "", fmt.Errorf("the identity provider refused the login: %s", message))
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || cookie.Value == "" || cookie.Value != query.Get("state") {
+		h.failOIDCLogin(w, r, "", fmt.Errorf("login state mismatch; start the login again"))
+		return
+	}
+
+	identity, err := h.oidc.Exchange(r.Context(), query.Get("state"), query.Get("code"))
+	if err != nil {
+		h.failOIDCLogin(w, r, "", err)
+		return
+	}
+	user, err := h.auth.SyncExternal(identity.Username, oidc.Source, identity.Role)
+	if err != nil {
+		h.failOIDCLogin(w, r, identity.Username, err)
+		return
+	}
+	if err := h.startSession(w, r, user.Name); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to start session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %s logged in through single sign-on from %s as %s", user.Name, r.RemoteAddr, user.Role)
+	h.recordEvent(r, "/api/auth/oidc/callback", user.Name, http.StatusOK, nil)
+	http.Redirect(w, r, h.path("/"), http.StatusFound)
+}
+
+// Logs and audits a failed single sign-on and shows the reason on the login page
+func (h *Handler) failOIDCLogin(w http.ResponseWriter, r *http.Request, actor string, err error) {
+	log.Printf("Failed single sign-on from %s: %v", r.RemoteAddr, err)
+	h.recordEvent(r, "/api/auth/oidc/callback", actor, http.StatusUnauthorized, err)
+	http.Redirect(w, r, h.path("/login.html")+"?error="+url.QueryEscape(err.Error()), http.StatusFound)
+}
+
+// Returns the callback URL the provider should send the browser back to
+func (h *Handler) oidcRedirectURL(r *http.Request) string {
+	if configured := h.oidc.RedirectURL(); configured != "" {
+		return configured
+	}
+	scheme := "http"
+	if isSecure(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + h.path("/api/auth/oidc/callback")
+}
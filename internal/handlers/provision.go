@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"minecrap_hoster/internal/backup"
+	"minecrap_hoster/internal/minecraft"
+	"minecrap_hoster/internal/provision"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Returns the provisioned server version and whether an install is running
+func (h *Handler) HandleProvision(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	record, err := provision.LoadRecord(h.server.Dir())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read provision record: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, map[string]interface{}{
+		"installed":  record,
+		"jar":        h.server.Config().ExecutablePath,
+		"installing": h.provisioner.Busy(),
+	})
+}
+
+// Lists the Minecraft versions available for a flavor
+func (h *Handler) HandleProvisionVersions(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	versions, err := h.provisioner.Versions(r.Context(), r.URL.Query().Get("flavor"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list versions: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	respondWithJSON(w, versions)
+}
+
+// Installs or upgrades the server jar in the background, backing up the world first
+func (h *Handler) HandleProvisionInstall(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	if h.server.Status != minecraft.Stopped {
+		http.Error(w, "Stop the server before changing its version", http.StatusConflict)
+		return
+	}
+	req := provision.Request{
+		Flavor:    r.FormValue("flavor"),
+		Minecraft: r.FormValue("minecraft"),
+		Loader:    r.FormValue("loader"),
+	}
+	if _, err := provision.ParseRequest(req.Flavor); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target := formValueOr(r, "target", backup.DefaultTarget().Name)
+
+	// Held from before the backup, so the server can't start until the install is done
+	if !h.provisioner.Reserve() {
+		http.Error(w, provision.ErrBusy.Error(), http.StatusConflict)
+		return
+	}
+
+	// Downloads can take far longer than the HTTP write timeout
+	go func() {
+		defer h.provisioner.Release()
+		h.provisionServer(req, target)
+	}()
+
+	respondWithJSON(w, map[string]string{"status": "installing"})
+}
+
+func (h *Handler) provisionServer(req provision.Request, target string) {
+	h.server.AddLog(fmt.Sprintf("Installing %s %s...", req.Flavor, req.Minecraft))
+
+	if _, err := os.Stat(h.server.WorldPath()); err == nil {
+		archive, err := h.backups.BackupWorld(h.server, target)
+		if err != nil {
+			log.Printf("Pre-upgrade backup failed: %v", err)
+			h.server.AddLog(fmt.Sprintf("Install cancelled, pre-upgrade backup failed: %v", err))
+			return
+		}
+		h.server.AddLog(fmt.Sprintf("Backup %s created in %s", archive.Name, archive.Target))
+	}
+
+	record, err := h.provisioner.Install(context.Background(), h.server.Dir(), req)
+	if err != nil {
+		log.Printf("Server install failed: %v", err)
+		h.server.AddLog(fmt.Sprintf("Server install failed: %v", err))
+		return
+	}
+
+	jar, err := filepath.Abs(filepath.Join(h.server.Dir(), record.Jar))
+	if err == nil {
+		err = h.server.SetExecutablePath(jar)
+	}
+	if err != nil {
+		h.server.AddLog(fmt.Sprintf("Installed %s but could not switch to it: %v", record.Jar, err))
+		return
+	}
+
+	h.server.AddLog(fmt.Sprintf("Installed %s %s (%s)", record.Flavor, record.Minecraft, record.Jar))
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type secureKey struct{}
+
+// Middleware taking the client address and scheme of requests relayed by a
+// trusted reverse proxy from its X-Forwarded-For and X-Forwarded-Proto
+// headers. Anyone else's forwarding headers are ignored, so clients can't
+// spoof their address.
+func (h *Handler) fromProxy(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(h.trustedProxies) == 0 || !h.isTrustedProxy(clientIP(r)) {
+			next(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			// A proxy chain lists the scheme the client used first
+			proto, _, _ = strings.Cut(proto, ",")
+			ctx = context.WithValue(ctx, secureKey{}, strings.EqualFold(strings.TrimSpace(proto), "https"))
+		}
+		r = r.WithContext(ctx)
+		if ip := h.forwardedFor(r); ip != "" {
+			r.RemoteAddr = ip
+		}
+		next(w, r)
+	}
+}
+
+// Returns the client address from X-Forwarded-For: the last entry not added
+// by a trusted proxy, since entries further left may be made up by the client
+func (h *Handler) forwardedFor(r *http.Request) string {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			break
+		}
+		client = hops[i]
+		if !h.isTrustedProxy(client) {
+			break
+		}
+	}
+	return client
+}
+
+func (h *Handler) isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range h.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the browser reached the hoster over HTTPS, directly or
+// through a trusted proxy
+func isSecure(r *http.Request) bool {
+	if secure, ok := r.Context().Value(secureKey{}).(bool); ok {
+		return secure
+	}
+	return r.TLS != nil
+}
+
+// Returns the public path of a route, under the base path
+func (h *Handler) path(route string) string {
+	return h.basePath + route
+}
+
+// Serves requests under the base path with it removed. Requests without it
+// pass through unchanged, for proxies that strip the prefix themselves.
+func (h *Handler) stripBasePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == h.basePath {
+			// The relative URLs of the pages only resolve under the trailing slash
+			target := h.basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, h.basePath+"/") {
+			http.StripPrefix(h.basePath, next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
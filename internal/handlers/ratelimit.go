@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"minecrap_hoster/internal/auth"
+	"minecrap_hoster/internal/ratelimit"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Route groups with rate limits of their own
+const (
+	RateLogin   = "login"   // Password, two-factor and single sign-on logins
+	RateCommand = "command" // Console commands
+	RateWrite   = "write"   // Other requests that change something
+	RateRead    = "read"    // All other API requests
+)
+
+// The rate limit groups, for validating configuration
+var RateGroups = []string{RateLogin, RateCommand, RateWrite, RateRead}
+
+// Failed logins from one address allowed for an account before it is locked
+// out there, and how long the lockout lasts; it doubles with each further failure
+const (
+	loginFailuresFree = 5
+	loginLockoutBase  = time.Minute
+	loginLockoutMax   = time.Hour
+)
+
+var loginPaths = map[string]bool{
+	"/api/auth/login":         true,
+	"/api/auth/2fa/verify":    true,
+	"/api/auth/oidc/login":    true,
+	"/api/auth/oidc/callback": true,
+}
+
+// A group's limiters; the same rate applies to each client address and each user
+type rateLimit struct {
+	perIP   *ratelimit.Limiter
+	perUser *ratelimit.Limiter
+}
+
+// Returns the rate limit group of a request, or "" for static files
+func rateGroup(r *http.Request) string {
+	switch {
+	case loginPaths[r.URL.Path]:
+		return RateLogin
+	case r.URL.Path == "/api/server/command":
+		return RateCommand
+	case !strings.HasPrefix(r.URL.Path, "/api/"):
+		return ""
+	case !isSafeMethod(r.Method):
+		return RateWrite
+	}
+	return RateRead
+}
+
+// Middleware refusing requests from addresses over their group's rate. It
+// runs before authentication, so guessing passwords or tokens is limited too.
+func (h *Handler) limitClient(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group := rateGroup(r)
+		if limit, ok := h.rateLimits[group]; ok {
+			if wait, tripped := limit.perIP.Allow(clientIP(r)); wait > 0 {
+				h.refuseRate(w, r, group, "", wait, tripped)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// Refuses the request if the user is over their group's rate. Returns
+// whether the request may go on.
+func (h *Handler) limitUser(w http.ResponseWriter, r *http.Request, user auth.User) bool {
+	group := rateGroup(r)
+	limit, ok := h.rateLimits[group]
+	if !ok {
+		return true
+	}
+	if wait, tripped := limit.perUser.Allow(user.Name); wait > 0 {
+		h.refuseRate(w, r, group, user.Name, wait, tripped)
+		return false
+	}
+	return true
+}
+
+// Answers 429 and, the first time a client or user goes over the limit
+// since they were last allowed, logs and audits it. Refused requests that
+// already get an audit entry of their own aren't recorded twice.
+func (h *Handler) refuseRate(w http.ResponseWriter, r *http.Request, group, user string, wait time.Duration, tripped bool) {
+	if tripped {
+		who := clientIP(r)
+		if user != "" {
+			who = fmt.Sprintf("user %s", user)
+		}
+		log.Printf("Rate limited %s requests from %s", group, who)
+		if !audited(r) {
+			h.recordEvent(r, r.URL.Path, user, http.StatusTooManyRequests, fmt.Errorf("%s rate limit exceeded by %s", group, who))
+		}
+	}
+	respondWithRetryAfter(w, "Too many requests; slow down", wait)
+}
+
+// Key of the login lockout; failures count per account and address, so
+// others can't lock an account out everywhere
+func loginKey(r *http.Request, name string) string {
+	return name + "|" + clientIP(r)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"minecrap_hoster/internal/scheduler"
+	"net/http"
+	"strconv"
+)
+
+// Lists scheduled jobs
+func (h *Handler) HandleSchedules(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	respondWithJSON(w, h.scheduler.Jobs())
+}
+
+// Creates a job from a JSON body
+func (h *Handler) HandleCreateSchedule(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	job, err := decodeJob(r)
+	if err != nil {
+		http.Error(w, "Invalid job JSON", http.StatusBadRequest)
+		return
+	}
+	if job.Task.Type == scheduler.TaskCommand && !h.allowCommand(w, r, job.Task.Command) {
+		return
+	}
+
+	created, err := h.scheduler.Create(job)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create job: %v", err), http.StatusBadRequest)
+		return
+	}
+	respondWithJSON(w, created)
+}
+
+// Replaces a job definition from a JSON body containing its ID
+func (h *Handler) HandleUpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	job, err := decodeJob(r)
+	if err != nil {
+		http.Error(w, "Invalid job JSON", http.StatusBadRequest)
+		return
+	}
+	if job.Task.Type == scheduler.TaskCommand && !h.allowCommand(w, r, job.Task.Command) {
+		return
+	}
+
+	updated, err := h.scheduler.Update(job)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update job: %v", err), http.StatusBadRequest)
+		return
+	}
+	respondWithJSON(w, updated)
+}
+
+// Reads a job from the request body and notes it for the audit log. Jobs are
+// enabled unless the body says otherwise, so one posted without "enabled"
+// doesn't silently never run.
+func decodeJob(r *http.Request) (scheduler.Job, error) {
+	job := scheduler.Job{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		return job, err
+	}
+
+	noteParams(r, map[string]string{
+		"id":        job.ID,
+		"name":      job.Name,
+		"schedule":  job.Schedule,
+		"time_zone": job.TimeZone,
+		"enabled":   strconv.FormatBool(job.Enabled),
+		"task":      job.Task.Type,
+		"command":   job.Task.Command,
+		"message":   job.Task.Message,
+		"target":    job.Task.Target,
+	})
+	return job, nil
+}
+
+// Deletes a job
+func (h *Handler) HandleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	if err := h.scheduler.Delete(r.FormValue("id")); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete job: %v", err), http.StatusNotFound)
+		return
+	}
+	respondWithMessage(w, "Job deleted", http.StatusOK)
+}
+
+// Runs a job immediately
+func (h *Handler) HandleRunSchedule(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+
+	job, err := h.scheduler.Get(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to run job: %v", err), http.StatusNotFound)
+		return
+	}
+	// Operators may run jobs, but not commands they couldn't send themselves
+	if job.Task.Type == scheduler.TaskCommand && !h.allowCommand(w, r, job.Task.Command) {
+		return
+	}
+
+	if err := h.scheduler.RunNow(job.ID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to run job: %v", err), http.StatusNotFound)
+		return
+	}
+	respondWithMessage(w, "Job started", http.StatusOK)
+}
+
+// Returns the run history, optionally filtered by job ID
+func (h *Handler) HandleScheduleHistory(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	respondWithJSON(w, h.scheduler.History(r.URL.Query().Get("id"), limit))
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
+	"minecrap_hoster/internal/minecraft"
 	"net/http"
 	"strings"
 	"sync"
@@ -27,19 +28,26 @@ func DefaultSSEConfig() SSEConfig {
 
 // Manages the state of an SSE connection
 type sseConnection struct {
-	writer   http.ResponseWriter
-	flusher  http.Flusher
-	seenLogs map[string]bool
-	lastLen  int
-	status   uint8
-	config   SSEConfig
+	server    *minecraft.MinecraftServer
+	writer    http.ResponseWriter
+	flusher   http.Flusher
+	seenLogs  map[string]bool
+	lastLen   int
+	status    string
+	countdown string
+	config    SSEConfig
 }
 
 // Streams server logs using Server-Sent Events
 func (h *Handler) HandleLogs(w http.ResponseWriter, r *http.Request) {
 	log.Printf("New SSE connection from %s", r.RemoteAddr)
 
-	conn, err := newSSEConnection(w, DefaultSSEConfig())
+	server, err := h.serverFor(w, r)
+	if err != nil {
+		return
+	}
+
+	conn, err := newSSEConnection(w, server, DefaultSSEConfig())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -53,7 +61,7 @@ func (h *Handler) HandleLogs(w http.ResponseWriter, r *http.Request) {
 	conn.streamLogs(h, r.Context().Done())
 }
 
-func newSSEConnection(w http.ResponseWriter, config SSEConfig) (*sseConnection, error) {
+func newSSEConnection(w http.ResponseWriter, server *minecraft.MinecraftServer, config SSEConfig) (*sseConnection, error) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		return nil, fmt.Errorf("streaming unsupported")
@@ -62,6 +70,7 @@ func newSSEConnection(w http.ResponseWriter, config SSEConfig) (*sseConnection,
 	setSSEHeaders(w)
 
 	return &sseConnection{
+		server:   server,
 		writer:   w,
 		flusher:  flusher,
 		seenLogs: make(map[string]bool),
@@ -71,11 +80,10 @@ func newSSEConnection(w http.ResponseWriter, config SSEConfig) (*sseConnection,
 
 func setSSEHeaders(w http.ResponseWriter) {
 	headers := map[string]string{
-		"Content-Type":                "text/event-stream",
-		"Cache-Control":               "no-cache",
-		"Connection":                  "keep-alive",
-		"Access-Control-Allow-Origin": "*",
-		"X-Accel-Buffering":           "no",
+		"Content-Type":      "text/event-stream",
+		"Cache-Control":     "no-cache",
+		"Connection":        "keep-alive",
+		"X-Accel-Buffering": "no",
 	}
 
 	for key, value := range headers {
@@ -90,8 +98,8 @@ func (c *sseConnection) initialize(h *Handler) error {
 	}
 
 	// Send initial status
-	c.status = h.server.Status
-	if err := c.sendEvent("status", getStatusHTML(c.status)); err != nil {
+	c.status = h.statusHTML(c.server)
+	if err := c.sendEvent("status", c.status); err != nil {
 		return fmt.Errorf("failed to send initial status: %v", err)
 	}
 
@@ -104,7 +112,7 @@ func (c *sseConnection) initialize(h *Handler) error {
 }
 
 func (c *sseConnection) sendInitialLogs(h *Handler) error {
-	currentLogs := h.server.GetLogs()
+	currentLogs := c.server.GetLogs()
 	c.lastLen = len(currentLogs)
 
 	newLogs := c.processNewLogs(currentLogs)
@@ -154,6 +162,11 @@ func (c *sseConnection) processUpdates(h *Handler) error {
 		return fmt.Errorf("status check failed: %v", err)
 	}
 
+	// Check restart countdown
+	if err := c.checkCountdown(h); err != nil {
+		return fmt.Errorf("countdown check failed: %v", err)
+	}
+
 	// Check for new logs
 	if err := c.checkNewLogs(h); err != nil {
 		return fmt.Errorf("log check failed: %v", err)
@@ -163,9 +176,9 @@ func (c *sseConnection) processUpdates(h *Handler) error {
 }
 
 func (c *sseConnection) checkStatus(h *Handler) error {
-	currentStatus := h.server.Status
+	currentStatus := h.statusHTML(c.server)
 	if currentStatus != c.status {
-		if err := c.sendEvent("status", getStatusHTML(currentStatus)); err != nil {
+		if err := c.sendEvent("status", currentStatus); err != nil {
 			return err
 		}
 		c.status = currentStatus
@@ -173,8 +186,19 @@ func (c *sseConnection) checkStatus(h *Handler) error {
 	return nil
 }
 
+func (c *sseConnection) checkCountdown(h *Handler) error {
+	current := getCountdownHTML(c.server.CountdownRemaining())
+	if current != c.countdown {
+		if err := c.sendEvent("countdown", current); err != nil {
+			return err
+		}
+		c.countdown = current
+	}
+	return nil
+}
+
 func (c *sseConnection) checkNewLogs(h *Handler) error {
-	currentLogs := h.server.GetLogs()
+	currentLogs := c.server.GetLogs()
 	currentLen := len(currentLogs)
 
 	if currentLen > c.lastLen {
@@ -202,6 +226,8 @@ func (c *sseConnection) processNewLogs(logs []string) []string {
 }
 
 func (c *sseConnection) sendEvent(event, data string) error {
+	// Multi-line data needs one data field per line
+	data = strings.ReplaceAll(data, "\n", "\ndata: ")
 	_, err := fmt.Fprintf(c.writer, "event: %s\ndata: %s\n\n", event, data)
 	if err != nil {
 		return err
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"fmt"
+	"minecrap_hoster/internal/auth"
+	"net/http"
+	"time"
+)
+
+// Lists the caller's API tokens; admins can pass all=true to see everyone's
+func (h *Handler) HandleTokens(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodGet(w, r); err != nil {
+		return
+	}
+	user, err := h.requireTokenUser(w, r)
+	if err != nil {
+		return
+	}
+
+	owner := user.Name
+	if r.URL.Query().Get("all") == "true" && user.Role.Allows(auth.RoleAdmin) {
+		owner = ""
+	}
+	respondWithJSON(w, h.tokens.List(owner))
+}
+
+// Creates an API token for the caller. The secret is only shown in this response.
+func (h *Handler) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+	user, err := h.requireTokenUser(w, r)
+	if err != nil {
+		return
+	}
+
+	scopes, err := auth.ParseScopes(r.FormValue("scopes"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ttl time.Duration
+	if value := r.FormValue("expires"); value != "" {
+		if ttl, err = time.ParseDuration(value); err != nil || ttl <= 0 {
+			http.Error(w, "expires must be a positive duration such as 720h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	instance := r.FormValue("instance")
+	if instance != "" {
+		found, err := h.instances.Get(instance)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		instance = found.Name
+	}
+
+	token, secret, err := h.tokens.Create(user.Name, r.FormValue("name"), scopes, instance, ttl)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create token: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	respondWithJSON(w, map[string]interface{}{
+		"token":  token,
+		"secret": secret,
+	})
+}
+
+// Revokes one of the caller's tokens; admins can revoke anyone's
+func (h *Handler) HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+	user, err := h.requireTokenUser(w, r)
+	if err != nil {
+		return
+	}
+
+	token, ok := h.tokens.Get(r.FormValue("id"))
+	if !ok || (token.User != user.Name && !user.Role.Allows(auth.RoleAdmin)) {
+		http.Error(w, "Unknown token", http.StatusNotFound)
+		return
+	}
+	if err := h.tokens.Revoke(token.ID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to revoke token: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respondWithMessage(w, "Token revoked", http.StatusOK)
+}
+
+// Returns the logged-in user, failing when accounts or tokens are disabled
+func (h *Handler) requireTokenUser(w http.ResponseWriter, r *http.Request) (auth.User, error) {
+	user, ok := auth.UserFrom(r.Context())
+	if !ok || h.tokens == nil {
+		http.Error(w, "API tokens are disabled", http.StatusNotFound)
+		return auth.User{}, fmt.Errorf("API tokens are disabled")
+	}
+	return user, nil
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"minecrap_hoster/internal/auth"
+	"net/http"
+)
+
+// Cookie holding a login that waits for its second factor
+const challengeCookie = "mch_2fa"
+
+// Routes a user who must set up two-factor authentication can still use
+var twoFactorSetupRoutes = map[string]bool{
+	"/api/auth/me":         true,
+	"/api/auth/password":   true,
+	"/api/auth/2fa/setup":  true,
+	"/api/auth/2fa/enable": true,
+}
+
+// Reports whether a user's role requires two-factor authentication they haven't set up.
+// Single sign-on accounts are left to the identity provider.
+func (h *Handler) needsTwoFactor(user auth.User) bool {
+	return h.auth != nil && h.twoFactorRoles[user.Role] && user.Local() && !user.TwoFactor
+}
+
+// Asks for the second factor of a login whose password checked out
+func (h *Handler) startChallenge(w http.ResponseWriter, r *http.Request, name string) error {
+	token, expires, err := h.auth.NewChallenge(name)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     challengeCookie,
+		Value:    token,
+		Path:     h.path("/api/auth/"),
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, `{"two_factor":true}`)
+	return nil
+}
+
+// Finishes a login with a TOTP or recovery code
+func (h *Handler) HandleVerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+	if err := h.requireAccounts(w); err != nil {
+		return
+	}
+
+	cookie, err := r.Cookie(challengeCookie)
+	if err != nil {
+		http.Error(w, "No login is waiting for a code; log in again", http.StatusUnauthorized)
+		return
+	}
+	user, err := h.auth.CompleteChallenge(cookie.Value, r.FormValue("code"))
+	if user.Name != "" {
+		noteActor(r, user, nil)
+	}
+	if err != nil {
+		log.Printf("Failed two-factor login for %q from %s: %v", user.Name, r.RemoteAddr, err)
+		respondWithCodeError(w, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: challengeCookie, Path: h.path("/api/auth/"), MaxAge: -1, HttpOnly: true})
+	if err := h.startSession(w, r, user.Name); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to start session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %s logged in with two-factor authentication from %s", user.Name, r.RemoteAddr)
+	respondWithJSON(w, user)
+}
+
+// Creates a TOTP secret and returns it with the provisioning URI for a QR code
+func (h *Handler) HandleSetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+	user, ok := auth.UserFrom(r.Context())
+	if !ok {
+		http.Error(w, "Accounts are disabled", http.StatusNotFound)
+		return
+	}
+
+	secret, err := h.auth.BeginTOTP(user.Name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to set up two-factor authentication: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	respondWithJSON(w, map[string]string{
+		"secret": secret,
+		"uri":    auth.ProvisioningURI(h.totpIssuer, user.Name, secret),
+	})
+}
+
+// Turns on two-factor authentication with a first code, returning the recovery codes
+func (h *Handler) HandleEnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+	user, ok := auth.UserFrom(r.Context())
+	if !ok {
+		http.Error(w, "Accounts are disabled", http.StatusNotFound)
+		return
+	}
+
+	codes, err := h.auth.EnableTOTP(user.Name, r.FormValue("code"))
+	if err != nil {
+		respondWithCodeError(w, err)
+		return
+	}
+	respondWithJSON(w, map[string][]string{"recovery_codes": codes})
+}
+
+// Turns off two-factor authentication after checking the password and a code
+func (h *Handler) HandleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+	user, ok := auth.UserFrom(r.Context())
+	if !ok {
+		http.Error(w, "Accounts are disabled", http.StatusNotFound)
+		return
+	}
+
+	if user.TwoFactor && h.twoFactorRoles[user.Role] {
+		http.Error(w, fmt.Sprintf("Two-factor authentication is required for the %s role", user.Role), http.StatusForbidden)
+		return
+	}
+	if _, err := h.auth.Authenticate(user.Name, r.FormValue("password")); err != nil {
+		http.Error(w, "Password is wrong", http.StatusForbidden)
+		return
+	}
+	if user.TwoFactor {
+		if err := h.auth.VerifySecondFactor(user.Name, r.FormValue("code")); err != nil {
+			respondWithCodeError(w, err)
+			return
+		}
+	}
+	if err := h.auth.DisableTOTP(user.Name); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to turn off two-factor authentication: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respondWithMessage(w, "Two-factor authentication turned off", http.StatusOK)
+}
+
+// Replaces the recovery codes after checking a code
+func (h *Handler) HandleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if err := AssertMethodPost(w, r); err != nil {
+		return
+	}
+	user, ok := auth.UserFrom(r.Context())
+	if !ok {
+		http.Error(w, "Accounts are disabled", http.StatusNotFound)
+		return
+	}
+
+	if err := h.auth.VerifySecondFactor(user.Name, r.FormValue("code")); err != nil {
+		respondWithCodeError(w, err)
+		return
+	}
+	codes, err := h.auth.NewRecoveryCodes(user.Name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create recovery codes: %v", err), http.StatusInternalServerError)
+		return
+	}
+	respondWithJSON(w, map[string][]string{"recovery_codes": codes})
+}
+
+// Refuses a wrong code or expired login with 401, or with 429 once the account is locked
+func respondWithCodeError(w http.ResponseWriter, err error) {
+	var locked *auth.LockedError
+	switch {
+	case errors.As(err, &locked):
+		respondWithRetryAfter(w, err.Error(), locked.RetryAfter)
+	case errors.Is(err, auth.ErrInvalidCode), errors.Is(err, auth.ErrLoginExpired):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,149 @@
+package hostmem
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	meminfoPath = "/proc/meminfo"
+	cgroupPath  = "/proc/self/cgroup"
+	cgroupRoot  = "/sys/fs/cgroup"
+)
+
+// Smallest heap a server is sized to, however little memory is left
+const MinHeapMB = 512
+
+// Memory a JVM uses beyond its heap: metaspace, code cache, thread stacks
+// and GC structures. Roughly a fifth of the heap plus a fixed base.
+const (
+	jvmBaseOverheadMB = 256
+	jvmOverheadRatio  = 5
+)
+
+// Info is the memory available to the hoster and its servers
+type Info struct {
+	TotalMB     int `json:"total_mb"`     // Physical memory
+	AvailableMB int `json:"available_mb"` // Free plus reclaimable memory right now
+	CgroupMB    int `json:"cgroup_mb"`    // Container limit, 0 when unlimited
+}
+
+// Returns the memory servers may use: the physical total or the cgroup limit, whichever is lower
+func (i Info) LimitMB() int {
+	if i.CgroupMB > 0 && i.CgroupMB < i.TotalMB {
+		return i.CgroupMB
+	}
+	return i.TotalMB
+}
+
+// Reads /proc/meminfo and the cgroup memory limit of this process
+func Detect() (Info, error) {
+	var info Info
+
+	file, err := os.Open(meminfoPath)
+	if err != nil {
+		return info, fmt.Errorf("failed to read host memory: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			info.TotalMB = kb / 1024
+		case "MemAvailable:":
+			info.AvailableMB = kb / 1024
+		}
+	}
+	if info.TotalMB == 0 {
+		return info, fmt.Errorf("no MemTotal in %s", meminfoPath)
+	}
+
+	info.CgroupMB = cgroupLimitMB()
+	return info, nil
+}
+
+// Finds the lowest memory limit on this process's cgroup and its parents,
+// for cgroup v2 and v1. Returns 0 when there is none.
+func cgroupLimitMB() int {
+	data, err := os.ReadFile(cgroupPath)
+	if err != nil {
+		return 0
+	}
+
+	limit := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		var base, file string
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			base, file = cgroupRoot, "memory.max"
+		case strings.Contains(","+parts[1]+",", ",memory,"):
+			base, file = filepath.Join(cgroupRoot, "memory"), "memory.limit_in_bytes"
+		default:
+			continue
+		}
+
+		// Inside a container the cgroup is usually mounted as the root, so
+		// walk from the full path up to the mount point
+		for dir := parts[2]; ; dir = path.Dir(dir) {
+			if mb := readLimitMB(filepath.Join(base, filepath.FromSlash(dir), file)); mb > 0 && (limit == 0 || mb < limit) {
+				limit = mb
+			}
+			if dir == "/" || dir == "." {
+				break
+			}
+		}
+	}
+	return limit
+}
+
+// Reads a cgroup limit file; "max" and v1's huge unlimited value mean no limit
+func readLimitMB(file string) int {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0
+	}
+	bytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || bytes <= 0 || bytes >= 1<<60 {
+		return 0
+	}
+	return int(bytes >> 20)
+}
+
+// Estimates the memory a JVM with the given heap takes in total
+func Footprint(heapMB int) int {
+	return heapMB + heapMB/jvmOverheadRatio + jvmBaseOverheadMB
+}
+
+// Returns the largest heap whose footprint fits in the given memory, rounded
+// down to 128 MB and never below MinHeapMB
+func HeapFor(footprintMB int) int {
+	heap := (footprintMB - jvmBaseOverheadMB) * jvmOverheadRatio / (jvmOverheadRatio + 1)
+	heap -= heap % 128
+	if heap < MinHeapMB {
+		return MinHeapMB
+	}
+	return heap
+}
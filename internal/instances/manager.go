@@ -0,0 +1,562 @@
+package instances
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"minecrap_hoster/internal/hostmem"
+	"minecrap_hoster/internal/jdk"
+	"minecrap_hoster/internal/minecraft"
+	"minecrap_hoster/internal/provision"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// How long the proxy may take to come up before backends are started anyway
+const proxyReadyTimeout = 2 * time.Minute
+
+// How long StopAll waits for each group of instances to exit
+const stopTimeout = 2 * time.Minute
+
+var namePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// Manager runs the extra server instances alongside the main one, keeps the
+// proxy's server list in step with the backends and orders startup and
+// shutdown around the proxy.
+type Manager struct {
+	mutex     sync.Mutex
+	path      string
+	base      minecraft.ServerConfig // Main server settings, used as defaults
+	options   Options
+	main      *Instance
+	instances []*Instance // Extra instances in the order they were added
+}
+
+// Creates a manager around the main server and loads the saved instances
+func NewManager(path string, main *minecraft.MinecraftServer, options Options) (*Manager, error) {
+	base := main.Config()
+	base.Flavor = main.Flavor()
+	if options.Java == "" {
+		options.Java = base.JavaPath
+	}
+	m := &Manager{
+		path:    path,
+		base:    base,
+		options: options,
+		main: &Instance{
+			Config: Config{
+				Name:   MainInstance,
+				Role:   RoleBackend,
+				Dir:    main.Dir(),
+				Jar:    base.ExecutablePath,
+				Flavor: main.Flavor().Name(),
+
+				InitialMemoryMB: base.InitialMemoryMB,
+			},
+			Server: main,
+		},
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		m.rebalance()
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instances: %v", err)
+	}
+
+	var configs []Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse instances: %v", err)
+	}
+	for _, config := range configs {
+		instance, err := m.newInstance(config)
+		if err != nil {
+			return nil, fmt.Errorf("instance %q: %v", config.Name, err)
+		}
+		m.instances = append(m.instances, instance)
+	}
+
+	m.rebalance()
+	if err := m.syncProxy(); err != nil {
+		log.Printf("Failed to update proxy server list: %v", err)
+	}
+	return m, nil
+}
+
+// Validates a config, fills in defaults and creates its server
+func (m *Manager) newInstance(config Config) (*Instance, error) {
+	if !namePattern.MatchString(config.Name) || config.Name == MainInstance {
+		return nil, fmt.Errorf("invalid instance name %q", config.Name)
+	}
+
+	switch config.Role {
+	case RoleProxy:
+		if config.Flavor == "" {
+			config.Flavor = "velocity"
+		}
+		if config.MemoryMB == 0 {
+			config.MemoryMB = 512
+		}
+	case RoleBackend:
+		if config.Flavor == "" {
+			config.Flavor = m.base.Flavor.Name()
+		}
+	default:
+		return nil, fmt.Errorf("role must be %q or %q", RoleProxy, RoleBackend)
+	}
+
+	flavor, err := minecraft.FlavorByName(config.Flavor)
+	if err != nil {
+		return nil, err
+	}
+	if config.Role == RoleProxy && flavor.Name() != "velocity" {
+		return nil, fmt.Errorf("proxies must use the velocity flavor")
+	}
+
+	dir, err := filepath.Abs(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("directory %s is not accessible", dir)
+	}
+
+	jar := config.Jar
+	if jar == "" {
+		jar = minecraft.FindExecutable(flavor, dir)
+	} else if !filepath.IsAbs(jar) {
+		jar = filepath.Join(dir, jar)
+	}
+	if jar == "" {
+		return nil, fmt.Errorf("jar must be set for %s instances", flavor.Name())
+	}
+	if _, err := os.Stat(jar); err != nil {
+		return nil, fmt.Errorf("server jar %s not found", jar)
+	}
+
+	server := m.base
+	server.Flavor = flavor
+	server.WorkingDir = dir
+	server.ExecutablePath = jar
+	if flavor.NeedsJava() {
+		if server.JavaPath, err = m.resolveJava(config.Java, dir, jar); err != nil {
+			return nil, err
+		}
+	}
+	if config.MemoryMB > 0 {
+		server.MemoryUtilizationMB = config.MemoryMB
+		server.InitialMemoryMB = 0 // The main server's -Xms may not fit
+	}
+	if config.Role == RoleProxy || !flavor.NeedsJava() {
+		// The main server's tuning is meant for a Java game server
+		server.InitialMemoryMB = 0
+		server.JVMPreset = ""
+		server.JVMFlags = nil
+		server.SystemProperties = nil
+	}
+	if config.InitialMemoryMB > 0 {
+		server.InitialMemoryMB = config.InitialMemoryMB
+	}
+	if config.JVMPreset != "" {
+		server.JVMPreset = config.JVMPreset
+	}
+	if config.JVMFlags != nil {
+		server.JVMFlags = config.JVMFlags
+	}
+	if config.JVMProps != nil {
+		server.SystemProperties = config.JVMProps
+	}
+	server.ServerArgs = config.ServerArgs
+	if err := minecraft.ValidateJVMArgs(server); err != nil {
+		return nil, err
+	}
+
+	instance := &Instance{Config: config, Server: minecraft.NewServer(server)}
+	if m.options.Setup != nil {
+		m.options.Setup(instance.Server)
+	}
+	return instance, nil
+}
+
+// Resolves an instance's Java setting, falling back to the default one
+func (m *Manager) resolveJava(setting, dir, jar string) (string, error) {
+	if setting == "" {
+		setting = m.options.Java
+	}
+	return jdk.Resolve(setting, provision.DetectMinecraftVersion(dir, jar), m.options.JavaDirs)
+}
+
+// Switches the Java runtime of a stopped instance. The setting may be a path,
+// a command on PATH or "auto"; it is saved for all but the main instance.
+func (m *Manager) SetJava(name, setting string) (string, error) {
+	instance, err := m.Get(name)
+	if err != nil {
+		return "", err
+	}
+	if !instance.Server.Flavor().NeedsJava() {
+		return "", fmt.Errorf("%s servers don't run on Java", instance.Server.Flavor().Name())
+	}
+
+	config := instance.Server.Config()
+	path, err := jdk.Resolve(setting, provision.DetectMinecraftVersion(config.WorkingDir, config.ExecutablePath), m.options.JavaDirs)
+	if err != nil {
+		return "", err
+	}
+	if err := instance.Server.SetJavaPath(path); err != nil {
+		return "", err
+	}
+	if instance == m.main {
+		return path, nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	previous := instance.Java
+	instance.Java = setting
+	if err := m.save(); err != nil {
+		instance.Java = previous
+		return "", err
+	}
+	return path, nil
+}
+
+// Returns the named instance; an empty name is the main server
+func (m *Manager) Get(name string) (*Instance, error) {
+	if name == "" || name == MainInstance {
+		return m.main, nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, instance := range m.instances {
+		if instance.Name == name {
+			return instance, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown instance %q", name)
+}
+
+// Returns every instance with its current state, proxy first
+func (m *Manager) List() []Status {
+	var statuses []Status
+	for _, instance := range m.ordered() {
+		statuses = append(statuses, Status{
+			Config:  instance.Config,
+			Status:  instance.Server.Status,
+			Ready:   instance.Server.Ready(),
+			Players: instance.Server.Players(),
+		})
+	}
+	return statuses
+}
+
+// Adds an instance and, for backends, registers it with the proxy
+func (m *Manager) Add(config Config) error {
+	instance, err := m.newInstance(config)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, existing := range m.instances {
+		if existing.Name == instance.Name {
+			return fmt.Errorf("instance %q already exists", instance.Name)
+		}
+		if existing.Role == RoleProxy && instance.Role == RoleProxy {
+			return fmt.Errorf("a proxy instance already exists")
+		}
+	}
+
+	m.instances = append(m.instances, instance)
+	if err := m.save(); err != nil {
+		m.instances = m.instances[:len(m.instances)-1]
+		return err
+	}
+
+	log.Printf("Added %s instance %s in %s", instance.Role, instance.Name, instance.Dir)
+	m.rebalance()
+	return m.syncProxy()
+}
+
+// Removes a stopped instance and drops it from the proxy's server list
+func (m *Manager) Remove(name string) error {
+	if name == MainInstance {
+		return fmt.Errorf("the main instance can't be removed")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, instance := range m.instances {
+		if instance.Name != name {
+			continue
+		}
+		if instance.Server.Status != minecraft.Stopped {
+			return fmt.Errorf("instance %q must be stopped first", name)
+		}
+
+		m.instances = append(m.instances[:i], m.instances[i+1:]...)
+		if err := m.save(); err != nil {
+			return err
+		}
+		log.Printf("Removed instance %s", name)
+		m.rebalance()
+		return m.syncProxy()
+	}
+	return fmt.Errorf("unknown instance %q", name)
+}
+
+// Starts the proxy, waits for it to be ready, then starts every backend
+func (m *Manager) StartAll() error {
+	var errs []error
+	proxy := m.proxy()
+
+	if proxy != nil && proxy.Server.Status == minecraft.Stopped {
+		if err := proxy.Server.Start(); err != nil {
+			return fmt.Errorf("proxy %s: %w", proxy.Name, err)
+		}
+		if !waitFor(proxy.Server.Ready, proxyReadyTimeout) {
+			errs = append(errs, fmt.Errorf("proxy %s did not finish starting", proxy.Name))
+		}
+	}
+
+	for _, instance := range m.backends() {
+		if instance.Server.Status != minecraft.Stopped {
+			continue
+		}
+		if err := instance.Server.Start(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", instance.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stops every backend, waits for them to exit, then stops the proxy
+func (m *Manager) StopAll() error {
+	var errs []error
+
+	backends := m.backends()
+	for _, instance := range backends {
+		if err := stopInstance(instance); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, instance := range backends {
+		if !waitFor(func() bool { return instance.Server.Status == minecraft.Stopped }, stopTimeout) {
+			errs = append(errs, fmt.Errorf("%s did not stop", instance.Name))
+		}
+	}
+	if len(errs) > 0 {
+		// Keep the proxy up so players aren't cut off from backends still running
+		return errors.Join(errs...)
+	}
+
+	if proxy := m.proxy(); proxy != nil {
+		if err := stopInstance(proxy); err != nil {
+			return err
+		}
+		if !waitFor(func() bool { return proxy.Server.Status == minecraft.Stopped }, stopTimeout) {
+			return fmt.Errorf("proxy %s did not stop", proxy.Name)
+		}
+	}
+	return nil
+}
+
+// Reports whether every instance has stopped
+func (m *Manager) AllStopped() bool {
+	for _, instance := range m.ordered() {
+		if instance.Server.Status != minecraft.Stopped {
+			return false
+		}
+	}
+	return true
+}
+
+func stopInstance(instance *Instance) error {
+	status := instance.Server.Status
+	if status != minecraft.Running && status != minecraft.Starting {
+		return nil
+	}
+	if err := instance.Server.Stop(); err != nil {
+		return fmt.Errorf("%s: %w", instance.Name, err)
+	}
+	return nil
+}
+
+func waitFor(condition func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return true
+}
+
+func (m *Manager) proxy() *Instance {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.proxyLocked()
+}
+
+func (m *Manager) proxyLocked() *Instance {
+	for _, instance := range m.instances {
+		if instance.Role == RoleProxy {
+			return instance
+		}
+	}
+	return nil
+}
+
+// Returns the main server followed by the extra backends
+func (m *Manager) backends() []*Instance {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.backendsLocked()
+}
+
+func (m *Manager) backendsLocked() []*Instance {
+	backends := []*Instance{m.main}
+	for _, instance := range m.instances {
+		if instance.Role == RoleBackend {
+			backends = append(backends, instance)
+		}
+	}
+	return backends
+}
+
+func (m *Manager) ordered() []*Instance {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var ordered []*Instance
+	if proxy := m.proxyLocked(); proxy != nil {
+		ordered = append(ordered, proxy)
+	}
+	return append(ordered, m.backendsLocked()...)
+}
+
+// Writes the backends into the proxy's velocity.toml. Must be called with
+// the mutex held.
+func (m *Manager) syncProxy() error {
+	proxy := m.proxyLocked()
+	if proxy == nil {
+		return nil
+	}
+
+	var backends []Backend
+	for _, instance := range m.backendsLocked() {
+		backends = append(backends, Backend{Name: instance.Name, Address: backendAddress(instance)})
+	}
+
+	path := filepath.Join(proxy.Server.Dir(), velocityConfig)
+	if err := UpdateVelocityServers(path, backends); err != nil {
+		return fmt.Errorf("failed to update %s: %v", path, err)
+	}
+	log.Printf("Updated proxy server list with %d backend(s)", len(backends))
+	return nil
+}
+
+// Returns the maximum heap in MB of every instance that runs on Java
+func (m *Manager) Heaps() map[string]int {
+	heaps := make(map[string]int)
+	for _, instance := range m.ordered() {
+		if instance.Server.Flavor().NeedsJava() {
+			heaps[instance.Name] = instance.Server.Config().MemoryUtilizationMB
+		}
+	}
+	return heaps
+}
+
+// Whether an instance's heap is sized from the memory budget
+func (m *Manager) autoSized(instance *Instance) bool {
+	return m.options.MemoryBudgetMB > 0 && instance.Server.Flavor().NeedsJava() &&
+		(instance == m.main || instance.MemoryMB == 0)
+}
+
+// Splits the memory budget evenly between the stopped auto-sized servers,
+// after the fixed-size and running ones. Running servers keep their heap.
+// Must be called with the mutex held.
+func (m *Manager) rebalance() {
+	if m.options.MemoryBudgetMB <= 0 {
+		return
+	}
+
+	var sized []*Instance
+	used := 0
+	all := m.backendsLocked()
+	if proxy := m.proxyLocked(); proxy != nil {
+		all = append(all, proxy)
+	}
+	for _, instance := range all {
+		if !instance.Server.Flavor().NeedsJava() {
+			continue
+		}
+		if m.autoSized(instance) && instance.Server.Status == minecraft.Stopped {
+			sized = append(sized, instance)
+		} else {
+			used += hostmem.Footprint(instance.Server.Config().MemoryUtilizationMB)
+		}
+	}
+	if len(sized) == 0 {
+		return
+	}
+
+	share := (m.options.MemoryBudgetMB - used) / len(sized)
+	heap := hostmem.HeapFor(share)
+	if hostmem.Footprint(heap) > share {
+		log.Printf("Warning: only %d MB of memory is left for each of %d server(s); using the minimum heap of %d MB", share, len(sized), heap)
+	}
+
+	for _, instance := range sized {
+		// A fixed heap avoids resizing pauses; an explicit smaller -Xms is kept
+		initial := heap
+		if instance.InitialMemoryMB > 0 && instance.InitialMemoryMB < heap {
+			initial = instance.InitialMemoryMB
+		}
+		if err := instance.Server.SetMemory(initial, heap); err != nil {
+			log.Printf("Failed to size %s: %v", instance.Name, err)
+			continue
+		}
+		log.Printf("Sized %s to a %d MB heap", instance.Name, heap)
+	}
+}
+
+func backendAddress(instance *Instance) string {
+	if instance.Address != "" {
+		return instance.Address
+	}
+
+	port := "25565"
+	if props, err := instance.Server.Properties(); err == nil && props["server-port"] != "" {
+		port = props["server-port"]
+	}
+	return "127.0.0.1:" + port
+}
+
+// Must be called with the mutex held
+func (m *Manager) save() error {
+	configs := make([]Config, 0, len(m.instances))
+	for _, instance := range m.instances {
+		configs = append(configs, instance.Config)
+	}
+
+	data, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save instances: %v", err)
+	}
+	return os.Rename(tmp, m.path)
+}
@@ -0,0 +1,59 @@
+package instances
+
+import "minecrap_hoster/internal/minecraft"
+
+// Instance roles
+const (
+	RoleProxy   = "proxy"
+	RoleBackend = "backend"
+)
+
+// Name of the server configured by the hoster's own flags
+const MainInstance = "main"
+
+// Config describes an extra server instance, as saved in the instances file
+type Config struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Dir      string `json:"dir"`
+	Jar      string `json:"jar,omitempty"` // Relative to Dir unless absolute; defaults to the flavor's usual file
+	Flavor   string `json:"flavor,omitempty"`
+	Java     string `json:"java,omitempty"`
+	MemoryMB int    `json:"memory_mb,omitempty"`
+	Address  string `json:"address,omitempty"` // Where the proxy reaches a backend; defaults to 127.0.0.1:<server-port>
+
+	// JVM tuning; backends inherit unset values from the main server
+	InitialMemoryMB int               `json:"initial_memory_mb,omitempty"`
+	JVMPreset       string            `json:"jvm_preset,omitempty"`
+	JVMFlags        []string          `json:"jvm_flags,omitempty"`
+	JVMProps        map[string]string `json:"jvm_props,omitempty"`
+	ServerArgs      []string          `json:"server_args,omitempty"` // Never inherited
+}
+
+// Instance is a managed server process and its configuration
+type Instance struct {
+	Config
+	Server *minecraft.MinecraftServer
+}
+
+// Status summarises an instance for the API
+type Status struct {
+	Config
+	Status  uint8    `json:"status"`
+	Ready   bool     `json:"ready"`
+	Players []string `json:"players"`
+}
+
+// Options configures how instance servers are created
+type Options struct {
+	Java     string   // Java setting for instances that don't name one, possibly "auto"
+	JavaDirs []string // Extra directories searched for JDKs
+
+	// Memory in MB all Java servers may use together, JVM overhead included.
+	// When set, the main server and instances without memory_mb are sized to
+	// share it.
+	MemoryBudgetMB int
+
+	// Registers checks and hooks on each new instance server
+	Setup func(server *minecraft.MinecraftServer)
+}
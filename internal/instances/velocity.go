@@ -0,0 +1,166 @@
+package instances
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Name of the proxy's configuration file
+const velocityConfig = "velocity.toml"
+
+var (
+	tableHeader   = regexp.MustCompile(`^\[[^\[\]]+\]\s*(#.*)?$`)
+	serverEntry   = regexp.MustCompile(`^"?([A-Za-z0-9_.-]+)"?\s*=\s*"[^"]*"\s*(#.*)?$`)
+	tryAssignment = regexp.MustCompile(`^try\s*=\s*\[(.*)$`)
+	quotedName    = regexp.MustCompile(`"([^"]*)"`)
+)
+
+// Backend is a server the proxy forwards players to
+type Backend struct {
+	Name    string
+	Address string
+}
+
+// Rewrites the [servers] table of velocity.toml to list exactly the given
+// backends, keeping comments and every other table. The try order keeps the
+// entries that still exist; if none do, players are sent to the first backend.
+func UpdateVelocityServers(path string, backends []Backend) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+
+	start, end := findTable(lines, "servers")
+	if start < 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "[servers]")
+		start, end = len(lines)-1, len(lines)
+	}
+
+	table := append([]string{lines[start]}, rewriteServers(lines[start+1:end], backends)...)
+	result := append(append(append([]string{}, lines[:start]...), table...), lines[end:]...)
+	output := strings.TrimRight(strings.Join(result, "\n"), "\n") + "\n"
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(output), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Returns the header line of the named table and the line where it ends
+func findTable(lines []string, name string) (int, int) {
+	start := -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !tableHeader.MatchString(trimmed) {
+			continue
+		}
+		if start >= 0 {
+			return start, i
+		}
+		if strings.HasPrefix(trimmed, "["+name+"]") {
+			start = i
+		}
+	}
+	return start, len(lines)
+}
+
+// Replaces the server entries and try list in the body of [servers], in
+// place, keeping comments and blank lines where they were
+func rewriteServers(body []string, backends []Backend) []string {
+	entries := make([]string, 0, len(backends))
+	names := make(map[string]bool)
+	for _, backend := range backends {
+		entries = append(entries, fmt.Sprintf("%s = %q", backend.Name, backend.Address))
+		names[backend.Name] = true
+	}
+
+	var result, try []string
+	entriesAt, tryAt := -1, -1
+	inTry := false
+
+	for _, line := range body {
+		trimmed := strings.TrimSpace(line)
+
+		if inTry {
+			try = append(try, quotedNames(trimmed)...)
+			inTry = !strings.Contains(trimmed, "]")
+			continue
+		}
+		if match := tryAssignment.FindStringSubmatch(trimmed); match != nil {
+			try = append(try, quotedNames(match[1])...)
+			inTry = !strings.Contains(match[1], "]")
+			tryAt = len(result)
+			continue
+		}
+		if serverEntry.MatchString(trimmed) {
+			if entriesAt < 0 {
+				entriesAt = len(result)
+			}
+			continue
+		}
+		result = append(result, line)
+	}
+
+	var order []string
+	for _, name := range try {
+		if names[name] {
+			order = append(order, name)
+		}
+	}
+	if len(order) == 0 && len(backends) > 0 {
+		order = []string{backends[0].Name}
+	}
+	quoted := make([]string, len(order))
+	for i, name := range order {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	tryLine := fmt.Sprintf("try = [%s]", strings.Join(quoted, ", "))
+
+	// Trailing blank lines stay between the table and the next one
+	trailing := len(result)
+	for trailing > 0 && strings.TrimSpace(result[trailing-1]) == "" {
+		trailing--
+	}
+	if entriesAt < 0 || entriesAt > trailing {
+		entriesAt = 0
+	}
+	if tryAt < 0 || tryAt > trailing {
+		tryAt = trailing
+	}
+
+	var rewritten []string
+	for i := 0; i <= len(result); i++ {
+		if i == entriesAt {
+			rewritten = append(rewritten, entries...)
+		}
+		if i == tryAt {
+			rewritten = append(rewritten, tryLine)
+		}
+		if i < len(result) {
+			rewritten = append(rewritten, result[i])
+		}
+	}
+	if len(result) == trailing {
+		rewritten = append(rewritten, "")
+	}
+	return rewritten
+}
+
+func quotedNames(text string) []string {
+	var names []string
+	for _, match := range quotedName.FindAllStringSubmatch(text, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
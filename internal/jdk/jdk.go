@@ -0,0 +1,270 @@
+package jdk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Setting that asks for a JDK to be picked by Minecraft version
+const Auto = "auto"
+
+// Where Linux distributions install JDKs
+const systemJVMDir = "/usr/lib/jvm"
+
+// How long a java binary may take to report its settings
+const probeTimeout = 10 * time.Second
+
+// Runtime is an installed Java runtime
+type Runtime struct {
+	Path    string `json:"path"` // java executable
+	Home    string `json:"home"`
+	Version string `json:"version"`
+	Major   int    `json:"major"`
+	Vendor  string `json:"vendor,omitempty"`
+}
+
+type probeResult struct {
+	modTime time.Time
+	runtime Runtime
+	err     error
+}
+
+var (
+	probeMutex sync.Mutex
+	probeCache = make(map[string]probeResult)
+)
+
+// Finds Java runtimes in JAVA_HOME, PATH, /usr/lib/jvm and the extra
+// directories, which may be JDK homes or directories of them. Newest first.
+func Discover(extraDirs []string) []Runtime {
+	var candidates []string
+
+	if home := os.Getenv("JAVA_HOME"); home != "" {
+		candidates = append(candidates, filepath.Join(home, "bin", "java"))
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir != "" {
+			candidates = append(candidates, filepath.Join(dir, "java"))
+		}
+	}
+	for _, dir := range append([]string{systemJVMDir}, extraDirs...) {
+		candidates = append(candidates, filepath.Join(dir, "bin", "java"))
+		matches, _ := filepath.Glob(filepath.Join(dir, "*", "bin", "java"))
+		candidates = append(candidates, matches...)
+	}
+
+	seen := make(map[string]bool)
+	var runtimes []Runtime
+	for _, candidate := range candidates {
+		resolved, err := filepath.EvalSymlinks(candidate)
+		if err != nil || seen[resolved] {
+			continue
+		}
+		seen[resolved] = true
+
+		runtime, err := Probe(candidate)
+		if err != nil {
+			continue
+		}
+		runtimes = append(runtimes, runtime)
+	}
+
+	sort.SliceStable(runtimes, func(i, j int) bool { return runtimes[i].Major > runtimes[j].Major })
+	return runtimes
+}
+
+// Reads a java executable's version with -XshowSettings:properties. Results
+// are cached until the executable changes.
+func Probe(path string) (Runtime, error) {
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return Runtime{}, fmt.Errorf("java executable not found: %v", err)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return Runtime{}, err
+	}
+
+	probeMutex.Lock()
+	cached, ok := probeCache[resolved]
+	probeMutex.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.runtime, cached.err
+	}
+
+	runtime, err := probe(resolved)
+
+	probeMutex.Lock()
+	probeCache[resolved] = probeResult{modTime: info.ModTime(), runtime: runtime, err: err}
+	probeMutex.Unlock()
+	return runtime, err
+}
+
+func probe(path string) (Runtime, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	// The settings are printed to stderr along with the version banner
+	output, err := exec.CommandContext(ctx, path, "-XshowSettings:properties", "-version").CombinedOutput()
+	if err != nil {
+		return Runtime{}, fmt.Errorf("failed to run %s: %v", path, err)
+	}
+
+	props := parseSettings(output)
+	version := props["java.version"]
+	major := MajorVersion(version)
+	if major == 0 {
+		return Runtime{}, fmt.Errorf("%s did not report a Java version", path)
+	}
+
+	return Runtime{
+		Path:    path,
+		Home:    props["java.home"],
+		Version: version,
+		Major:   major,
+		Vendor:  props["java.vendor"],
+	}, nil
+}
+
+// Parses "    key = value" lines from -XshowSettings output
+func parseSettings(output []byte) map[string]string {
+	props := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), " = ")
+		if found {
+			props[key] = value
+		}
+	}
+	return props
+}
+
+// Returns the feature release of a java.version string: 8 for "1.8.0_392", 17 for "17.0.8"
+func MajorVersion(version string) int {
+	parts := strings.FieldsFunc(version, func(r rune) bool { return r == '.' || r == '_' || r == '-' || r == '+' })
+	if len(parts) == 0 {
+		return 0
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0
+	}
+	if major == 1 && len(parts) > 1 {
+		major, _ = strconv.Atoi(parts[1])
+	}
+	return major
+}
+
+// Returns the oldest Java release a Minecraft version runs on, or 0 when the
+// version is unknown
+func RequiredMajor(minecraft string) int {
+	parts := strings.Split(minecraft, ".")
+	if len(parts) < 2 {
+		return 0
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	patch := 0
+	if len(parts) > 2 {
+		patch, _ = strconv.Atoi(parts[2])
+	}
+
+	switch {
+	case major > 1: // Year-numbered releases
+		return 21
+	case minor > 20 || (minor == 20 && patch >= 5):
+		return 21
+	case minor >= 18:
+		return 17
+	case minor == 17:
+		return 16
+	default:
+		return 8
+	}
+}
+
+// Picks the runtime closest to the required release: the oldest one that is
+// new enough, or the newest when nothing is required
+func Select(runtimes []Runtime, required int) (Runtime, error) {
+	if len(runtimes) == 0 {
+		return Runtime{}, fmt.Errorf("no Java runtimes found")
+	}
+	if required == 0 {
+		return runtimes[0], nil
+	}
+
+	var best *Runtime
+	for i := range runtimes {
+		if runtimes[i].Major >= required && (best == nil || runtimes[i].Major < best.Major) {
+			best = &runtimes[i]
+		}
+	}
+	if best == nil {
+		return Runtime{}, fmt.Errorf("Java %d or newer is required, but only %s found", required, describe(runtimes))
+	}
+	return *best, nil
+}
+
+// Resolves a java setting to an executable: "auto" selects by Minecraft
+// version, anything else is looked up on PATH
+func Resolve(setting, minecraft string, extraDirs []string) (string, error) {
+	if setting != Auto {
+		path, err := exec.LookPath(setting)
+		if err != nil {
+			return "", fmt.Errorf("java executable not found: %v", err)
+		}
+		return path, nil
+	}
+
+	runtime, err := Select(Discover(extraDirs), RequiredMajor(minecraft))
+	if err != nil {
+		return "", err
+	}
+	return runtime.Path, nil
+}
+
+func describe(runtimes []Runtime) string {
+	versions := make([]string, len(runtimes))
+	for i, runtime := range runtimes {
+		versions[i] = fmt.Sprintf("Java %d", runtime.Major)
+	}
+	return strings.Join(versions, ", ")
+}
+
+// Checks that a java executable is new enough for a Minecraft version,
+// suggesting installed runtimes that would be
+func Check(javaPath, minecraft string, extraDirs []string) error {
+	required := RequiredMajor(minecraft)
+	if required == 0 {
+		return nil
+	}
+
+	runtime, err := Probe(javaPath)
+	if err != nil {
+		return err
+	}
+	if runtime.Major >= required {
+		return nil
+	}
+
+	message := fmt.Sprintf("Minecraft %s needs Java %d or newer, but %s is Java %s", minecraft, required, javaPath, runtime.Version)
+	if suitable, err := Select(Discover(extraDirs), required); err == nil {
+		message += fmt.Sprintf("; %s (Java %s) would work, or use -java auto", suitable.Path, suitable.Version)
+	} else {
+		message += fmt.Sprintf("; no Java %d or newer runtime is installed", required)
+	}
+	return fmt.Errorf("%s", message)
+}
@@ -0,0 +1,210 @@
+package minecraft
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Tracks a pending graceful restart
+type countdown struct {
+	deadline time.Time
+	cancel   chan struct{}
+}
+
+// Returns the default warning schedule for graceful restarts
+func DefaultRestartWarnings() []time.Duration {
+	return []time.Duration{10 * time.Minute, 5 * time.Minute, time.Minute, 10 * time.Second}
+}
+
+// Parses a comma-separated list of durations such as "10m,5m,1m,10s"
+func ParseRestartWarnings(text string) ([]time.Duration, error) {
+	var warnings []time.Duration
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid restart warning %q", part)
+		}
+		warnings = append(warnings, d)
+	}
+	return warnings, nil
+}
+
+// Starts a countdown that warns players, saves the world and restarts.
+// A zero delay counts down from the longest configured warning.
+func (s *MinecraftServer) GracefulRestart(delay time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Status != Running {
+		return fmt.Errorf("cannot restart server: current state is %d", s.Status)
+	}
+	if s.countdown != nil {
+		return fmt.Errorf("a restart countdown is already running")
+	}
+
+	if delay <= 0 {
+		delay = s.longestWarning()
+	}
+	if delay <= 0 {
+		delay = 10 * time.Second
+	}
+
+	cd := &countdown{
+		deadline: time.Now().Add(delay),
+		cancel:   make(chan struct{}),
+	}
+	s.countdown = cd
+
+	log.Printf("Graceful restart scheduled in %v", delay)
+	go s.runCountdown(cd, delay)
+	return nil
+}
+
+func (s *MinecraftServer) longestWarning() time.Duration {
+	longest := time.Duration(0)
+	for _, w := range s.config.RestartWarnings {
+		longest = max(longest, w)
+	}
+	return longest
+}
+
+// Cancels a pending graceful restart and tells players
+func (s *MinecraftServer) CancelCountdown() error {
+	s.mutex.Lock()
+	cd := s.countdown
+	if cd == nil {
+		s.mutex.Unlock()
+		return fmt.Errorf("no restart countdown is running")
+	}
+	s.stopCountdown()
+	s.mutex.Unlock()
+
+	log.Printf("Graceful restart cancelled")
+	s.AddLog("Restart countdown cancelled")
+	if err := s.Broadcast("Scheduled restart cancelled"); err != nil {
+		log.Printf("Failed to announce cancellation: %v", err)
+	}
+	return nil
+}
+
+// Returns the time left before a graceful restart, or zero if none is pending
+func (s *MinecraftServer) CountdownRemaining() time.Duration {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.countdown == nil {
+		return 0
+	}
+	return max(time.Until(s.countdown.deadline), time.Second)
+}
+
+// Must be called with the mutex held
+func (s *MinecraftServer) stopCountdown() {
+	if s.countdown != nil {
+		close(s.countdown.cancel)
+		s.countdown = nil
+	}
+}
+
+func (s *MinecraftServer) runCountdown(cd *countdown, delay time.Duration) {
+	warnings := make([]time.Duration, 0, len(s.config.RestartWarnings)+1)
+	for _, w := range s.config.RestartWarnings {
+		if w <= delay {
+			warnings = append(warnings, w)
+		}
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i] > warnings[j] })
+
+	// Always announce the restart as soon as it is scheduled
+	if len(warnings) == 0 || warnings[0] != delay {
+		warnings = append([]time.Duration{delay}, warnings...)
+	}
+
+	for _, w := range warnings {
+		if !waitUntil(cd.deadline.Add(-w), cd.cancel) {
+			return
+		}
+		s.warnPlayers(w)
+	}
+
+	if !waitUntil(cd.deadline, cd.cancel) {
+		return
+	}
+
+	s.mutex.Lock()
+	if s.countdown != cd {
+		s.mutex.Unlock()
+		return
+	}
+	s.countdown = nil
+	s.mutex.Unlock()
+
+	mark := s.LogMark()
+	if err := s.ExecuteCommand("save-all flush"); err != nil {
+		log.Printf("Failed to save before restart: %v", err)
+	} else if !s.WaitForLog("Saved the game", mark, 30*time.Second) {
+		log.Printf("Timed out waiting for save before restart")
+	}
+
+	if err := s.Restart(); err != nil {
+		log.Printf("Graceful restart failed: %v", err)
+		s.AddLog(fmt.Sprintf("Graceful restart failed: %v", err))
+	}
+}
+
+func waitUntil(at time.Time, cancel <-chan struct{}) bool {
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-cancel:
+		return false
+	}
+}
+
+// Shows a restart warning in chat and, if enabled, as an on-screen title
+func (s *MinecraftServer) warnPlayers(remaining time.Duration) {
+	text := strings.ReplaceAll(s.config.RestartMessage, "{time}", FormatDuration(remaining))
+	log.Printf("Restart warning: %s", text)
+
+	if err := s.Broadcast(text); err != nil {
+		log.Printf("Failed to send restart warning: %v", err)
+		return
+	}
+
+	if s.config.RestartTitle {
+		title, _ := json.Marshal(map[string]string{"text": text, "color": "red"})
+		if err := s.ExecuteCommand("title @a title " + string(title)); err != nil {
+			log.Printf("Failed to send restart title: %v", err)
+		}
+	}
+}
+
+// Formats a duration for players, e.g. "5 minutes" or "10 seconds"
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Minute && d%time.Minute == 0:
+		return plural(int(d/time.Minute), "minute")
+	case d >= time.Minute:
+		return fmt.Sprintf("%d:%02d", int(d/time.Minute), int(d%time.Minute/time.Second))
+	default:
+		return plural(int(d/time.Second), "second")
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
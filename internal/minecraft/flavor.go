@@ -0,0 +1,269 @@
+package minecraft
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ServerFlavor describes how a kind of server is launched and controlled
+type ServerFlavor interface {
+	Name() string
+
+	// Builds the process command; the caller sets its working directory
+	Command(config ServerConfig) *exec.Cmd
+
+	// Whether the command runs on the JVM and needs a Java executable
+	NeedsJava() bool
+
+	// Matches the console line printed once startup has finished
+	ReadyPattern() *regexp.Regexp
+
+	// Match console lines for players joining and leaving; the first group is the name
+	PlayerPatterns() (join, leave *regexp.Regexp)
+
+	// Console command that shuts the server down cleanly
+	StopCommand() string
+
+	// Locates the world directory from the server directory and server.properties
+	WorldPath(dir string, props map[string]string) string
+
+	// Configuration files and directories, relative to the server directory
+	ConfigPaths() []string
+}
+
+// Flavors whose worlds can be flushed to disk while running
+type worldSaver interface {
+	SaveCommands() (off, flush, saved, on string)
+}
+
+// Flavors that know where their launch file is in a server directory
+type executableFinder interface {
+	FindExecutable(dir string) string
+}
+
+// Returns the flavor's usual launch file in dir, or "" when it has no fixed location
+func FindExecutable(flavor ServerFlavor, dir string) string {
+	if finder, ok := flavor.(executableFinder); ok {
+		return finder.FindExecutable(dir)
+	}
+	return ""
+}
+
+var (
+	javaJoinPattern  = regexp.MustCompile(`: (\S+) joined the game$`)
+	javaLeavePattern = regexp.MustCompile(`: (\S+) left the game$`)
+	javaReadyPattern = regexp.MustCompile(`: Done \([0-9.,]+s\)!`)
+)
+
+var flavors = map[string]ServerFlavor{
+	"fabric":   javaFlavor{name: "fabric"},
+	"vanilla":  javaFlavor{name: "vanilla"},
+	"quilt":    javaFlavor{name: "quilt"},
+	"paper":    paperFlavor{javaFlavor{name: "paper"}},
+	"forge":    forgeFlavor{javaFlavor{name: "forge"}},
+	"neoforge": forgeFlavor{javaFlavor{name: "neoforge"}},
+	"bedrock":  bedrockFlavor{},
+	"velocity": velocityFlavor{},
+}
+
+// Returns the flavor with the given name
+func FlavorByName(name string) (ServerFlavor, error) {
+	flavor, ok := flavors[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown server flavor %q (available: %s)", name, strings.Join(FlavorNames(), ", "))
+	}
+	return flavor, nil
+}
+
+// Lists the known flavor names
+func FlavorNames() []string {
+	names := make([]string, 0, len(flavors))
+	for name := range flavors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the configured flavor, defaulting to Fabric
+func (c ServerConfig) flavor() ServerFlavor {
+	if c.Flavor == nil {
+		return flavors["fabric"]
+	}
+	return c.Flavor
+}
+
+func javaWorldPath(dir string, props map[string]string) string {
+	levelName := "world"
+	if props["level-name"] != "" {
+		levelName = props["level-name"]
+	}
+	return filepath.Join(dir, levelName)
+}
+
+// Vanilla, Fabric and Quilt: java -jar <jar> nogui
+type javaFlavor struct {
+	name string
+}
+
+func (f javaFlavor) Name() string    { return f.name }
+func (f javaFlavor) NeedsJava() bool { return true }
+
+func (f javaFlavor) Command(config ServerConfig) *exec.Cmd {
+	args := append(jvmArgs(config), "-jar", config.ExecutablePath, "nogui")
+	args = append(args, config.ServerArgs...)
+	return exec.Command(config.JavaPath, args...)
+}
+
+func (f javaFlavor) ReadyPattern() *regexp.Regexp { return javaReadyPattern }
+
+func (f javaFlavor) PlayerPatterns() (*regexp.Regexp, *regexp.Regexp) {
+	return javaJoinPattern, javaLeavePattern
+}
+
+func (f javaFlavor) StopCommand() string { return "stop" }
+
+func (f javaFlavor) WorldPath(dir string, props map[string]string) string {
+	return javaWorldPath(dir, props)
+}
+
+func (f javaFlavor) ConfigPaths() []string {
+	return []string{"server.properties", "config"}
+}
+
+func (f javaFlavor) SaveCommands() (string, string, string, string) {
+	return "save-off", "save-all flush", "Saved the game", "save-on"
+}
+
+// Paper reads its own configuration files and, with G1 enabled and no other
+// preset or collector chosen, gets Aikar's tuned flags, which Paper recommends.
+type paperFlavor struct {
+	javaFlavor
+}
+
+func (paperFlavor) Command(config ServerConfig) *exec.Cmd {
+	if config.UseG1GC && config.JVMPreset == "" && len(collectors(config.JVMFlags)) == 0 {
+		config.JVMPreset = "aikar"
+	}
+
+	args := append(jvmArgs(config), "-jar", config.ExecutablePath, "--nogui")
+	args = append(args, config.ServerArgs...)
+	return exec.Command(config.JavaPath, args...)
+}
+
+func (paperFlavor) ConfigPaths() []string {
+	return []string{"server.properties", "bukkit.yml", "spigot.yml", "config", "plugins"}
+}
+
+// Forge and NeoForge start from the argument file their installer writes:
+// java @libraries/<group>/<version>/unix_args.txt nogui. ExecutablePath
+// points at that file.
+type forgeFlavor struct {
+	javaFlavor
+}
+
+func (f forgeFlavor) Command(config ServerConfig) *exec.Cmd {
+	args := append(jvmArgs(config), "@"+config.ExecutablePath, "nogui")
+	args = append(args, config.ServerArgs...)
+	return exec.Command(config.JavaPath, args...)
+}
+
+// Picks the installed version's argument file, the last by name if there are several
+func (f forgeFlavor) FindExecutable(dir string) string {
+	group := "net/minecraftforge/forge"
+	if f.name == "neoforge" {
+		group = "net/neoforged/neoforge"
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "libraries", filepath.FromSlash(group), "*", "unix_args.txt"))
+	if len(matches) == 0 {
+		return ""
+	}
+	sort.Strings(matches)
+	return matches[len(matches)-1]
+}
+
+func (f forgeFlavor) ConfigPaths() []string {
+	return []string{"server.properties", "config", "defaultconfigs", "user_jvm_args.txt"}
+}
+
+// Bedrock Dedicated Server is a native binary with its own console messages
+type bedrockFlavor struct{}
+
+var (
+	bedrockJoinPattern  = regexp.MustCompile(`Player connected: ([^,]+),`)
+	bedrockLeavePattern = regexp.MustCompile(`Player disconnected: ([^,]+),`)
+	bedrockReadyPattern = regexp.MustCompile(`Server started\.`)
+)
+
+func (bedrockFlavor) Name() string    { return "bedrock" }
+func (bedrockFlavor) NeedsJava() bool { return false }
+
+func (bedrockFlavor) Command(config ServerConfig) *exec.Cmd {
+	cmd := exec.Command(config.ExecutablePath, config.ServerArgs...)
+	// The server loads its bundled libraries from its own directory
+	cmd.Env = append(cmd.Environ(), "LD_LIBRARY_PATH="+filepath.Dir(config.ExecutablePath))
+	return cmd
+}
+
+func (bedrockFlavor) FindExecutable(dir string) string {
+	return filepath.Join(dir, "bedrock_server")
+}
+
+func (bedrockFlavor) ReadyPattern() *regexp.Regexp { return bedrockReadyPattern }
+
+func (bedrockFlavor) PlayerPatterns() (*regexp.Regexp, *regexp.Regexp) {
+	return bedrockJoinPattern, bedrockLeavePattern
+}
+
+func (bedrockFlavor) StopCommand() string { return "stop" }
+
+func (bedrockFlavor) WorldPath(dir string, props map[string]string) string {
+	levelName := "Bedrock level"
+	if props["level-name"] != "" {
+		levelName = props["level-name"]
+	}
+	return filepath.Join(dir, "worlds", levelName)
+}
+
+func (bedrockFlavor) ConfigPaths() []string {
+	return []string{"server.properties", "permissions.json", "allowlist.json"}
+}
+
+// Velocity is a proxy: it has no world, takes no nogui argument and shuts
+// down with "end"
+type velocityFlavor struct{}
+
+var (
+	velocityJoinPattern  = regexp.MustCompile(`\[connected player\] (\S+) \([^)]*\) has connected`)
+	velocityLeavePattern = regexp.MustCompile(`\[connected player\] (\S+) \([^)]*\) has disconnected`)
+)
+
+func (velocityFlavor) Name() string    { return "velocity" }
+func (velocityFlavor) NeedsJava() bool { return true }
+
+func (velocityFlavor) Command(config ServerConfig) *exec.Cmd {
+	args := append(jvmArgs(config), "-jar", config.ExecutablePath)
+	args = append(args, config.ServerArgs...)
+	return exec.Command(config.JavaPath, args...)
+}
+
+func (velocityFlavor) ReadyPattern() *regexp.Regexp { return javaReadyPattern }
+
+func (velocityFlavor) PlayerPatterns() (*regexp.Regexp, *regexp.Regexp) {
+	return velocityJoinPattern, velocityLeavePattern
+}
+
+func (velocityFlavor) StopCommand() string { return "end" }
+
+func (velocityFlavor) WorldPath(dir string, props map[string]string) string {
+	return dir
+}
+
+func (velocityFlavor) ConfigPaths() []string {
+	return []string{"velocity.toml", "forwarding.secret", "plugins"}
+}
@@ -0,0 +1,71 @@
+package minecraft
+
+import (
+	"fmt"
+	"log"
+)
+
+// Returned by Start when a preflight check blocks the server from starting
+type PreflightError struct {
+	Check  string
+	Report string
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("preflight check %q failed:\n%s", e.Check, e.Report)
+}
+
+type preflightCheck struct {
+	name  string
+	check func() error
+}
+
+// Registers a hook that runs before the server process starts; an error aborts the start.
+// Hooks and checks run with the server locked and must not call its methods.
+func (s *MinecraftServer) OnStart(hook func() error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.startHooks = append(s.startHooks, hook)
+}
+
+// Registers a hook that runs after the server process exits, before it is reported stopped
+func (s *MinecraftServer) OnStop(hook func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stopHooks = append(s.stopHooks, hook)
+}
+
+// Registers a check that must pass before the server starts, unless forced
+func (s *MinecraftServer) AddPreflightCheck(name string, check func() error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.preflight = append(s.preflight, preflightCheck{name: name, check: check})
+}
+
+// Must be called with the mutex held
+func (s *MinecraftServer) runStartHooks() error {
+	for _, hook := range s.startHooks {
+		if err := hook(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Must be called with the mutex held
+func (s *MinecraftServer) runStopHooks() {
+	for _, hook := range s.stopHooks {
+		hook()
+	}
+}
+
+// Must be called with the mutex held
+func (s *MinecraftServer) runPreflight() error {
+	for _, pc := range s.preflight {
+		if err := pc.check(); err != nil {
+			log.Printf("Preflight check %s failed: %v", pc.name, err)
+			return &PreflightError{Check: pc.name, Report: err.Error()}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package minecraft
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Named sets of JVM tuning flags
+var jvmPresets = map[string][]string{
+	// Aikar's G1 flags, recommended by Paper for heaps up to about 12 GB
+	"aikar": {
+		"-XX:+UseG1GC",
+		"-XX:+ParallelRefProcEnabled",
+		"-XX:MaxGCPauseMillis=200",
+		"-XX:+UnlockExperimentalVMOptions",
+		"-XX:+DisableExplicitGC",
+		"-XX:+AlwaysPreTouch",
+		"-XX:G1NewSizePercent=30",
+		"-XX:G1MaxNewSizePercent=40",
+		"-XX:G1HeapRegionSize=8M",
+		"-XX:G1ReservePercent=20",
+		"-XX:G1HeapWastePercent=5",
+		"-XX:G1MixedGCCountTarget=4",
+		"-XX:InitiatingHeapOccupancyPercent=15",
+		"-XX:G1MixedGCLiveThresholdPercent=90",
+		"-XX:G1RSetUpdatingPauseTimePercent=5",
+		"-XX:SurvivorRatio=32",
+		"-XX:+PerfDisableSharedMem",
+		"-XX:MaxTenuringThreshold=1",
+		"-Dusing.aikars.flags=https://mcflags.emc.gs",
+		"-Daikars.new.flags=true",
+	},
+	// Generational ZGC, for large heaps on Java 21 and newer
+	"zgc": {
+		"-XX:+UseZGC",
+		"-XX:+ZGenerational",
+		"-XX:+AlwaysPreTouch",
+		"-XX:+DisableExplicitGC",
+		"-XX:+PerfDisableSharedMem",
+	},
+	// Small footprint for proxies and hosts with little memory
+	"low-memory": {
+		"-XX:+UseSerialGC",
+		"-XX:MaxMetaspaceSize=256M",
+		"-XX:ReservedCodeCacheSize=64M",
+		"-Xss512k",
+	},
+}
+
+var (
+	gcFlagPattern = regexp.MustCompile(`^-XX:\+Use(\w+)GC$`)
+	// Heap sizes are owned by the memory settings
+	heapFlagPattern = regexp.MustCompile(`^-(Xm[sx]|XX:(MaxHeapSize|InitialHeapSize|MinHeapSize)=)`)
+)
+
+// Lists the known JVM preset names
+func JVMPresetNames() []string {
+	names := make([]string, 0, len(jvmPresets))
+	for name := range jvmPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Memory, GC and tuning flags shared by the Java flavors. G1 is only added
+// when neither the preset nor the extra flags pick a collector.
+func jvmArgs(config ServerConfig) []string {
+	preset := jvmPresets[config.JVMPreset]
+	args := make([]string, 0, 8+len(preset)+len(config.JVMFlags)+len(config.SystemProperties))
+
+	if config.ServerFlag {
+		args = append(args, "-server")
+	}
+
+	if config.InitialMemoryMB > 0 {
+		args = append(args, fmt.Sprintf("-Xms%dM", config.InitialMemoryMB))
+	}
+	args = append(args, fmt.Sprintf("-Xmx%dM", config.MemoryUtilizationMB))
+
+	if config.UseG1GC && len(collectors(preset)) == 0 && len(collectors(config.JVMFlags)) == 0 {
+		args = append(args, "-XX:+UseG1GC")
+	}
+	args = append(args, preset...)
+	args = append(args, config.JVMFlags...)
+
+	keys := make([]string, 0, len(config.SystemProperties))
+	for key := range config.SystemProperties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, fmt.Sprintf("-D%s=%s", key, config.SystemProperties[key]))
+	}
+	return args
+}
+
+// Returns the -XX:+Use...GC flags among args
+func collectors(args []string) []string {
+	var found []string
+	for _, arg := range args {
+		if gcFlagPattern.MatchString(arg) {
+			found = append(found, arg)
+		}
+	}
+	return found
+}
+
+// Checks the JVM settings for unknown presets, flags that belong to other
+// settings and conflicting garbage collectors
+func ValidateJVMArgs(config ServerConfig) error {
+	if !config.flavor().NeedsJava() {
+		if config.JVMPreset != "" || len(config.JVMFlags) > 0 || len(config.SystemProperties) > 0 {
+			return fmt.Errorf("%s servers don't run on Java and take no JVM settings", config.flavor().Name())
+		}
+		return nil
+	}
+
+	preset, ok := jvmPresets[config.JVMPreset]
+	if config.JVMPreset != "" && !ok {
+		return fmt.Errorf("unknown JVM preset %q (available: %s)", config.JVMPreset, strings.Join(JVMPresetNames(), ", "))
+	}
+	if config.InitialMemoryMB < 0 || config.InitialMemoryMB > config.MemoryUtilizationMB {
+		return fmt.Errorf("initial memory %d MB must be between 0 and the maximum of %d MB", config.InitialMemoryMB, config.MemoryUtilizationMB)
+	}
+
+	for _, flag := range config.JVMFlags {
+		switch {
+		case !strings.HasPrefix(flag, "-"):
+			return fmt.Errorf("JVM flag %q must start with -", flag)
+		case flag == "-jar" || strings.HasPrefix(flag, "@"):
+			return fmt.Errorf("JVM flag %q would change what is launched", flag)
+		case heapFlagPattern.MatchString(flag):
+			return fmt.Errorf("JVM flag %q conflicts with the memory settings; use those instead", flag)
+		case strings.HasPrefix(flag, "-D"):
+			key, _, _ := strings.Cut(strings.TrimPrefix(flag, "-D"), "=")
+			if _, ok := config.SystemProperties[key]; ok {
+				return fmt.Errorf("system property %s is set both as a flag and as a property", key)
+			}
+		}
+	}
+
+	for key := range config.SystemProperties {
+		if key == "" || strings.ContainsAny(key, "= \t") {
+			return fmt.Errorf("invalid system property name %q", key)
+		}
+	}
+
+	// Only one collector may be chosen; the same one twice is harmless
+	chosen := map[string]string{}
+	for _, gc := range collectors(preset) {
+		chosen[gc] = "the " + config.JVMPreset + " preset"
+	}
+	for _, gc := range collectors(config.JVMFlags) {
+		chosen[gc] = "the JVM flags"
+	}
+	if len(chosen) > 1 {
+		var conflicts []string
+		for gc, source := range chosen {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", gc, source))
+		}
+		sort.Strings(conflicts)
+		return fmt.Errorf("conflicting garbage collectors: %s", strings.Join(conflicts, " and "))
+	}
+
+	generational := slices.Contains(preset, "-XX:+ZGenerational") || slices.Contains(config.JVMFlags, "-XX:+ZGenerational")
+	if generational && chosen["-XX:+UseZGC"] == "" {
+		return fmt.Errorf("-XX:+ZGenerational needs -XX:+UseZGC")
+	}
+	return nil
+}
+
+// Returns the exact command line the server would be started with
+func (s *MinecraftServer) CommandLine() []string {
+	return s.config.flavor().Command(s.config).Args
+}
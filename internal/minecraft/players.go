@@ -0,0 +1,67 @@
+package minecraft
+
+import (
+	"sort"
+	"time"
+)
+
+// Updates player and readiness tracking from a line of server output.
+// Must be called with the mutex held.
+func (s *MinecraftServer) trackLine(line string) {
+	flavor := s.config.flavor()
+	joinPattern, leavePattern := flavor.PlayerPatterns()
+
+	if match := joinPattern.FindStringSubmatch(line); match != nil {
+		s.players[match[1]] = time.Now()
+		return
+	}
+	if match := leavePattern.FindStringSubmatch(line); match != nil {
+		delete(s.players, match[1])
+		if len(s.players) == 0 {
+			s.lastActive = time.Now()
+		}
+		return
+	}
+	if !s.ready && flavor.ReadyPattern().MatchString(line) {
+		s.ready = true
+		s.lastActive = time.Now()
+	}
+}
+
+// Must be called with the mutex held
+func (s *MinecraftServer) resetTracking() {
+	s.players = make(map[string]time.Time)
+	s.ready = false
+	s.lastActive = time.Now()
+}
+
+// Returns the names of the players currently online
+func (s *MinecraftServer) Players() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := make([]string, 0, len(s.players))
+	for name := range s.players {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reports whether the server has finished starting up
+func (s *MinecraftServer) Ready() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.ready
+}
+
+// Returns how long the server has been ready with no players online
+func (s *MinecraftServer) IdleFor() time.Duration {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !s.ready || len(s.players) > 0 {
+		return 0
+	}
+	return time.Since(s.lastActive)
+}
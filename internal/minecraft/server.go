@@ -2,6 +2,7 @@ package minecraft
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -13,9 +14,10 @@ import (
 func NewServer(config ServerConfig) *MinecraftServer {
 	validateConfig(config)
 	return &MinecraftServer{
-		Status: Stopped,
-		LogBuf: make([]string, 0, config.MaxLogLines),
-		config: config,
+		Status:  Stopped,
+		LogBuf:  make([]string, 0, config.MaxLogLines),
+		config:  config,
+		players: make(map[string]time.Time),
 	}
 }
 
@@ -32,34 +34,30 @@ func validateConfig(config ServerConfig) {
 	if config.MaxLogLines <= 0 {
 		panic("Maximum log lines must be positive.")
 	}
+	if len(config.RestartWarnings) > 0 && config.RestartMessage == "" {
+		panic("Restart warning message must be non-empty.")
+	}
 }
 
-// Constructs the Java command with appropriate arguments
-func (s *MinecraftServer) buildJavaCommand() *exec.Cmd {
-	args := buildJavaArgs(s.config)
-	log.Printf("Building Java command: %s %v", s.config.JavaPath, args)
-	return exec.Command(s.config.JavaPath, args...)
+// Constructs the server command for the configured flavor
+func (s *MinecraftServer) buildCommand() *exec.Cmd {
+	cmd := s.config.flavor().Command(s.config)
+	log.Printf("Building %s command: %s %v", s.config.flavor().Name(), cmd.Path, cmd.Args[1:])
+	cmd.Dir = s.config.WorkingDir
+	return cmd
 }
 
-func buildJavaArgs(config ServerConfig) []string {
-	args := make([]string, 0, 8)
-
-	if config.ServerFlag {
-		args = append(args, "-server")
-	}
-
-	args = append(args, fmt.Sprintf("-Xmx%dM", config.MemoryUtilizationMB))
-
-	if config.UseG1GC {
-		args = append(args, "-XX:+UseG1GC")
-	}
+// Initializes and starts the Minecraft server process
+func (s *MinecraftServer) Start() error {
+	return s.start(false)
+}
 
-	args = append(args, "-jar", config.ExecutablePath, "nogui")
-	return args
+// Starts the server without running preflight checks
+func (s *MinecraftServer) StartForced() error {
+	return s.start(true)
 }
 
-// Initializes and starts the Minecraft server process
-func (s *MinecraftServer) Start() error {
+func (s *MinecraftServer) start(force bool) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -67,6 +65,13 @@ func (s *MinecraftServer) Start() error {
 		return err
 	}
 
+	if force {
+		log.Printf("Forced start requested, skipping preflight checks")
+	} else if err := s.runPreflight(); err != nil {
+		s.appendLogLocked(err.Error())
+		return err
+	}
+
 	if err := s.initializeProcess(); err != nil {
 		return err
 	}
@@ -84,7 +89,12 @@ func (s *MinecraftServer) validateStartState() error {
 }
 
 func (s *MinecraftServer) initializeProcess() error {
-	s.Command = s.buildJavaCommand()
+	if err := s.runStartHooks(); err != nil {
+		return err
+	}
+
+	s.Command = s.buildCommand()
+	s.resetTracking()
 
 	if err := s.setupPipes(); err != nil {
 		return err
@@ -143,6 +153,7 @@ func (s *MinecraftServer) monitorProcess() {
 
 	s.mutex.Lock()
 	wasRunning := s.Status == Running
+	s.runStopHooks()
 	s.updateServerState()
 	autoRestart := s.autoRestart
 	s.mutex.Unlock()
@@ -151,6 +162,8 @@ func (s *MinecraftServer) monitorProcess() {
 }
 
 func (s *MinecraftServer) updateServerState() {
+	s.stopCountdown()
+	s.resetTracking()
 	s.Status = Stopped
 	s.stdin = nil
 	s.Command = nil
@@ -214,7 +227,7 @@ func (s *MinecraftServer) sendStopCommand() error {
 	}
 
 	log.Printf("Sending stop command...")
-	_, err := fmt.Fprintln(s.stdin, "stop")
+	_, err := fmt.Fprintln(s.stdin, s.config.flavor().StopCommand())
 	if err != nil {
 		log.Printf("Failed to write stop command: %v", err)
 		return fmt.Errorf("failed to send stop command: %v", err)
@@ -258,12 +271,29 @@ func (s *MinecraftServer) Restart() error {
 		return fmt.Errorf("cannot restart server: current state is %d", s.Status)
 	}
 
-	if err := s.Stop(); err != nil {
+	// Check before stopping, so a failing check doesn't leave the server down
+	if err := s.runPreflight(); err != nil {
+		s.appendLogLocked(err.Error())
+		return err
+	}
+
+	// Stop and Start take the lock themselves, so use their unlocked steps
+	if err := s.sendStopCommand(); err != nil {
 		return fmt.Errorf("failed to stop server during restart: %v", err)
 	}
+	s.Status = Stopping
 
 	s.waitForStop()
-	return s.Start()
+
+	if err := s.validateStartState(); err != nil {
+		return err
+	}
+	if err := s.initializeProcess(); err != nil {
+		return err
+	}
+
+	log.Printf("Server restarted successfully")
+	return nil
 }
 
 func (s *MinecraftServer) waitForStop() {
@@ -282,7 +312,7 @@ func (s *MinecraftServer) handleLogs(pipe io.Reader) {
 	for scanner.Scan() {
 		line := scanner.Text()
 		log.Printf("Server output: %s", line)
-		s.addLogLine(line)
+		s.addServerLine(line)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -291,14 +321,27 @@ func (s *MinecraftServer) handleLogs(pipe io.Reader) {
 	}
 }
 
+func (s *MinecraftServer) addServerLine(line string) {
+	s.mutex.Lock()
+	s.trackLine(line)
+	s.mutex.Unlock()
+
+	s.addLogLine(line)
+}
+
 func (s *MinecraftServer) addLogLine(line string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	s.appendLogLocked(line)
+}
 
+// Must be called with the mutex held
+func (s *MinecraftServer) appendLogLocked(line string) {
 	if len(s.LogBuf) >= s.config.MaxLogLines {
 		s.LogBuf = s.LogBuf[1:]
 	}
 	s.LogBuf = append(s.LogBuf, line)
+	s.lines++
 }
 
 // Server control and status methods
@@ -322,6 +365,15 @@ func (s *MinecraftServer) ExecuteCommand(command string) error {
 	return nil
 }
 
+// Sends a chat message to every player
+func (s *MinecraftServer) Broadcast(message string) error {
+	text, err := json.Marshal(map[string]string{"text": message, "color": "yellow"})
+	if err != nil {
+		return err
+	}
+	return s.ExecuteCommand("tellraw @a " + string(text))
+}
+
 func (s *MinecraftServer) ToggleAutoRestart() bool {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -350,6 +402,31 @@ func (s *MinecraftServer) GetLogs() []string {
 	return logs
 }
 
+// Returns a marker for the current log position, for use with LogsSinceMark
+func (s *MinecraftServer) LogMark() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.lines
+}
+
+// Returns the lines logged after the given mark that are still buffered
+func (s *MinecraftServer) LogsSinceMark(mark int) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	count := s.lines - mark
+	if count <= 0 {
+		return []string{}
+	}
+	if count > len(s.LogBuf) {
+		count = len(s.LogBuf)
+	}
+
+	logs := make([]string, count)
+	copy(logs, s.LogBuf[len(s.LogBuf)-count:])
+	return logs
+}
+
 func (s *MinecraftServer) GetLogsSince(index int) []string {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
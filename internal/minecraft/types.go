@@ -4,6 +4,7 @@ import (
 	"io"
 	"os/exec"
 	"sync"
+	"time"
 )
 
 const (
@@ -17,10 +18,22 @@ const (
 type ServerConfig struct {
 	JavaPath            string
 	ExecutablePath      string
+	WorkingDir          string // Directory the server process runs in
 	MemoryUtilizationMB int
 	MaxLogLines         int
-	UseG1GC             bool // Whether to use G1 Garbage Collector
-	ServerFlag          bool // Whether to use -server flag
+	UseG1GC             bool         // Whether to use G1 Garbage Collector
+	ServerFlag          bool         // Whether to use -server flag
+	Flavor              ServerFlavor // How the server is launched; nil means Fabric
+
+	InitialMemoryMB  int               // Initial heap (-Xms); 0 leaves it to the JVM
+	JVMPreset        string            // Named set of tuning flags, see JVMPresetNames
+	JVMFlags         []string          // Extra JVM flags, added after the preset
+	SystemProperties map[string]string // Passed as -Dkey=value
+	ServerArgs       []string          // Passed to the server after nogui
+
+	RestartWarnings []time.Duration // When to warn players before a graceful restart
+	RestartMessage  string          // Warning text; {time} is replaced with the time left
+	RestartTitle    bool            // Whether to also show warnings as an on-screen title
 }
 
 type MinecraftServer struct {
@@ -28,10 +41,19 @@ type MinecraftServer struct {
 	Status  uint8
 	LogBuf  []string
 	stdin   io.WriteCloser
+	lines   int // Total log lines received, including trimmed ones
 	mutex   sync.RWMutex
 	config  ServerConfig
 
 	autoRestart bool
+	countdown   *countdown
+
+	players    map[string]time.Time // Online players and when they joined
+	ready      bool                 // Whether startup has finished
+	lastActive time.Time            // When the server last had players or became ready
+	startHooks []func() error
+	stopHooks  []func()
+	preflight  []preflightCheck
 }
 
 // InitConfig returns default server configuration
@@ -39,9 +61,13 @@ func InitConfig() ServerConfig {
 	return ServerConfig{
 		JavaPath:            "java",
 		ExecutablePath:      "fabric-server-mc.1.20.1-loader.0.16.5-launcher.1.0.1.jar",
+		WorkingDir:          ".",
 		MemoryUtilizationMB: 8192, // 8GB
 		MaxLogLines:         1000,
 		UseG1GC:             true,
 		ServerFlag:          true,
+		RestartWarnings:     DefaultRestartWarnings(),
+		RestartMessage:      "Server restarting in {time}",
+		RestartTitle:        true,
 	}
 }
@@ -0,0 +1,158 @@
+package minecraft
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Returns a copy of the server configuration
+func (s *MinecraftServer) Config() ServerConfig {
+	return s.config
+}
+
+// Switches the jar the server runs, such as after an upgrade; the server must be stopped
+func (s *MinecraftServer) SetExecutablePath(path string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Status != Stopped {
+		return fmt.Errorf("server must be stopped to change its jar")
+	}
+	s.config.ExecutablePath = path
+	log.Printf("Server jar set to %s", path)
+	return nil
+}
+
+// Switches the Java runtime the server runs on; the server must be stopped
+func (s *MinecraftServer) SetJavaPath(path string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Status != Stopped {
+		return fmt.Errorf("server must be stopped to change its Java runtime")
+	}
+	s.config.JavaPath = path
+	log.Printf("Java runtime set to %s", path)
+	return nil
+}
+
+// Changes the initial and maximum heap in MB; the server must be stopped
+func (s *MinecraftServer) SetMemory(initialMB, maxMB int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Status != Stopped {
+		return fmt.Errorf("server must be stopped to change its memory")
+	}
+	if maxMB <= 0 || initialMB < 0 || initialMB > maxMB {
+		return fmt.Errorf("invalid heap size %d-%d MB", initialMB, maxMB)
+	}
+	s.config.InitialMemoryMB = initialMB
+	s.config.MemoryUtilizationMB = maxMB
+	return nil
+}
+
+// Returns the directory the server process runs in
+func (s *MinecraftServer) Dir() string {
+	if s.config.WorkingDir == "" {
+		return "."
+	}
+	return s.config.WorkingDir
+}
+
+// Reads server.properties from the server directory
+func (s *MinecraftServer) Properties() (map[string]string, error) {
+	return readProperties(filepath.Join(s.Dir(), "server.properties"))
+}
+
+// Returns the flavor the server is launched as
+func (s *MinecraftServer) Flavor() ServerFlavor {
+	return s.config.flavor()
+}
+
+// Returns the path of the world directory named by level-name
+func (s *MinecraftServer) WorldPath() string {
+	props, err := s.Properties()
+	if err != nil {
+		props = map[string]string{}
+	}
+	return s.config.flavor().WorldPath(s.Dir(), props)
+}
+
+func readProperties(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	props := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		props[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return props, scanner.Err()
+}
+
+// Flushes the world to disk and disables autosave until ResumeSaving is called
+func (s *MinecraftServer) SaveWorld(timeout time.Duration) error {
+	saver, ok := s.config.flavor().(worldSaver)
+	if !ok {
+		return fmt.Errorf("%s servers can't save while running; stop the server first", s.config.flavor().Name())
+	}
+	off, flush, saved, _ := saver.SaveCommands()
+	mark := s.LogMark()
+
+	if err := s.ExecuteCommand(off); err != nil {
+		return err
+	}
+	if err := s.ExecuteCommand(flush); err != nil {
+		s.ResumeSaving()
+		return err
+	}
+
+	if !s.WaitForLog(saved, mark, timeout) {
+		s.ResumeSaving()
+		return fmt.Errorf("timed out waiting for world save")
+	}
+	return nil
+}
+
+// Re-enables autosave after SaveWorld
+func (s *MinecraftServer) ResumeSaving() {
+	saver, ok := s.config.flavor().(worldSaver)
+	if !ok {
+		return
+	}
+	_, _, _, on := saver.SaveCommands()
+	if err := s.ExecuteCommand(on); err != nil {
+		log.Printf("Failed to re-enable saving: %v", err)
+	}
+}
+
+// Waits until a line containing substr is logged after mark
+func (s *MinecraftServer) WaitForLog(substr string, mark int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		for _, line := range s.LogsSinceMark(mark) {
+			if strings.Contains(line, substr) {
+				return true
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,434 @@
+package modpack
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Returned when an import is started while another one is still running
+var ErrBusy = errors.New("a modpack import is already running")
+
+// Importer installs Modrinth modpacks into a server directory
+type Importer struct {
+	client       *http.Client
+	allowedHosts []string // Empty allows any host
+	workers      int
+	busy         atomic.Bool
+}
+
+// Creates an importer that downloads only from the given hosts
+func NewImporter(client *http.Client, allowedHosts []string) *Importer {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Minute}
+	}
+	return &Importer{
+		client:       client,
+		allowedHosts: allowedHosts,
+		workers:      4,
+	}
+}
+
+// Reports whether an import is in progress
+func (im *Importer) Busy() bool {
+	return im.busy.Load()
+}
+
+// Returns the record of the pack installed in dir, or nil if there is none
+func LoadRecord(dir string) (*Record, error) {
+	data, err := os.ReadFile(filepath.Join(dir, recordFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", recordFile, err)
+	}
+	return &record, nil
+}
+
+// Installs or updates the pack at packPath into dir. Every server-side file is
+// downloaded and verified before anything in dir is changed.
+func (im *Importer) Import(ctx context.Context, dir, packPath string) (*Result, error) {
+	if !im.busy.CompareAndSwap(false, true) {
+		return nil, ErrBusy
+	}
+	defer im.busy.Store(false)
+
+	pack, err := zip.OpenReader(packPath)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid .mrpack: %v", err)
+	}
+	defer pack.Close()
+
+	idx, err := readIndex(&pack.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := LoadRecord(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Record: Record{
+			Name:         idx.Name,
+			VersionID:    idx.VersionID,
+			Dependencies: idx.Dependencies,
+			Installed:    time.Now().UTC(),
+		},
+	}
+
+	var files []indexFile
+	for _, file := range idx.Files {
+		if file.Env["server"] == "unsupported" {
+			result.Skipped = append(result.Skipped, file.Path)
+			continue
+		}
+		if err := validateFile(file); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	staging, err := os.MkdirTemp(dir, ".mrpack-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	log.Printf("Importing %s %s: %d files to download", idx.Name, idx.VersionID, len(files))
+	if err := im.downloadAll(ctx, staging, files); err != nil {
+		return nil, err
+	}
+
+	// Record every file this pack or the previous one may leave behind before
+	// changing anything, so a failed import can still be cleaned up by the next
+	pending := result.Record
+	var planned []string
+	for _, file := range files {
+		planned = append(planned, file.Path)
+	}
+	pending.Files = mergeUnique(planned, nil)
+	if previous != nil {
+		pending.Files = mergeUnique(pending.Files, previous.Files)
+		pending.Overrides = previous.Overrides
+	}
+	if err := saveRecord(dir, pending); err != nil {
+		return nil, err
+	}
+
+	// Everything verified; install downloads, then overrides on top
+	for _, file := range files {
+		if err := moveInto(filepath.Join(staging, filepath.FromSlash(file.Path)), filepath.Join(dir, filepath.FromSlash(file.Path))); err != nil {
+			return nil, fmt.Errorf("failed to install %s: %v", file.Path, err)
+		}
+		result.Downloaded = append(result.Downloaded, file.Path)
+	}
+	result.Record.Files = result.Downloaded
+
+	for _, prefix := range []string{"overrides/", "server-overrides/"} {
+		copied, err := extractOverrides(&pack.Reader, prefix, dir)
+		if err != nil {
+			return nil, err
+		}
+		result.Record.Overrides = mergeUnique(result.Record.Overrides, copied)
+	}
+
+	if previous != nil {
+		current := mergeUnique(slices.Clone(result.Record.Files), result.Record.Overrides)
+		result.Removed = removeStale(dir, previous.Files, current)
+		result.Removed = append(result.Removed, removeStale(dir, staleModOverrides(previous.Overrides), current)...)
+	}
+
+	if err := saveRecord(dir, result.Record); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Imported %s %s: %d downloaded, %d overrides, %d removed",
+		idx.Name, idx.VersionID, len(result.Downloaded), len(result.Record.Overrides), len(result.Removed))
+	return result, nil
+}
+
+func readIndex(pack *zip.Reader) (*index, error) {
+	file, err := pack.Open("modrinth.index.json")
+	if err != nil {
+		return nil, fmt.Errorf("pack has no modrinth.index.json")
+	}
+	defer file.Close()
+
+	var idx index
+	if err := json.NewDecoder(io.LimitReader(file, 16<<20)).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("invalid modrinth.index.json: %v", err)
+	}
+	if idx.FormatVersion != 1 {
+		return nil, fmt.Errorf("unsupported pack format version %d", idx.FormatVersion)
+	}
+	if idx.Game != "minecraft" {
+		return nil, fmt.Errorf("unsupported game %q", idx.Game)
+	}
+	return &idx, nil
+}
+
+func validateFile(file indexFile) error {
+	if !safePath(file.Path) {
+		return fmt.Errorf("pack file path %q is not allowed", file.Path)
+	}
+	if file.Hashes["sha512"] == "" && file.Hashes["sha1"] == "" {
+		return fmt.Errorf("pack file %s has no sha1 or sha512 hash", file.Path)
+	}
+	if len(file.Downloads) == 0 {
+		return fmt.Errorf("pack file %s has no download URLs", file.Path)
+	}
+	return nil
+}
+
+// Pack paths must stay inside the server directory
+func safePath(p string) bool {
+	if p == "" || strings.Contains(p, "\\") || path.IsAbs(p) {
+		return false
+	}
+	cleaned := path.Clean(p)
+	return cleaned == p && cleaned != ".." && !strings.HasPrefix(cleaned, "../") && !filepath.IsAbs(filepath.FromSlash(p))
+}
+
+func (im *Importer) downloadAll(ctx context.Context, staging string, files []indexFile) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan indexFile)
+	errs := make(chan error, len(files))
+	var wg sync.WaitGroup
+
+	for i := 0; i < im.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				if err := im.download(ctx, staging, file); err != nil {
+					errs <- err
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for _, file := range files {
+		select {
+		case jobs <- file:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Fetches a file from the first URL that serves matching content
+func (im *Importer) download(ctx context.Context, staging string, file indexFile) error {
+	dest := filepath.Join(staging, filepath.FromSlash(file.Path))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, link := range file.Downloads {
+		if err := im.checkHost(link); err != nil {
+			lastErr = err
+			continue
+		}
+		if lastErr = im.fetch(ctx, link, dest, file); lastErr == nil {
+			return nil
+		}
+		log.Printf("Download of %s from %s failed: %v", file.Path, link, lastErr)
+	}
+	return fmt.Errorf("failed to download %s: %v", file.Path, lastErr)
+}
+
+func (im *Importer) checkHost(link string) error {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("invalid download URL %q", link)
+	}
+	if len(im.allowedHosts) > 0 && !slices.Contains(im.allowedHosts, u.Hostname()) {
+		return fmt.Errorf("download host %s is not allowed", u.Hostname())
+	}
+	return nil
+}
+
+func (im *Importer) fetch(ctx context.Context, link, dest string, file indexFile) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "minecrap_hoster")
+
+	resp, err := im.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	algorithm, want, digest := pickHash(file.Hashes)
+	limit := file.FileSize
+	if limit <= 0 {
+		limit = MaxPackSize
+	}
+	size, err := io.Copy(io.MultiWriter(out, digest), io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return err
+	}
+	if file.FileSize > 0 && size != file.FileSize {
+		return fmt.Errorf("size mismatch: got %d bytes, expected %d", size, file.FileSize)
+	}
+	if got := hex.EncodeToString(digest.Sum(nil)); !strings.EqualFold(got, want) {
+		return fmt.Errorf("%s mismatch: got %s, expected %s", algorithm, got, want)
+	}
+	return out.Sync()
+}
+
+// Prefers the stronger hash when both are listed
+func pickHash(hashes map[string]string) (string, string, hash.Hash) {
+	if want := hashes["sha512"]; want != "" {
+		return "sha512", want, sha512.New()
+	}
+	return "sha1", hashes["sha1"], sha1.New()
+}
+
+// Copies entries under prefix into dir and returns their relative paths
+func extractOverrides(pack *zip.Reader, prefix, dir string) ([]string, error) {
+	var copied []string
+	for _, entry := range pack.File {
+		if !strings.HasPrefix(entry.Name, prefix) || entry.FileInfo().IsDir() {
+			continue
+		}
+
+		rel := strings.TrimPrefix(entry.Name, prefix)
+		if !safePath(rel) {
+			return nil, fmt.Errorf("override path %q is not allowed", entry.Name)
+		}
+
+		if err := extractEntry(entry, filepath.Join(dir, filepath.FromSlash(rel))); err != nil {
+			return nil, fmt.Errorf("failed to apply %s: %v", entry.Name, err)
+		}
+		copied = append(copied, rel)
+	}
+	return copied, nil
+}
+
+func extractEntry(entry *zip.File, dest string) error {
+	src, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func moveInto(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// Deletes files the previous pack installed that the new one no longer lists
+func removeStale(dir string, previous, current []string) []string {
+	var removed []string
+	for _, rel := range previous {
+		if slices.Contains(current, rel) || !safePath(rel) {
+			continue
+		}
+		err := os.Remove(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove stale pack file %s: %v", rel, err)
+			continue
+		}
+		removed = append(removed, rel)
+	}
+	return removed
+}
+
+// Returns the overrides under mods/. Other overrides, like configs, may have
+// been edited since and are left alone when a pack drops them.
+func staleModOverrides(overrides []string) []string {
+	var mods []string
+	for _, rel := range overrides {
+		if strings.HasPrefix(rel, "mods/") {
+			mods = append(mods, rel)
+		}
+	}
+	return mods
+}
+
+func mergeUnique(a, b []string) []string {
+	for _, item := range b {
+		if !slices.Contains(a, item) {
+			a = append(a, item)
+		}
+	}
+	sort.Strings(a)
+	return a
+}
+
+func saveRecord(dir string, record Record) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, recordFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save pack record: %v", err)
+	}
+	return os.Rename(tmp, filepath.Join(dir, recordFile))
+}
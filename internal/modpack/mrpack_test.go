@@ -0,0 +1,262 @@
+package modpack
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Serves files by path, standing in for the Modrinth CDN
+func newFileServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Returns an importer allowed to download only from the test server
+func newTestImporter(server *httptest.Server) *Importer {
+	u, _ := url.Parse(server.URL)
+	return NewImporter(server.Client(), []string{u.Hostname()})
+}
+
+func sha512Hex(content string) string {
+	sum := sha512.Sum512([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Describes a file the pack downloads from the test server
+func packFile(server *httptest.Server, path, content string) indexFile {
+	return indexFile{
+		Path:      path,
+		Hashes:    map[string]string{"sha512": sha512Hex(content)},
+		Downloads: []string{server.URL + "/" + path},
+		FileSize:  int64(len(content)),
+	}
+}
+
+// Writes an .mrpack with the given files and extra zip entries
+func writePack(t *testing.T, version string, files []indexFile, entries map[string]string) string {
+	t.Helper()
+	packPath := filepath.Join(t.TempDir(), "pack.mrpack")
+	out, err := os.Create(packPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	archive := zip.NewWriter(out)
+	idx := index{
+		FormatVersion: 1,
+		Game:          "minecraft",
+		Name:          "Test Pack",
+		VersionID:     version,
+		Files:         files,
+		Dependencies:  map[string]string{"minecraft": "1.20.1"},
+	}
+	writer, err := archive.Create("modrinth.index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewEncoder(writer).Encode(idx); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range entries {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return packPath
+}
+
+func exists(dir, rel string) bool {
+	_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rel)))
+	return err == nil
+}
+
+func TestImportRejectsHashMismatch(t *testing.T) {
+	server := newFileServer(t, map[string]string{"/mods/a.jar": "tampered"})
+	file := packFile(server, "mods/a.jar", "original")
+	dir := t.TempDir()
+
+	_, err := newTestImporter(server).Import(context.Background(), dir, writePack(t, "1", []indexFile{file}, nil))
+	if err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Fatalf("expected a hash mismatch, got %v", err)
+	}
+	if exists(dir, "mods/a.jar") {
+		t.Error("unverified file was installed")
+	}
+	if record, _ := LoadRecord(dir); record != nil {
+		t.Error("record was written for a failed import")
+	}
+}
+
+func TestImportRejectsDisallowedHost(t *testing.T) {
+	server := newFileServer(t, map[string]string{"/mods/a.jar": "content"})
+	file := packFile(server, "mods/a.jar", "content")
+	importer := NewImporter(server.Client(), []string{"cdn.modrinth.com"})
+
+	_, err := importer.Import(context.Background(), t.TempDir(), writePack(t, "1", []indexFile{file}, nil))
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("expected the host to be refused, got %v", err)
+	}
+}
+
+func TestImportRejectsUnsafePaths(t *testing.T) {
+	server := newFileServer(t, map[string]string{"/x": "content"})
+
+	tests := []struct {
+		name    string
+		files   []indexFile
+		entries map[string]string
+	}{
+		{name: "parent file path", files: []indexFile{packFile(server, "../evil.jar", "content")}},
+		{name: "nested parent file path", files: []indexFile{packFile(server, "mods/../../evil.jar", "content")}},
+		{name: "absolute file path", files: []indexFile{packFile(server, "/tmp/evil.jar", "content")}},
+		{name: "parent override", entries: map[string]string{"overrides/../evil.txt": "x"}},
+		{name: "absolute override", entries: map[string]string{"overrides//tmp/evil.txt": "x"}},
+		{name: "parent server override", entries: map[string]string{"server-overrides/config/../../evil.txt": "x"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "server")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := newTestImporter(server).Import(context.Background(), dir, writePack(t, "1", test.files, test.entries))
+			if err == nil || !strings.Contains(err.Error(), "not allowed") {
+				t.Fatalf("expected the path to be refused, got %v", err)
+			}
+			if exists(parent, "evil.jar") || exists(parent, "evil.txt") {
+				t.Error("file was written outside the server directory")
+			}
+		})
+	}
+}
+
+func TestImportSkipsClientOnlyFiles(t *testing.T) {
+	server := newFileServer(t, map[string]string{"/mods/server.jar": "server"})
+	serverFile := packFile(server, "mods/server.jar", "server")
+	clientFile := packFile(server, "mods/client.jar", "client") // Not served; downloading it would fail
+	clientFile.Env = map[string]string{"client": "required", "server": "unsupported"}
+	dir := t.TempDir()
+
+	result, err := newTestImporter(server).Import(context.Background(), dir, writePack(t, "1", []indexFile{serverFile, clientFile}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Skipped, []string{"mods/client.jar"}) {
+		t.Errorf("skipped %v, expected the client-only mod", result.Skipped)
+	}
+	if !exists(dir, "mods/server.jar") || exists(dir, "mods/client.jar") {
+		t.Error("expected only the server mod to be installed")
+	}
+}
+
+func TestImportRemovesStaleFiles(t *testing.T) {
+	server := newFileServer(t, map[string]string{
+		"/mods/kept.jar":    "kept",
+		"/mods/dropped.jar": "dropped",
+	})
+	dir := t.TempDir()
+	importer := newTestImporter(server)
+
+	first := writePack(t, "1",
+		[]indexFile{packFile(server, "mods/kept.jar", "kept"), packFile(server, "mods/dropped.jar", "dropped")},
+		map[string]string{
+			"overrides/mods/bundled.jar": "bundled",
+			"overrides/config/old.cfg":   "old",
+		})
+	if _, err := importer.Import(context.Background(), dir, first); err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range []string{"mods/kept.jar", "mods/dropped.jar", "mods/bundled.jar", "config/old.cfg"} {
+		if !exists(dir, rel) {
+			t.Fatalf("%s missing after the first import", rel)
+		}
+	}
+
+	second := writePack(t, "2",
+		[]indexFile{packFile(server, "mods/kept.jar", "kept")},
+		map[string]string{"overrides/config/new.cfg": "new"})
+	result, err := importer.Import(context.Background(), dir, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exists(dir, "mods/dropped.jar") || exists(dir, "mods/bundled.jar") {
+		t.Errorf("stale mods left behind; removed %v", result.Removed)
+	}
+	for _, rel := range []string{"mods/kept.jar", "config/old.cfg", "config/new.cfg"} {
+		if !exists(dir, rel) {
+			t.Errorf("%s missing after the update", rel)
+		}
+	}
+	record, err := LoadRecord(dir)
+	if err != nil || record == nil || record.VersionID != "2" {
+		t.Fatalf("expected the record of version 2, got %+v (%v)", record, err)
+	}
+}
+
+func TestImportRecordsFilesBeforeOverrides(t *testing.T) {
+	server := newFileServer(t, map[string]string{
+		"/mods/old.jar": "old",
+		"/mods/new.jar": "new",
+	})
+	dir := t.TempDir()
+	importer := newTestImporter(server)
+
+	if _, err := importer.Import(context.Background(), dir, writePack(t, "1", []indexFile{packFile(server, "mods/old.jar", "old")}, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The downloads install, then a bad override fails the import
+	broken := writePack(t, "2",
+		[]indexFile{packFile(server, "mods/new.jar", "new")},
+		map[string]string{"overrides/../evil.txt": "x"})
+	if _, err := importer.Import(context.Background(), dir, broken); err == nil {
+		t.Fatal("expected the bad override to fail the import")
+	}
+
+	record, err := LoadRecord(dir)
+	if err != nil || record == nil {
+		t.Fatalf("no record after the failed import: %v", err)
+	}
+	for _, rel := range []string{"mods/old.jar", "mods/new.jar"} {
+		if !slices.Contains(record.Files, rel) {
+			t.Errorf("record %v lacks %s, so a later import can't remove it", record.Files, rel)
+		}
+	}
+
+	// A fixed pack without either mod cleans both up
+	if _, err := importer.Import(context.Background(), dir, writePack(t, "3", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if exists(dir, "mods/old.jar") || exists(dir, "mods/new.jar") {
+		t.Error("files from the failed import were left behind")
+	}
+}
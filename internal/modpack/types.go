@@ -0,0 +1,54 @@
+package modpack
+
+import "time"
+
+// Name of the record kept in the server directory after an import
+const recordFile = "modpack.json"
+
+// Largest .mrpack accepted for import
+const MaxPackSize = 1 << 30
+
+// Hosts Modrinth allows pack downloads from
+var DefaultAllowedHosts = []string{
+	"cdn.modrinth.com",
+	"github.com",
+	"raw.githubusercontent.com",
+	"gitlab.com",
+}
+
+// Layout of modrinth.index.json
+type index struct {
+	FormatVersion int               `json:"formatVersion"`
+	Game          string            `json:"game"`
+	VersionID     string            `json:"versionId"`
+	Name          string            `json:"name"`
+	Summary       string            `json:"summary"`
+	Files         []indexFile       `json:"files"`
+	Dependencies  map[string]string `json:"dependencies"`
+}
+
+type indexFile struct {
+	Path      string            `json:"path"`
+	Hashes    map[string]string `json:"hashes"`
+	Env       map[string]string `json:"env"`
+	Downloads []string          `json:"downloads"`
+	FileSize  int64             `json:"fileSize"`
+}
+
+// Record describes the pack installed in a server directory
+type Record struct {
+	Name         string            `json:"name"`
+	VersionID    string            `json:"version_id"`
+	Dependencies map[string]string `json:"dependencies"`
+	Files        []string          `json:"files"`     // Downloaded files, removed when a later update drops them
+	Overrides    []string          `json:"overrides"` // Files copied from overrides; only mods are removed when a later update drops them
+	Installed    time.Time         `json:"installed"`
+}
+
+// Result summarises an import
+type Result struct {
+	Record     Record   `json:"record"`
+	Downloaded []string `json:"downloaded"`
+	Skipped    []string `json:"skipped"` // Client-only files
+	Removed    []string `json:"removed"` // Stale files from the previous pack version
+}
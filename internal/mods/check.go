@@ -0,0 +1,252 @@
+package mods
+
+import (
+	"archive/zip"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// GameVersions are the versions the loader itself provides to mods
+type GameVersions struct {
+	Minecraft string `json:"minecraft,omitempty"`
+	Loader    string `json:"loader,omitempty"`
+}
+
+// Report is the outcome of a dependency check
+type Report struct {
+	Game     GameVersions `json:"game"`
+	Errors   []string     `json:"errors"`
+	Warnings []string     `json:"warnings"`
+}
+
+// Reports whether the check found problems that would stop the server loading
+func (r *Report) HasErrors() bool {
+	return len(r.Errors) > 0
+}
+
+// Formats the report for people
+func (r *Report) String() string {
+	var sb strings.Builder
+	if len(r.Errors) > 0 {
+		fmt.Fprintf(&sb, "Mod check found %d problem(s):\n", len(r.Errors))
+		for _, e := range r.Errors {
+			fmt.Fprintf(&sb, "  - %s\n", e)
+		}
+	}
+	if len(r.Warnings) > 0 {
+		fmt.Fprintf(&sb, "Warnings:\n")
+		for _, w := range r.Warnings {
+			fmt.Fprintf(&sb, "  - %s\n", w)
+		}
+	}
+	if sb.Len() == 0 {
+		return "All mod dependencies satisfied\n"
+	}
+	return sb.String()
+}
+
+var launcherNamePattern = regexp.MustCompile(`mc\.([^-]+(?:-[a-z]+[0-9.]*)?)-loader\.([0-9][^-]*)`)
+
+// Works out the Minecraft and loader versions from the Fabric launcher jar,
+// reading its install.properties and falling back to its file name
+func DetectGameVersions(jarPath string) GameVersions {
+	var game GameVersions
+
+	if reader, err := zip.OpenReader(jarPath); err == nil {
+		if data, err := readZipFile(&reader.Reader, "install.properties"); err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+				switch key {
+				case "game-version":
+					game.Minecraft = value
+				case "fabric-loader-version":
+					game.Loader = value
+				}
+			}
+		}
+		reader.Close()
+	}
+
+	if game.Minecraft == "" || game.Loader == "" {
+		if match := launcherNamePattern.FindStringSubmatch(filepath.Base(jarPath)); match != nil {
+			if game.Minecraft == "" {
+				game.Minecraft = match[1]
+			}
+			if game.Loader == "" {
+				game.Loader = match[2]
+			}
+		}
+	}
+	return game
+}
+
+// A mod or built-in that satisfies dependencies on its id
+type provider struct {
+	version Version
+	source  string
+}
+
+// Checks every mod in dir against the others and the game versions
+func Check(dir string, game GameVersions) (*Report, error) {
+	installed, err := Scan(dir)
+	if err != nil {
+		return nil, err
+	}
+	return CheckMods(installed, game), nil
+}
+
+// Checks already scanned mods against each other and the game versions
+func CheckMods(installed []Mod, game GameVersions) *Report {
+	report := &Report{Game: game, Errors: []string{}, Warnings: []string{}}
+	providers := make(map[string]provider)
+
+	if game.Minecraft != "" {
+		providers["minecraft"] = provider{ParseVersion(game.Minecraft), "the server"}
+	} else {
+		report.Warnings = append(report.Warnings, "could not determine the Minecraft version; minecraft dependencies are not checked")
+	}
+	if game.Loader != "" {
+		providers["fabricloader"] = provider{ParseVersion(game.Loader), "the server"}
+	} else {
+		report.Warnings = append(report.Warnings, "could not determine the Fabric loader version; fabricloader dependencies are not checked")
+	}
+
+	// Top-level jars with the same id stop Fabric from loading
+	topLevel := make(map[string][]string)
+	for _, mod := range installed {
+		if mod.ID != "" && loadsOnServer(mod) {
+			topLevel[mod.ID] = append(topLevel[mod.ID], mod.File)
+		}
+	}
+	for _, id := range sortedKeys(topLevel) {
+		if files := topLevel[id]; len(files) > 1 {
+			report.Errors = append(report.Errors, fmt.Sprintf("duplicate mod id %q in %s", id, strings.Join(files, ", ")))
+		}
+	}
+
+	var active []Mod
+	for _, mod := range Flatten(installed) {
+		if mod.Error != "" {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s: %s", mod.File, mod.Error))
+			continue
+		}
+		if !loadsOnServer(mod) {
+			continue
+		}
+		active = append(active, mod)
+		register(providers, mod.ID, mod)
+		for _, alias := range mod.Provides {
+			register(providers, alias, mod)
+		}
+	}
+
+	for _, mod := range active {
+		checkDepends(report, providers, mod)
+		checkBreaks(report, providers, mod)
+		checkRecommends(report, providers, mod)
+	}
+	return report
+}
+
+// Client-only mods are skipped by the loader on a dedicated server
+func loadsOnServer(mod Mod) bool {
+	return mod.Environment != "client"
+}
+
+// Keeps the newest provider, matching how Fabric picks between nested copies
+func register(providers map[string]provider, id string, mod Mod) {
+	version := ParseVersion(mod.Version)
+	if existing, ok := providers[id]; ok && CompareVersions(existing.version, version) >= 0 {
+		return
+	}
+	providers[id] = provider{version, mod.File}
+}
+
+// Dependencies on the Java runtime are checked separately
+func isBuiltin(id string) bool {
+	return id == "java"
+}
+
+func describe(mod Mod) string {
+	name := mod.ID
+	if mod.Name != "" {
+		name = mod.Name
+	}
+	return fmt.Sprintf("%s %s (%s)", name, mod.Version, mod.File)
+}
+
+func checkDepends(report *Report, providers map[string]provider, mod Mod) {
+	for _, id := range sortedKeys(mod.Depends) {
+		if isBuiltin(id) {
+			continue
+		}
+		want := mod.Depends[id]
+		have, ok := providers[id]
+		if !ok {
+			if id == "minecraft" || id == "fabricloader" {
+				continue // Version unknown; already warned
+			}
+			report.Errors = append(report.Errors, fmt.Sprintf("%s requires %s %s, which is not installed",
+				describe(mod), id, strings.Join(want, " or ")))
+			continue
+		}
+
+		matches, err := MatchesAny(have.version, want)
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s: cannot check %s requirement: %v", describe(mod), id, err))
+			continue
+		}
+		if !matches {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s requires %s %s, but %s is provided by %s",
+				describe(mod), id, strings.Join(want, " or "), have.version, have.source))
+		}
+	}
+}
+
+func checkBreaks(report *Report, providers map[string]provider, mod Mod) {
+	for _, id := range sortedKeys(mod.Breaks) {
+		have, ok := providers[id]
+		if !ok {
+			continue
+		}
+
+		matches, err := MatchesAny(have.version, mod.Breaks[id])
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s: cannot check %s conflict: %v", describe(mod), id, err))
+			continue
+		}
+		if matches {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s is incompatible with %s %s (%s)",
+				describe(mod), id, have.version, have.source))
+		}
+	}
+}
+
+func checkRecommends(report *Report, providers map[string]provider, mod Mod) {
+	for _, id := range sortedKeys(mod.Recommends) {
+		if isBuiltin(id) {
+			continue
+		}
+		have, ok := providers[id]
+		if !ok {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s recommends %s, which is not installed", describe(mod), id))
+			continue
+		}
+		if matches, err := MatchesAny(have.version, mod.Recommends[id]); err == nil && !matches {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s recommends %s %s, but %s is installed",
+				describe(mod), id, strings.Join(mod.Recommends[id], " or "), have.version))
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
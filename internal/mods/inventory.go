@@ -0,0 +1,174 @@
+package mods
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Reads every jar in dir and returns the mods they contain, sorted by file name
+func Scan(dir string) ([]Mod, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []Mod{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mods directory: %v", err)
+	}
+
+	mods := make([]Mod, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(strings.ToLower(entry.Name()), ".jar") {
+			continue
+		}
+		mods = append(mods, ReadJar(filepath.Join(dir, entry.Name())))
+	}
+
+	sort.Slice(mods, func(i, j int) bool { return mods[i].File < mods[j].File })
+	return mods, nil
+}
+
+// Reads the Fabric metadata of a single jar; problems are reported in Mod.Error
+func ReadJar(path string) Mod {
+	name := filepath.Base(path)
+
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return Mod{File: name, Error: fmt.Sprintf("not a readable jar: %v", err)}
+	}
+	defer reader.Close()
+
+	return readMod(&reader.Reader, name, 0)
+}
+
+func readMod(jar *zip.Reader, name string, depth int) Mod {
+	mod := Mod{File: name}
+
+	data, err := readZipFile(jar, metadataFile)
+	if err != nil {
+		mod.Error = err.Error()
+		return mod
+	}
+
+	var meta fabricMetadata
+	if err := json.Unmarshal(sanitizeJSON(data), &meta); err != nil {
+		mod.Error = fmt.Sprintf("invalid %s: %v", metadataFile, err)
+		return mod
+	}
+	if meta.ID == "" {
+		mod.Error = fmt.Sprintf("%s has no mod id", metadataFile)
+	}
+
+	mod.ID = meta.ID
+	mod.Version = meta.Version
+	mod.Name = meta.Name
+	mod.Description = meta.Description
+	mod.Environment = meta.Environment
+	mod.Provides = meta.Provides
+	mod.Depends = toMap(meta.Depends)
+	mod.Recommends = toMap(meta.Recommends)
+	mod.Breaks = toMap(meta.Breaks)
+	if mod.Environment == "" {
+		mod.Environment = "*"
+	}
+	for _, author := range meta.Authors {
+		mod.Authors = append(mod.Authors, string(author))
+	}
+
+	if depth >= maxNestingDepth {
+		return mod
+	}
+	for _, nested := range meta.Jars {
+		mod.Nested = append(mod.Nested, readNested(jar, name, nested.File, depth+1))
+	}
+	return mod
+}
+
+// Reads a jar bundled inside another jar
+func readNested(outer *zip.Reader, outerName, path string, depth int) Mod {
+	name := outerName + "!/" + path
+
+	data, err := readZipFile(outer, strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return Mod{File: name, Error: err.Error()}
+	}
+
+	inner, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return Mod{File: name, Error: fmt.Sprintf("not a readable jar: %v", err)}
+	}
+	return readMod(inner, name, depth)
+}
+
+func readZipFile(jar *zip.Reader, name string) ([]byte, error) {
+	file, err := jar.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("missing %s", name)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, 64<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	return data, nil
+}
+
+// Fabric tolerates raw newlines and tabs inside strings; encoding/json does not
+func sanitizeJSON(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	out := make([]byte, 0, len(data))
+	inString, escaped := false, false
+	for _, b := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			case b == '\n':
+				out = append(out, '\\', 'n')
+				continue
+			case b == '\r':
+				continue
+			case b == '\t':
+				out = append(out, '\\', 't')
+				continue
+			}
+		} else if b == '"' {
+			inString = true
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func toMap(in map[string]predicates) map[string][]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string][]string, len(in))
+	for id, preds := range in {
+		out[id] = preds
+	}
+	return out
+}
+
+// Returns the mods and all their nested mods as a single list
+func Flatten(mods []Mod) []Mod {
+	var flat []Mod
+	for _, mod := range mods {
+		flat = append(flat, mod)
+		flat = append(flat, Flatten(mod.Nested)...)
+	}
+	return flat
+}
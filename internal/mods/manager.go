@@ -0,0 +1,449 @@
+package mods
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directories and files the manager keeps next to the server's mods directory
+const (
+	DisabledDir = "mods-disabled"
+	pendingDir  = "mods-pending"
+	stateFile   = "mods-state.json"
+)
+
+// Mod change actions
+const (
+	ActionUpload  = "upload"
+	ActionEnable  = "enable"
+	ActionDisable = "disable"
+	ActionDelete  = "delete"
+)
+
+// Largest mod jar accepted by Upload
+const MaxUploadSize = 256 << 20
+
+// Change is a mod operation waiting for the server to stop
+type Change struct {
+	Action string    `json:"action"`
+	File   string    `json:"file"`
+	Queued time.Time `json:"queued"`
+}
+
+// Checksum records a jar's digest when it was installed
+type Checksum struct {
+	SHA256   string    `json:"sha256"`
+	Size     int64     `json:"size"`
+	Recorded time.Time `json:"recorded"`
+}
+
+type managerState struct {
+	Checksums map[string]Checksum `json:"checksums"`
+	Pending   []Change            `json:"pending"`
+}
+
+// Manager installs, enables, disables and removes mod jars. Changes made
+// while the server is running are queued and applied once it stops.
+type Manager struct {
+	mutex   sync.Mutex
+	dir     string      // Server directory
+	running func() bool // Reports whether the server process is up
+	state   managerState
+}
+
+// Creates a manager for the server directory and loads its saved state
+func NewManager(serverDir string, running func() bool) (*Manager, error) {
+	m := &Manager{
+		dir:     serverDir,
+		running: running,
+		state:   managerState{Checksums: make(map[string]Checksum)},
+	}
+
+	data, err := os.ReadFile(m.path(stateFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &m.state); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", stateFile, err)
+		}
+		if m.state.Checksums == nil {
+			m.state.Checksums = make(map[string]Checksum)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %v", stateFile, err)
+	}
+
+	return m, nil
+}
+
+func (m *Manager) path(parts ...string) string {
+	return filepath.Join(append([]string{m.dir}, parts...)...)
+}
+
+// Returns the installed mods with their recorded checksums
+func (m *Manager) Installed() ([]Mod, error) {
+	return m.list(ModsDir)
+}
+
+// Returns the mods moved to the disabled directory
+func (m *Manager) Disabled() ([]Mod, error) {
+	return m.list(DisabledDir)
+}
+
+func (m *Manager) list(dir string) ([]Mod, error) {
+	found, err := Scan(m.path(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i := range found {
+		found[i].SHA256 = m.state.Checksums[found[i].File].SHA256
+	}
+	return found, nil
+}
+
+// Returns the changes waiting for a restart
+func (m *Manager) Pending() []Change {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	pending := make([]Change, len(m.state.Pending))
+	copy(pending, m.state.Pending)
+	return pending
+}
+
+// Validates and installs an uploaded jar, replacing any jar with the same name
+func (m *Manager) Upload(name string, content io.Reader) (Mod, bool, error) {
+	if err := validateFileName(name); err != nil {
+		return Mod{}, false, err
+	}
+
+	if err := os.MkdirAll(m.path(pendingDir), 0755); err != nil {
+		return Mod{}, false, err
+	}
+	tmp, err := os.CreateTemp(m.path(pendingDir), ".upload-*")
+	if err != nil {
+		return Mod{}, false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(content, MaxUploadSize+1))
+	tmp.Close()
+	if err != nil {
+		return Mod{}, false, fmt.Errorf("failed to store upload: %v", err)
+	}
+	if size > MaxUploadSize {
+		return Mod{}, false, fmt.Errorf("mod jar is larger than %d MB", MaxUploadSize>>20)
+	}
+
+	mod := ReadJar(tmp.Name())
+	mod.File = name
+	if mod.Error != "" {
+		return mod, false, fmt.Errorf("not a Fabric mod jar: %s", mod.Error)
+	}
+	mod.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.state.Checksums[name] = Checksum{SHA256: mod.SHA256, Size: size, Recorded: time.Now().UTC()}
+
+	if m.running() {
+		if err := os.Rename(tmp.Name(), m.path(pendingDir, name)); err != nil {
+			return mod, false, err
+		}
+		// The new jar replaced any earlier one waiting under the same name
+		m.unqueueUpload(name)
+		m.queue(ActionUpload, name)
+		return mod, true, m.saveState()
+	}
+
+	if err := os.MkdirAll(m.path(ModsDir), 0755); err != nil {
+		return mod, false, err
+	}
+	if err := os.Rename(tmp.Name(), m.path(ModsDir, name)); err != nil {
+		return mod, false, err
+	}
+	log.Printf("Installed mod %s (%s %s)", name, mod.ID, mod.Version)
+	return mod, false, m.saveState()
+}
+
+// Installs a new jar in place of an existing one, such as an updated version
+func (m *Manager) Replace(old, name string, content io.Reader) (Mod, bool, error) {
+	if err := validateFileName(old); err != nil {
+		return Mod{}, false, err
+	}
+
+	mod, queued, err := m.Upload(name, content)
+	if err != nil || old == name {
+		return mod, queued, err
+	}
+
+	// Queued after the upload, so the old jar stays until the new one is in place
+	removeQueued, err := m.Delete(old)
+	return mod, queued || removeQueued, err
+}
+
+// Moves a mod to the disabled directory; reports whether the change was queued.
+// A jar still waiting to be installed is disabled right away.
+func (m *Manager) Disable(name string) (bool, error) {
+	if err := validateFileName(name); err != nil {
+		return false, err
+	}
+	cancelled, err := m.cancelUpload(name, DisabledDir)
+	if err != nil {
+		return false, err
+	}
+	if !cancelled {
+		return m.change(ActionDisable, name, ModsDir)
+	}
+
+	// The installed version the upload was going to replace goes too
+	if fileExists(m.path(ModsDir, name)) {
+		return m.change(ActionDelete, name, ModsDir)
+	}
+	return false, nil
+}
+
+// Moves a disabled mod back into the mods directory
+func (m *Manager) Enable(name string) (bool, error) {
+	return m.change(ActionEnable, name, DisabledDir)
+}
+
+// Removes a mod, whether enabled, disabled or still waiting to be installed
+func (m *Manager) Delete(name string) (bool, error) {
+	if err := validateFileName(name); err != nil {
+		return false, err
+	}
+	cancelled, err := m.cancelUpload(name, "")
+	if err != nil {
+		return false, err
+	}
+	if cancelled && !fileExists(m.path(ModsDir, name)) && !fileExists(m.path(DisabledDir, name)) {
+		return false, nil
+	}
+	if fileExists(m.path(DisabledDir, name)) && !fileExists(m.path(ModsDir, name)) {
+		return m.change(ActionDelete, name, DisabledDir)
+	}
+	return m.change(ActionDelete, name, ModsDir)
+}
+
+func (m *Manager) change(action, name, from string) (bool, error) {
+	if err := validateFileName(name); err != nil {
+		return false, err
+	}
+	if !fileExists(m.path(from, name)) {
+		return false, fmt.Errorf("%s not found in %s", name, from)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.running() {
+		m.queue(action, name)
+		return true, m.saveState()
+	}
+
+	if err := m.apply(Change{Action: action, File: name}); err != nil {
+		return false, err
+	}
+	return false, m.saveState()
+}
+
+// Must be called with the mutex held
+func (m *Manager) queue(action, name string) {
+	log.Printf("Queued mod %s of %s until the server restarts", action, name)
+	m.state.Pending = append(m.state.Pending, Change{Action: action, File: name, Queued: time.Now().UTC()})
+}
+
+// Must be called with the mutex held
+func (m *Manager) unqueueUpload(name string) bool {
+	kept := make([]Change, 0, len(m.state.Pending))
+	for _, change := range m.state.Pending {
+		if change.Action != ActionUpload || change.File != name {
+			kept = append(kept, change)
+		}
+	}
+	found := len(kept) < len(m.state.Pending)
+	m.state.Pending = kept
+	return found
+}
+
+// Takes a queued upload of name off the queue, deleting its jar or, if to is
+// set, moving it into that directory now. Reports whether there was one.
+func (m *Manager) cancelUpload(name, to string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	queued := false
+	for _, change := range m.state.Pending {
+		queued = queued || (change.Action == ActionUpload && change.File == name)
+	}
+	if !queued {
+		return false, nil
+	}
+
+	from := m.path(pendingDir, name)
+	if to == "" {
+		if err := os.Remove(from); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		if !fileExists(m.path(ModsDir, name)) && !fileExists(m.path(DisabledDir, name)) {
+			delete(m.state.Checksums, name)
+		}
+	} else if err := moveFile(from, m.path(to, name)); err != nil {
+		return false, err
+	}
+
+	m.unqueueUpload(name)
+	log.Printf("Cancelled queued upload of %s", name)
+	return true, m.saveState()
+}
+
+// Records fresh checksums for the jars in the mods directory, such as after
+// it was restored from a backup
+func (m *Manager) Rehash() error {
+	entries, err := os.ReadDir(m.path(ModsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || validateFileName(name) != nil {
+			continue
+		}
+		checksum, err := hashFile(m.path(ModsDir, name))
+		if err != nil {
+			return err
+		}
+		m.state.Checksums[name] = checksum
+	}
+	for name := range m.state.Checksums {
+		if !fileExists(m.path(ModsDir, name)) && !fileExists(m.path(DisabledDir, name)) && !fileExists(m.path(pendingDir, name)) {
+			delete(m.state.Checksums, name)
+		}
+	}
+	return m.saveState()
+}
+
+func hashFile(path string) (Checksum, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Checksum{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return Checksum{}, err
+	}
+	return Checksum{SHA256: hex.EncodeToString(hash.Sum(nil)), Size: size, Recorded: time.Now().UTC()}, nil
+}
+
+// Applies queued changes in order; intended to run once the server has stopped
+func (m *Manager) ApplyPending() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.state.Pending) == 0 {
+		return
+	}
+
+	for _, change := range m.state.Pending {
+		if err := m.apply(change); err != nil {
+			log.Printf("Failed to apply queued mod %s of %s: %v", change.Action, change.File, err)
+		}
+	}
+	m.state.Pending = nil
+
+	if err := m.saveState(); err != nil {
+		log.Printf("Failed to save mod state: %v", err)
+	}
+}
+
+// Must be called with the mutex held
+func (m *Manager) apply(change Change) error {
+	name := change.File
+
+	switch change.Action {
+	case ActionUpload:
+		return moveFile(m.path(pendingDir, name), m.path(ModsDir, name))
+
+	case ActionDisable:
+		return moveFile(m.path(ModsDir, name), m.path(DisabledDir, name))
+
+	case ActionEnable:
+		return moveFile(m.path(DisabledDir, name), m.path(ModsDir, name))
+
+	case ActionDelete:
+		path := m.path(ModsDir, name)
+		if !fileExists(path) {
+			path = m.path(DisabledDir, name)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		// A disabled copy of the same name keeps its checksum
+		if !fileExists(m.path(ModsDir, name)) && !fileExists(m.path(DisabledDir, name)) {
+			delete(m.state.Checksums, name)
+		}
+		log.Printf("Deleted mod %s", name)
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", change.Action)
+}
+
+// Must be called with the mutex held
+func (m *Manager) saveState() error {
+	data, err := json.MarshalIndent(m.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path(stateFile + ".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save mod state: %v", err)
+	}
+	return os.Rename(tmp, m.path(stateFile))
+}
+
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	log.Printf("Moved %s to %s", from, to)
+	return nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func validateFileName(name string) error {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	if !strings.HasSuffix(strings.ToLower(name), ".jar") {
+		return fmt.Errorf("mod file must be a .jar")
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package mods
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Builds a Fabric mod jar with the given id and version
+func modJar(t *testing.T, id, version string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	writer, err := archive.Create(metadataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(writer, `{"schemaVersion": 1, "id": %q, "version": %q}`, id, version)
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Returns a manager for a new server directory and a switch for whether the server runs
+func newTestManager(t *testing.T) (*Manager, string, *bool) {
+	t.Helper()
+	dir := t.TempDir()
+	running := new(bool)
+	m, err := NewManager(dir, func() bool { return *running })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, dir, running
+}
+
+func upload(t *testing.T, m *Manager, name string, jar []byte) {
+	t.Helper()
+	if _, _, err := m.Upload(name, bytes.NewReader(jar)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUploadTwiceWhileRunning(t *testing.T) {
+	m, dir, running := newTestManager(t)
+	*running = true
+
+	second := modJar(t, "example", "2.0.0")
+	upload(t, m, "example.jar", modJar(t, "example", "1.0.0"))
+	upload(t, m, "example.jar", second)
+
+	if pending := m.Pending(); len(pending) != 1 {
+		t.Fatalf("expected one queued upload, got %+v", pending)
+	}
+
+	*running = false
+	m.ApplyPending()
+	data, err := os.ReadFile(filepath.Join(dir, ModsDir, "example.jar"))
+	if err != nil || !bytes.Equal(data, second) {
+		t.Fatalf("expected the second upload to be installed: %v", err)
+	}
+	if pending := m.Pending(); len(pending) != 0 {
+		t.Errorf("changes left queued: %+v", pending)
+	}
+}
+
+func TestDeleteCancelsQueuedUpload(t *testing.T) {
+	m, dir, running := newTestManager(t)
+	*running = true
+	upload(t, m, "example.jar", modJar(t, "example", "1.0.0"))
+
+	queued, err := m.Delete("example.jar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued {
+		t.Error("cancelling an upload should not queue anything")
+	}
+	if pending := m.Pending(); len(pending) != 0 {
+		t.Errorf("upload still queued: %+v", pending)
+	}
+	if _, err := os.Stat(filepath.Join(dir, pendingDir, "example.jar")); !os.IsNotExist(err) {
+		t.Error("queued jar was not removed")
+	}
+
+	*running = false
+	m.ApplyPending()
+	if _, err := os.Stat(filepath.Join(dir, ModsDir, "example.jar")); !os.IsNotExist(err) {
+		t.Error("cancelled upload was installed")
+	}
+}
+
+func TestDeleteCancelsQueuedReplacement(t *testing.T) {
+	m, dir, running := newTestManager(t)
+	upload(t, m, "example.jar", modJar(t, "example", "1.0.0"))
+	*running = true
+	upload(t, m, "example.jar", modJar(t, "example", "2.0.0"))
+
+	// Both the waiting jar and the installed one go
+	queued, err := m.Delete("example.jar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !queued {
+		t.Error("deleting the installed jar while running should be queued")
+	}
+
+	*running = false
+	m.ApplyPending()
+	for _, sub := range []string{ModsDir, pendingDir, DisabledDir} {
+		if _, err := os.Stat(filepath.Join(dir, sub, "example.jar")); !os.IsNotExist(err) {
+			t.Errorf("example.jar left in %s", sub)
+		}
+	}
+}
+
+func TestDisableQueuedUpload(t *testing.T) {
+	m, dir, running := newTestManager(t)
+	*running = true
+	jar := modJar(t, "example", "1.0.0")
+	upload(t, m, "example.jar", jar)
+
+	queued, err := m.Disable("example.jar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued {
+		t.Error("disabling a waiting jar should not queue anything")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, DisabledDir, "example.jar"))
+	if err != nil || !bytes.Equal(data, jar) {
+		t.Fatalf("waiting jar not moved to the disabled directory: %v", err)
+	}
+
+	*running = false
+	m.ApplyPending()
+	if _, err := os.Stat(filepath.Join(dir, ModsDir, "example.jar")); !os.IsNotExist(err) {
+		t.Error("disabled upload was installed")
+	}
+	disabled, err := m.Disabled()
+	if err != nil || len(disabled) != 1 || disabled[0].SHA256 == "" {
+		t.Errorf("expected the disabled jar with its checksum, got %+v (%v)", disabled, err)
+	}
+}
+
+func TestRehashAfterRestore(t *testing.T) {
+	m, dir, _ := newTestManager(t)
+	upload(t, m, "example.jar", modJar(t, "example", "1.0.0"))
+	upload(t, m, "gone.jar", modJar(t, "gone", "1.0.0"))
+
+	// A restored mods directory holds other jars than the recorded ones
+	restored := modJar(t, "example", "0.9.0")
+	if err := os.WriteFile(filepath.Join(dir, ModsDir, "example.jar"), restored, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, ModsDir, "gone.jar")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Rehash(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(restored)
+	if checksum := m.state.Checksums["example.jar"]; checksum.SHA256 != hex.EncodeToString(sum[:]) || checksum.Size != int64(len(restored)) {
+		t.Errorf("checksum not updated: %+v", checksum)
+	}
+	if _, ok := m.state.Checksums["gone.jar"]; ok {
+		t.Error("checksum of a jar no longer present was kept")
+	}
+}
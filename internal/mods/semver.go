@@ -0,0 +1,230 @@
+package mods
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version follows Fabric's SemanticVersion: any number of numeric components,
+// an optional pre-release after "-" and build metadata after "+". Versions that
+// don't parse are kept as plain strings and only compare equal to themselves.
+type Version struct {
+	raw        string
+	components []int
+	wildcard   int // Index of an "x" component in a predicate, or -1
+	prerelease string
+	semantic   bool
+}
+
+// Parses a version string; non-semantic versions are returned as string versions
+func ParseVersion(text string) Version {
+	v, err := parseSemantic(text, false)
+	if err != nil {
+		return Version{raw: text, wildcard: -1}
+	}
+	return v
+}
+
+func parseSemantic(text string, allowWildcard bool) (Version, error) {
+	v := Version{raw: text, wildcard: -1, semantic: true}
+
+	core, _, _ := strings.Cut(text, "+")
+	core, v.prerelease, _ = strings.Cut(core, "-")
+	if core == "" {
+		return v, fmt.Errorf("empty version")
+	}
+
+	for i, part := range strings.Split(core, ".") {
+		if allowWildcard && (part == "x" || part == "X" || part == "*") {
+			if v.wildcard < 0 {
+				v.wildcard = i
+			}
+			v.components = append(v.components, 0)
+			continue
+		}
+		if v.wildcard >= 0 {
+			return v, fmt.Errorf("components after a wildcard in %q", text)
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid component %q in %q", part, text)
+		}
+		v.components = append(v.components, n)
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	return v.raw
+}
+
+func (v Version) component(i int) int {
+	if i < len(v.components) {
+		return v.components[i]
+	}
+	return 0
+}
+
+// Compares two versions; string versions compare lexically
+func CompareVersions(a, b Version) int {
+	if !a.semantic || !b.semantic {
+		return strings.Compare(a.raw, b.raw)
+	}
+
+	for i := 0; i < max(len(a.components), len(b.components)); i++ {
+		if c := a.component(i) - b.component(i); c != 0 {
+			if c < 0 {
+				return -1
+			}
+			return 1
+		}
+	}
+	return comparePrerelease(a.prerelease, b.prerelease)
+}
+
+// A release sorts after all of its pre-releases
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < min(len(aParts), len(bParts)); i++ {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				return compareInts(aNum, bNum)
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(aParts[i], bParts[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInts(len(aParts), len(bParts))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// A single comparison such as ">=1.2.0" or "1.20.x"
+type comparison struct {
+	op      string
+	version Version
+}
+
+var operators = []string{">=", "<=", ">", "<", "=", "^", "~"}
+
+// Reports whether version satisfies a Fabric dependency predicate.
+// Space-separated comparisons must all match, e.g. ">=1.2 <2".
+func MatchesPredicate(version Version, predicate string) (bool, error) {
+	predicate = strings.TrimSpace(predicate)
+	if predicate == "" || predicate == "*" {
+		return true, nil
+	}
+
+	for _, term := range strings.Fields(predicate) {
+		c, err := parseComparison(term)
+		if err != nil {
+			return false, err
+		}
+		if !c.matches(version) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Reports whether version satisfies any of the alternatives
+func MatchesAny(version Version, alternatives []string) (bool, error) {
+	if len(alternatives) == 0 {
+		return true, nil
+	}
+	for _, predicate := range alternatives {
+		ok, err := MatchesPredicate(version, predicate)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func parseComparison(term string) (comparison, error) {
+	op := "="
+	for _, candidate := range operators {
+		if strings.HasPrefix(term, candidate) {
+			op = candidate
+			term = term[len(candidate):]
+			break
+		}
+	}
+
+	v, err := parseSemantic(term, op == "=")
+	if err != nil {
+		// Non-semantic versions can only be matched exactly
+		if op != "=" {
+			return comparison{}, fmt.Errorf("operator %s needs a semantic version, got %q", op, term)
+		}
+		return comparison{op: op, version: Version{raw: term, wildcard: -1}}, nil
+	}
+	return comparison{op: op, version: v}, nil
+}
+
+func (c comparison) matches(v Version) bool {
+	p := c.version
+
+	if !v.semantic || !p.semantic {
+		return c.op == "=" && v.raw == p.raw
+	}
+
+	if p.wildcard >= 0 {
+		for i := 0; i < p.wildcard; i++ {
+			if v.component(i) != p.component(i) {
+				return false
+			}
+		}
+		return true
+	}
+
+	cmp := CompareVersions(v, p)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "^":
+		return cmp >= 0 && v.component(0) == p.component(0)
+	case "~":
+		return cmp >= 0 && v.component(0) == p.component(0) && v.component(1) == p.component(1)
+	}
+	return false
+}
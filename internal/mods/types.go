@@ -0,0 +1,89 @@
+package mods
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Name of the directory Fabric loads mods from, relative to the server directory
+const ModsDir = "mods"
+
+// Name of the metadata file inside a Fabric mod jar
+const metadataFile = "fabric.mod.json"
+
+// How deep jar-in-jar nesting is followed
+const maxNestingDepth = 4
+
+// Mod is a Fabric mod found in a jar, possibly nested inside another jar
+type Mod struct {
+	File        string              `json:"file"` // Jar file name; nested jars use "outer.jar!/path/inner.jar"
+	ID          string              `json:"id,omitempty"`
+	Version     string              `json:"version,omitempty"`
+	Name        string              `json:"name,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Authors     []string            `json:"authors,omitempty"`
+	Environment string              `json:"environment,omitempty"`
+	Provides    []string            `json:"provides,omitempty"`
+	Depends     map[string][]string `json:"depends,omitempty"`
+	Recommends  map[string][]string `json:"recommends,omitempty"`
+	Breaks      map[string][]string `json:"breaks,omitempty"`
+	Nested      []Mod               `json:"nested,omitempty"`
+	SHA256      string              `json:"sha256,omitempty"` // Checksum recorded when the jar was uploaded
+	Error       string              `json:"error,omitempty"`  // Set when metadata is missing or unreadable
+}
+
+// Raw fabric.mod.json layout
+type fabricMetadata struct {
+	SchemaVersion int                   `json:"schemaVersion"`
+	ID            string                `json:"id"`
+	Version       string                `json:"version"`
+	Name          string                `json:"name"`
+	Description   string                `json:"description"`
+	Authors       []person              `json:"authors"`
+	Environment   string                `json:"environment"`
+	Provides      []string              `json:"provides"`
+	Depends       map[string]predicates `json:"depends"`
+	Recommends    map[string]predicates `json:"recommends"`
+	Breaks        map[string]predicates `json:"breaks"`
+	Jars          []struct {
+		File string `json:"file"`
+	} `json:"jars"`
+}
+
+// An author entry, written either as a plain name or as {"name": ...}
+type person string
+
+func (p *person) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*p = person(name)
+		return nil
+	}
+
+	var obj struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("invalid person entry")
+	}
+	*p = person(obj.Name)
+	return nil
+}
+
+// A dependency version requirement, written as one string or a list of alternatives
+type predicates []string
+
+func (p *predicates) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*p = predicates{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("invalid version requirement")
+	}
+	*p = list
+	return nil
+}
@@ -0,0 +1,249 @@
+package mods
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Public Modrinth API; any server implementing the same version endpoints works
+const DefaultUpdateAPI = "https://api.modrinth.com"
+
+// Update is a newer compatible version of an installed mod
+type Update struct {
+	File          string       `json:"file"` // Installed jar it replaces
+	ModID         string       `json:"mod_id,omitempty"`
+	Current       string       `json:"current_version,omitempty"`
+	ProjectID     string       `json:"project_id"`
+	VersionID     string       `json:"version_id"`
+	VersionNumber string       `json:"version_number"`
+	Name          string       `json:"name"`
+	Changelog     string       `json:"changelog"`
+	Published     time.Time    `json:"published"`
+	Download      DownloadFile `json:"download"`
+}
+
+// DownloadFile is the jar an update installs
+type DownloadFile struct {
+	URL      string `json:"url"`
+	Filename string `json:"filename"`
+	SHA1     string `json:"sha1"`
+	SHA512   string `json:"sha512"`
+	Size     int64  `json:"size"`
+}
+
+// Version object returned by the Modrinth API
+type apiVersion struct {
+	ID            string    `json:"id"`
+	ProjectID     string    `json:"project_id"`
+	Name          string    `json:"name"`
+	VersionNumber string    `json:"version_number"`
+	Changelog     string    `json:"changelog"`
+	DatePublished time.Time `json:"date_published"`
+	Files         []struct {
+		Hashes   map[string]string `json:"hashes"`
+		URL      string            `json:"url"`
+		Filename string            `json:"filename"`
+		Primary  bool              `json:"primary"`
+		Size     int64             `json:"size"`
+	} `json:"files"`
+}
+
+// UpdateClient looks up mod updates by jar hash
+type UpdateClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// Creates a client for a Modrinth-compatible API at baseURL
+func NewUpdateClient(baseURL string, client *http.Client) *UpdateClient {
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute}
+	}
+	return &UpdateClient{baseURL: strings.TrimRight(baseURL, "/"), client: client}
+}
+
+// Returns updates for the jars in dir that are compatible with the game versions.
+// Jars the API doesn't know about are left out.
+func (c *UpdateClient) Check(ctx context.Context, dir string, game GameVersions) ([]Update, error) {
+	installed, err := Scan(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byHash := make(map[string]Mod)
+	var hashes []string
+	for _, mod := range installed {
+		sum, err := fileHash(filepath.Join(dir, mod.File), sha1.New())
+		if err != nil {
+			return nil, err
+		}
+		byHash[sum] = mod
+		hashes = append(hashes, sum)
+	}
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+
+	request := map[string]interface{}{
+		"hashes":    hashes,
+		"algorithm": "sha1",
+		"loaders":   []string{"fabric"},
+	}
+	if game.Minecraft != "" {
+		request["game_versions"] = []string{game.Minecraft}
+	}
+
+	var versions map[string]apiVersion
+	if err := c.post(ctx, "/v2/version_files/update", request, &versions); err != nil {
+		return nil, err
+	}
+
+	var updates []Update
+	for sum, version := range versions {
+		mod, ok := byHash[sum]
+		if !ok {
+			continue
+		}
+		file, ok := primaryFile(version)
+		if !ok || file.SHA1 == sum {
+			continue // Already the newest version
+		}
+		updates = append(updates, Update{
+			File:          mod.File,
+			ModID:         mod.ID,
+			Current:       mod.Version,
+			ProjectID:     version.ProjectID,
+			VersionID:     version.ID,
+			VersionNumber: version.VersionNumber,
+			Name:          version.Name,
+			Changelog:     version.Changelog,
+			Published:     version.DatePublished,
+			Download:      file,
+		})
+	}
+
+	sort.Slice(updates, func(i, j int) bool { return updates[i].File < updates[j].File })
+	return updates, nil
+}
+
+// Downloads an update's jar into a temporary file after verifying its hash.
+// The caller removes the file.
+func (c *UpdateClient) Fetch(ctx context.Context, update Update) (string, error) {
+	if update.Download.SHA512 == "" && update.Download.SHA1 == "" {
+		return "", fmt.Errorf("update for %s has no checksum", update.File)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, update.Download.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "minecrap_hoster")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download of %s failed: HTTP %d", update.Download.Filename, resp.StatusCode)
+	}
+
+	tmp, err := os.CreateTemp("", "mod-update-*.jar")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	want, digest := update.Download.SHA512, hash.Hash(sha512.New())
+	if want == "" {
+		want, digest = update.Download.SHA1, sha1.New()
+	}
+	if _, err := io.Copy(io.MultiWriter(tmp, digest), io.LimitReader(resp.Body, MaxUploadSize+1)); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if got := hex.EncodeToString(digest.Sum(nil)); !strings.EqualFold(got, want) {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("checksum mismatch for %s", update.Download.Filename)
+	}
+	return tmp.Name(), nil
+}
+
+func (c *UpdateClient) post(ctx context.Context, path string, body, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "minecrap_hoster")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("update API request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("update API returned HTTP %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 32<<20)).Decode(result); err != nil {
+		return fmt.Errorf("invalid update API response: %v", err)
+	}
+	return nil
+}
+
+// Picks the file marked primary, or the first jar when none is
+func primaryFile(version apiVersion) (DownloadFile, bool) {
+	index := -1
+	for i, file := range version.Files {
+		if file.Primary {
+			index = i
+			break
+		}
+		if index < 0 && strings.HasSuffix(file.Filename, ".jar") {
+			index = i
+		}
+	}
+	if index < 0 {
+		return DownloadFile{}, false
+	}
+
+	file := version.Files[index]
+	return DownloadFile{
+		URL:      file.URL,
+		Filename: file.Filename,
+		SHA1:     file.Hashes["sha1"],
+		SHA512:   file.Hashes["sha512"],
+		Size:     file.Size,
+	}, true
+}
+
+func fileHash(path string, digest hash.Hash) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(digest, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
@@ -0,0 +1,302 @@
+package mods
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A version published on the mock API
+type mockVersion struct {
+	id       string
+	number   string
+	loaders  []string
+	game     []string
+	jar      []byte
+	tampered bool // Whether the download differs from the published hashes
+}
+
+// Stands in for Modrinth's version file endpoints: each project lists its
+// versions oldest first, and a hash of any of them finds the newest version
+// matching the requested loaders and game versions
+type mockModrinth struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	projects map[string][]mockVersion
+	request  map[string]interface{} // Last update request body
+}
+
+func newMockModrinth(t *testing.T) *mockModrinth {
+	t.Helper()
+	api := &mockModrinth{projects: make(map[string][]mockVersion)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/version_files/update", func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Hashes       []string `json:"hashes"`
+			Algorithm    string   `json:"algorithm"`
+			Loaders      []string `json:"loaders"`
+			GameVersions []string `json:"game_versions"`
+		}
+		var raw map[string]interface{}
+		data, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || json.Unmarshal(data, &request) != nil || json.Unmarshal(data, &raw) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if request.Algorithm != "sha1" {
+			http.Error(w, "unsupported algorithm", http.StatusBadRequest)
+			return
+		}
+
+		api.mutex.Lock()
+		defer api.mutex.Unlock()
+		api.request = raw
+
+		found := make(map[string]apiVersion)
+		for _, sum := range request.Hashes {
+			for project, versions := range api.projects {
+				if !publishes(versions, sum) {
+					continue
+				}
+				for i := len(versions) - 1; i >= 0; i-- {
+					version := versions[i]
+					if overlaps(version.loaders, request.Loaders) &&
+						(len(request.GameVersions) == 0 || overlaps(version.game, request.GameVersions)) {
+						found[sum] = api.apiVersion(project, version)
+						break
+					}
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(found)
+	})
+	mux.HandleFunc("/download/", func(w http.ResponseWriter, r *http.Request) {
+		api.mutex.Lock()
+		defer api.mutex.Unlock()
+		for _, versions := range api.projects {
+			for _, version := range versions {
+				if r.URL.Path == "/download/"+version.id+".jar" {
+					jar := version.jar
+					if version.tampered {
+						jar = append([]byte("tampered"), jar...)
+					}
+					w.Write(jar)
+					return
+				}
+			}
+		}
+		http.NotFound(w, r)
+	})
+	api.Server = httptest.NewServer(mux)
+	t.Cleanup(api.Close)
+	return api
+}
+
+func publishes(versions []mockVersion, sum string) bool {
+	for _, version := range versions {
+		if sha1Hex(version.jar) == sum {
+			return true
+		}
+	}
+	return false
+}
+
+// Must be called with the mutex held
+func (api *mockModrinth) apiVersion(project string, version mockVersion) apiVersion {
+	result := apiVersion{
+		ID:            version.id,
+		ProjectID:     project,
+		Name:          project + " " + version.number,
+		VersionNumber: version.number,
+		Changelog:     "Changes in " + version.number,
+		DatePublished: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+	}
+	sum := sha512.Sum512(version.jar)
+	result.Files = append(result.Files, struct {
+		Hashes   map[string]string `json:"hashes"`
+		URL      string            `json:"url"`
+		Filename string            `json:"filename"`
+		Primary  bool              `json:"primary"`
+		Size     int64             `json:"size"`
+	}{
+		Hashes:   map[string]string{"sha1": sha1Hex(version.jar), "sha512": hex.EncodeToString(sum[:])},
+		URL:      api.URL + "/download/" + version.id + ".jar",
+		Filename: project + "-" + version.number + ".jar",
+		Primary:  true,
+		Size:     int64(len(version.jar)),
+	})
+	return result
+}
+
+func (api *mockModrinth) publish(project string, versions ...mockVersion) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.projects[project] = versions
+}
+
+func (api *mockModrinth) lastRequest() map[string]interface{} {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	return api.request
+}
+
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func installJar(t *testing.T, dir, name string, jar []byte) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), jar, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckUpdates(t *testing.T) {
+	api := newMockModrinth(t)
+	dir := t.TempDir()
+	fabric := []string{"fabric"}
+
+	// An update for this game version
+	sodium := modJar(t, "sodium", "0.5.0")
+	api.publish("sodium",
+		mockVersion{id: "s1", number: "0.5.0", loaders: fabric, game: []string{"1.20.1"}, jar: sodium},
+		mockVersion{id: "s2", number: "0.5.3", loaders: fabric, game: []string{"1.20.1"}, jar: modJar(t, "sodium", "0.5.3")},
+		mockVersion{id: "s3", number: "0.6.0", loaders: fabric, game: []string{"1.21"}, jar: modJar(t, "sodium", "0.6.0")},
+	)
+	// Newer versions exist only for another loader or game version
+	lithium := modJar(t, "lithium", "0.11.0")
+	api.publish("lithium",
+		mockVersion{id: "l1", number: "0.11.0", loaders: fabric, game: []string{"1.20.1"}, jar: lithium},
+		mockVersion{id: "l2", number: "0.11.1", loaders: []string{"forge"}, game: []string{"1.20.1"}, jar: modJar(t, "lithium", "0.11.1")},
+		mockVersion{id: "l3", number: "0.12.0", loaders: fabric, game: []string{"1.21"}, jar: modJar(t, "lithium", "0.12.0")},
+	)
+	installJar(t, dir, "sodium.jar", sodium)
+	installJar(t, dir, "lithium.jar", lithium)
+	installJar(t, dir, "private.jar", modJar(t, "private", "1.0.0")) // Unknown to the API
+
+	updates, err := NewUpdateClient(api.URL, api.Client()).Check(context.Background(), dir, GameVersions{Minecraft: "1.20.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("expected one update, got %+v", updates)
+	}
+	update := updates[0]
+	if update.File != "sodium.jar" || update.ModID != "sodium" || update.Current != "0.5.0" ||
+		update.VersionID != "s2" || update.VersionNumber != "0.5.3" || update.Changelog != "Changes in 0.5.3" {
+		t.Errorf("unexpected update: %+v", update)
+	}
+
+	request := api.lastRequest()
+	if hashes, _ := request["hashes"].([]interface{}); len(hashes) != 3 {
+		t.Errorf("expected the hashes of all three jars, got %v", request["hashes"])
+	}
+	if loaders, _ := json.Marshal(request["loaders"]); string(loaders) != `["fabric"]` {
+		t.Errorf("loaders = %s", loaders)
+	}
+	if game, _ := json.Marshal(request["game_versions"]); string(game) != `["1.20.1"]` {
+		t.Errorf("game_versions = %s", game)
+	}
+}
+
+func TestCheckUpdatesWithoutGameVersion(t *testing.T) {
+	api := newMockModrinth(t)
+	dir := t.TempDir()
+	fabric := []string{"fabric"}
+
+	lithium := modJar(t, "lithium", "0.11.0")
+	api.publish("lithium",
+		mockVersion{id: "l1", number: "0.11.0", loaders: fabric, game: []string{"1.20.1"}, jar: lithium},
+		mockVersion{id: "l3", number: "0.12.0", loaders: fabric, game: []string{"1.21"}, jar: modJar(t, "lithium", "0.12.0")},
+	)
+	installJar(t, dir, "lithium.jar", lithium)
+
+	updates, err := NewUpdateClient(api.URL, api.Client()).Check(context.Background(), dir, GameVersions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := api.lastRequest()["game_versions"]; ok {
+		t.Error("game_versions sent without a detected Minecraft version")
+	}
+	if len(updates) != 1 || updates[0].VersionNumber != "0.12.0" {
+		t.Errorf("expected the newest version, got %+v", updates)
+	}
+}
+
+func TestFetchVerifiesDownload(t *testing.T) {
+	api := newMockModrinth(t)
+	dir := t.TempDir()
+	fabric := []string{"fabric"}
+	game := []string{"1.20.1"}
+
+	sodium := modJar(t, "sodium", "0.5.0")
+	newer := modJar(t, "sodium", "0.5.3")
+	api.publish("sodium",
+		mockVersion{id: "s1", number: "0.5.0", loaders: fabric, game: game, jar: sodium},
+		mockVersion{id: "s2", number: "0.5.3", loaders: fabric, game: game, jar: newer},
+	)
+	installJar(t, dir, "sodium.jar", sodium)
+
+	client := NewUpdateClient(api.URL, api.Client())
+	updates, err := client.Check(context.Background(), dir, GameVersions{Minecraft: "1.20.1"})
+	if err != nil || len(updates) != 1 {
+		t.Fatalf("expected one update, got %+v (%v)", updates, err)
+	}
+
+	path, err := client.Fetch(context.Background(), updates[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	if data, err := os.ReadFile(path); err != nil || string(data) != string(newer) {
+		t.Errorf("downloaded jar differs from the published one: %v", err)
+	}
+
+	// The SHA-1 is used when the API gives no SHA-512
+	sha1Only := updates[0]
+	sha1Only.Download.SHA512 = ""
+	path, err = client.Fetch(context.Background(), sha1Only)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+
+	noChecksum := sha1Only
+	noChecksum.Download.SHA1 = ""
+	if _, err := client.Fetch(context.Background(), noChecksum); err == nil {
+		t.Error("update without a checksum was downloaded")
+	}
+
+	api.publish("sodium",
+		mockVersion{id: "s1", number: "0.5.0", loaders: fabric, game: game, jar: sodium},
+		mockVersion{id: "s2", number: "0.5.3", loaders: fabric, game: game, jar: newer, tampered: true},
+	)
+	if _, err := client.Fetch(context.Background(), updates[0]); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Allowed difference between our clock and the provider's
+const clockSkew = time.Minute
+
+// Signing algorithms accepted on ID tokens
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+}
+
+// A key from the provider's JWKS document
+type jsonWebKey struct {
+	KeyID string `json:"kid"`
+	Type  string `json:"kty"`
+	Use   string `json:"use"`
+	N     string `json:"n"`
+	E     string `json:"e"`
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// Checks an ID token's signature, issuer, audience, lifetime and nonce,
+// returning its claims
+func (p *Provider) verify(ctx context.Context, doc *discovery, token, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %v", err)
+	}
+	hash, ok := algorithms[header.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature")
+	}
+
+	key, err := p.key(ctx, doc, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	digest := hash.New()
+	digest.Write([]byte(parts[0] + "." + parts[1]))
+	if err := checkSignature(key, header.Algorithm, hash, digest.Sum(nil), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %v", err)
+	}
+	if err := p.checkClaims(doc, claims, nonce); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Checks the standard claims of a correctly signed ID token
+func (p *Provider) checkClaims(doc *discovery, claims map[string]interface{}, nonce string) error {
+	if issuer := stringClaim(claims, "iss"); issuer != doc.Issuer {
+		return fmt.Errorf("issued by %q, expected %q", issuer, doc.Issuer)
+	}
+
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, value := range aud {
+			if name, ok := value.(string); ok {
+				audiences = append(audiences, name)
+			}
+		}
+	}
+	found := false
+	for _, audience := range audiences {
+		found = found || audience == p.config.ClientID
+	}
+	if !found {
+		return fmt.Errorf("not issued for client %q", p.config.ClientID)
+	}
+	if party := stringClaim(claims, "azp"); len(audiences) > 1 && party != p.config.ClientID {
+		return fmt.Errorf("authorized party is %q, expected %q", party, p.config.ClientID)
+	}
+
+	now := time.Now()
+	expires, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("no expiry")
+	}
+	if now.After(time.Unix(int64(expires), 0).Add(clockSkew)) {
+		return fmt.Errorf("expired")
+	}
+	if issued, ok := claims["iat"].(float64); ok && time.Unix(int64(issued), 0).After(now.Add(clockSkew)) {
+		return fmt.Errorf("issued in the future")
+	}
+	if stringClaim(claims, "nonce") != nonce {
+		return fmt.Errorf("nonce mismatch")
+	}
+	if stringClaim(claims, "sub") == "" {
+		return fmt.Errorf("no subject")
+	}
+	return nil
+}
+
+// Returns the signing key with the given ID, refetching the JWKS when the
+// provider has rotated to a key we haven't seen
+func (p *Provider) key(ctx context.Context, doc *discovery, id string) (interface{}, error) {
+	p.mutex.Lock()
+	key, ok := p.lookupKey(id)
+	stale := time.Since(p.keysLoaded) > keyRefreshDelay
+	p.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key %q", id)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, doc.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "minecrap_hoster")
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	status, err := p.do(req, &set)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("HTTP %d", status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if parsed, err := jwk.publicKey(); err == nil {
+			keys[jwk.KeyID] = parsed
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.keys = keys
+	p.keysLoaded = time.Now()
+	if key, ok := p.lookupKey(id); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", id)
+}
+
+// Finds a cached key; a token without a key ID matches a provider's only key.
+// Must be called with the mutex held.
+func (p *Provider) lookupKey(id string) (interface{}, bool) {
+	if key, ok := p.keys[id]; ok {
+		return key, true
+	}
+	if id == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Converts an RSA or EC key to its Go form
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Type {
+	case "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("malformed RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("malformed EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Type)
+}
+
+// Verifies a signature made with the given algorithm
+func checkSignature(key interface{}, algorithm string, hash crypto.Hash, digest, signature []byte) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(algorithm, "RS") {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(algorithm, "ES") || len(signature) != 2*size {
+			break
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("key doesn't match algorithm %s", algorithm)
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
@@ -0,0 +1,369 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"minecrap_hoster/internal/auth"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source recorded on accounts that sign in through OIDC
+const Source = "oidc"
+
+const (
+	loginTimeout    = 10 * time.Minute // How long a user has to finish signing in
+	discoveryMaxAge = time.Hour
+	keyRefreshDelay = time.Minute // Least time between JWKS fetches for unknown keys
+	maxResponseSize = 1 << 20
+)
+
+// Config describes the identity provider and how its users map to accounts
+type Config struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string // Optional for public clients, which rely on PKCE alone
+	RedirectURL   string // Callback URL registered with the provider; empty derives it from each request
+	Scopes        []string
+	UsernameClaim string               // Claim used as the account name; falls back to email
+	GroupsClaim   string               // Claim listing the user's groups
+	Roles         map[string]auth.Role // Group to role; the highest matching role wins
+	DefaultRole   auth.Role            // Role of users in no mapped group; empty refuses them
+}
+
+// Identity is a user the provider vouched for
+type Identity struct {
+	Subject  string
+	Username string
+	Groups   []string
+	Role     auth.Role
+}
+
+// Provider signs users in with the authorization code flow and PKCE
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mutex      sync.Mutex
+	discovery  *discovery
+	discovered time.Time
+	keys       map[string]interface{} // Keyed by key ID
+	keysLoaded time.Time
+	pending    map[string]pendingLogin // Keyed by state
+}
+
+// Endpoints from the provider's discovery document
+type discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// A sign-in the browser was sent off to the provider for
+type pendingLogin struct {
+	verifier    string
+	nonce       string
+	redirectURL string
+	expires     time.Time
+}
+
+// Checks the configuration and creates a provider; nothing is fetched until the first login
+func New(config Config, client *http.Client) (*Provider, error) {
+	issuer, err := url.Parse(config.Issuer)
+	if err != nil || issuer.Host == "" || (issuer.Scheme != "https" && issuer.Scheme != "http") {
+		return nil, fmt.Errorf("invalid issuer URL %q", config.Issuer)
+	}
+	if config.ClientID == "" {
+		return nil, fmt.Errorf("a client ID is required")
+	}
+	if issuer.Scheme == "http" && !isLoopback(issuer.Hostname()) {
+		log.Printf("Warning: OIDC issuer %s is not using HTTPS", config.Issuer)
+	}
+	if !slices.Contains(config.Scopes, "openid") {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return &Provider{
+		config:  config,
+		client:  client,
+		keys:    make(map[string]interface{}),
+		pending: make(map[string]pendingLogin),
+	}, nil
+}
+
+// Returns the configured callback URL, if any
+func (p *Provider) RedirectURL() string {
+	return p.config.RedirectURL
+}
+
+// Returns the provider's authorization URL for a new sign-in and the state
+// the callback must return. redirectURL is where the provider sends the user back.
+func (p *Provider) AuthURL(ctx context.Context, redirectURL string) (string, string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err1 := randomString()
+	nonce, err2 := randomString()
+	verifier, err3 := randomString()
+	if err1 != nil || err2 != nil || err3 != nil {
+		return "", "", fmt.Errorf("failed to generate login secrets")
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	p.mutex.Lock()
+	now := time.Now()
+	for key, login := range p.pending {
+		if now.After(login.expires) {
+			delete(p.pending, key)
+		}
+	}
+	p.pending[state] = pendingLogin{
+		verifier:    verifier,
+		nonce:       nonce,
+		redirectURL: redirectURL,
+		expires:     now.Add(loginTimeout),
+	}
+	p.mutex.Unlock()
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return doc.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// Finishes a sign-in: redeems the code, verifies the ID token and maps the
+// user's groups to a role
+func (p *Provider) Exchange(ctx context.Context, state, code string) (Identity, error) {
+	p.mutex.Lock()
+	login, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mutex.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return Identity{}, fmt.Errorf("unknown or expired login attempt")
+	}
+	if code == "" {
+		return Identity{}, fmt.Errorf("the provider returned no authorization code")
+	}
+
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	rawToken, err := p.redeem(ctx, doc, code, login)
+	if err != nil {
+		return Identity{}, err
+	}
+	claims, err := p.verify(ctx, doc, rawToken, login.nonce)
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid ID token: %v", err)
+	}
+	return p.identity(claims)
+}
+
+// Sends the authorization code and PKCE verifier to the token endpoint
+func (p *Provider) redeem(ctx context.Context, doc *discovery, code string, login pendingLogin) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {login.redirectURL},
+		"code_verifier": {login.verifier},
+		"client_id":     {p.config.ClientID},
+	}
+	// Prefer HTTP basic authentication unless the provider only takes the secret in the body
+	postSecret := p.config.ClientSecret != "" && len(doc.TokenAuthMethods) > 0 &&
+		!slices.Contains(doc.TokenAuthMethods, "client_secret_basic") && slices.Contains(doc.TokenAuthMethods, "client_secret_post")
+	if postSecret {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "minecrap_hoster")
+	if p.config.ClientSecret != "" && !postSecret {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var result struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.do(req, &result)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %v", err)
+	}
+	if result.Error != "" {
+		return "", fmt.Errorf("token request refused: %s %s", result.Error, result.ErrorDescription)
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("token request returned HTTP %d", status)
+	}
+	if result.IDToken == "" {
+		return "", fmt.Errorf("the provider returned no ID token")
+	}
+	return result.IDToken, nil
+}
+
+// Reads the account name, groups and role from verified claims
+func (p *Provider) identity(claims map[string]interface{}) (Identity, error) {
+	identity := Identity{Subject: stringClaim(claims, "sub")}
+
+	identity.Username = stringClaim(claims, p.config.UsernameClaim)
+	if identity.Username == "" {
+		identity.Username = stringClaim(claims, "email")
+	}
+	if identity.Username == "" {
+		return Identity{}, fmt.Errorf("the ID token has no %s or email claim", p.config.UsernameClaim)
+	}
+
+	switch groups := claims[p.config.GroupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	}
+
+	identity.Role = p.config.DefaultRole
+	for _, group := range identity.Groups {
+		if role, ok := p.config.Roles[group]; ok && (identity.Role == "" || role.Allows(identity.Role)) {
+			identity.Role = role
+		}
+	}
+	if identity.Role == "" {
+		return Identity{}, fmt.Errorf("%s is in no group with access to the hoster", identity.Username)
+	}
+	return identity, nil
+}
+
+// Fetches the discovery document, cached for an hour
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mutex.Lock()
+	if p.discovery != nil && time.Since(p.discovered) < discoveryMaxAge {
+		doc := p.discovery
+		p.mutex.Unlock()
+		return doc, nil
+	}
+	p.mutex.Unlock()
+
+	issuer := strings.TrimRight(p.config.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "minecrap_hoster")
+
+	var doc discovery
+	status, err := p.do(req, &doc)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("HTTP %d", status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %v", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", doc.Issuer, p.config.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document is missing endpoints")
+	}
+
+	p.mutex.Lock()
+	p.discovery = &doc
+	p.discovered = time.Now()
+	p.mutex.Unlock()
+	return &doc, nil
+}
+
+// Sends a request and decodes the JSON response, returning its status
+func (p *Provider) do(req *http.Request, result interface{}) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(result); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("invalid response: %v", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// Parses "group=role" pairs separated by commas
+func ParseRoles(text string) (map[string]auth.Role, error) {
+	roles := make(map[string]auth.Role)
+	for _, pair := range strings.Split(text, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, name, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(group) == "" {
+			return nil, fmt.Errorf("invalid group mapping %q (expected group=role)", pair)
+		}
+		role, err := auth.ParseRole(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		roles[strings.TrimSpace(group)] = role
+	}
+	return roles, nil
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func isLoopback(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
@@ -0,0 +1,354 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"minecrap_hoster/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testClientID = "hoster"
+
+// A provider with discovery, JWKS and token endpoints that hands out
+// whatever ID token the test sets
+type mockIssuer struct {
+	*httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mutex       sync.Mutex
+	keys        []jsonWebKey // Published in the JWKS
+	jwksFetches int
+	idToken     string
+	tokenForm   url.Values // Last form posted to the token endpoint
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &mockIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	issuer.keys = []jsonWebKey{rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("ec", &ecKey.PublicKey)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                issuer.URL,
+			AuthorizationEndpoint: issuer.URL + "/authorize",
+			TokenEndpoint:         issuer.URL + "/token",
+			JWKSURI:               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mutex.Lock()
+		defer issuer.mutex.Unlock()
+		issuer.jwksFetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": issuer.keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		issuer.mutex.Lock()
+		defer issuer.mutex.Unlock()
+		issuer.tokenForm = r.PostForm
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.idToken, "token_type": "Bearer"})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// Returns a provider for the mock issuer, with its discovery document loaded
+func (m *mockIssuer) provider(t *testing.T) (*Provider, *discovery) {
+	t.Helper()
+	p, err := New(Config{
+		Issuer:   m.URL,
+		ClientID: testClientID,
+		Roles:    map[string]auth.Role{"admins": auth.RoleAdmin},
+	}, m.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := p.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, doc
+}
+
+func (m *mockIssuer) fetches() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.jwksFetches
+}
+
+// Returns claims the provider accepts for the given nonce
+func (m *mockIssuer) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                m.URL,
+		"aud":                testClientID,
+		"sub":                "user-1",
+		"exp":                now.Add(time.Hour).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"groups":             []string{"admins"},
+	}
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func rsaJWK(id string, key *rsa.PublicKey) jsonWebKey {
+	return jsonWebKey{
+		KeyID: id,
+		Type:  "RSA",
+		Use:   "sig",
+		N:     encodeSegment(key.N.Bytes()),
+		E:     encodeSegment(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(id string, key *ecdsa.PublicKey) jsonWebKey {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	return jsonWebKey{
+		KeyID: id,
+		Type:  "EC",
+		Curve: "P-256",
+		X:     encodeSegment(key.X.FillBytes(x)),
+		Y:     encodeSegment(key.Y.FillBytes(y)),
+	}
+}
+
+// Builds a token with the given header algorithm and key ID, signed by key
+// whatever the algorithm says
+func sign(t *testing.T, algorithm, keyID string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": algorithm, "kid": keyID, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := encodeSegment(header) + "." + encodeSegment(payload)
+
+	hash := algorithms[algorithm]
+	digest := hash.New()
+	digest.Write([]byte(input))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest.Sum(nil))
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest.Sum(nil))
+		if err == nil {
+			size := (key.Curve.Params().BitSize + 7) / 8
+			signature = make([]byte, 2*size)
+			r.FillBytes(signature[:size])
+			s.FillBytes(signature[size:])
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + encodeSegment(signature)
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newMockIssuer(t)
+	p, _ := issuer.provider(t)
+
+	authURL, state, err := p.AuthURL(context.Background(), "https://hoster.example/api/auth/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("state") != state || query.Get("client_id") != testClientID || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+
+	issuer.idToken = sign(t, "RS256", "rsa", issuer.rsaKey, issuer.claims(query.Get("nonce")))
+	identity, err := p.Exchange(context.Background(), state, "the-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "user-1" || identity.Username != "alice" || identity.Role != auth.RoleAdmin {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	// The verifier sent with the code must match the challenge sent to the browser
+	issuer.mutex.Lock()
+	form := issuer.tokenForm
+	issuer.mutex.Unlock()
+	challenge := sha256.Sum256([]byte(form.Get("code_verifier")))
+	if encodeSegment(challenge[:]) != query.Get("code_challenge") || form.Get("code") != "the-code" {
+		t.Errorf("token request %v doesn't match the authorization request", form)
+	}
+
+	// A state can only be redeemed once
+	if _, err := p.Exchange(context.Background(), state, "the-code"); err == nil {
+		t.Error("expected a replayed state to be refused")
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	p, _ := issuer.provider(t)
+
+	_, state, err := p.AuthURL(context.Background(), "https://hoster.example/api/auth/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.idToken = sign(t, "RS256", "rsa", issuer.rsaKey, issuer.claims("some other login's nonce"))
+
+	_, err = p.Exchange(context.Background(), state, "the-code")
+	if err == nil || !strings.Contains(err.Error(), "nonce mismatch") {
+		t.Fatalf("expected a nonce mismatch, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	issuer := newMockIssuer(t)
+	p, doc := issuer.provider(t)
+
+	for _, token := range []string{
+		sign(t, "RS256", "rsa", issuer.rsaKey, issuer.claims("n")),
+		sign(t, "RS512", "rsa", issuer.rsaKey, issuer.claims("n")),
+		sign(t, "ES256", "ec", issuer.ecKey, issuer.claims("n")),
+	} {
+		if _, err := p.verify(context.Background(), doc, token, "n"); err != nil {
+			t.Errorf("valid token refused: %v", err)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	issuer := newMockIssuer(t)
+	p, doc := issuer.provider(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Returns valid claims with one changed, or removed if value is nil
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := issuer.claims("n")
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	valid := issuer.claims("n")
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"bad signature", sign(t, "RS256", "rsa", otherKey, valid), "bad signature"},
+		{"tampered claims", func() string {
+			parts := strings.Split(sign(t, "RS256", "rsa", issuer.rsaKey, valid), ".")
+			forged, _ := json.Marshal(with("sub", "admin"))
+			return parts[0] + "." + encodeSegment(forged) + "." + parts[2]
+		}(), "bad signature"},
+		{"wrong audience", sign(t, "RS256", "rsa", issuer.rsaKey, with("aud", "another-client")), "not issued for client"},
+		{"other party among audiences", sign(t, "RS256", "rsa", issuer.rsaKey, with("aud", []string{testClientID, "another-client"})), "authorized party"},
+		{"wrong issuer", sign(t, "RS256", "rsa", issuer.rsaKey, with("iss", "https://evil.example")), "issued by"},
+		{"expired", sign(t, "RS256", "rsa", issuer.rsaKey, with("exp", time.Now().Add(-2*clockSkew).Unix())), "expired"},
+		{"no expiry", sign(t, "RS256", "rsa", issuer.rsaKey, with("exp", nil)), "no expiry"},
+		{"issued in the future", sign(t, "RS256", "rsa", issuer.rsaKey, with("iat", time.Now().Add(2*clockSkew).Unix())), "issued in the future"},
+		{"nonce mismatch", sign(t, "RS256", "rsa", issuer.rsaKey, with("nonce", "other")), "nonce mismatch"},
+		{"no subject", sign(t, "RS256", "rsa", issuer.rsaKey, with("sub", nil)), "no subject"},
+		{"RSA algorithm with an EC key", sign(t, "RS256", "ec", issuer.ecKey, valid), "key doesn't match algorithm RS256"},
+		{"EC algorithm with an RSA key", sign(t, "ES256", "rsa", issuer.rsaKey, valid), "key doesn't match algorithm ES256"},
+		{"unsigned", encodeSegment([]byte(`{"alg":"none"}`)) + "." + encodeSegment([]byte(`{}`)) + ".", "unsupported signing algorithm"},
+		{"malformed", "not-a-token", "malformed token"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := p.verify(context.Background(), doc, test.token, "n")
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("expected an error containing %q, got %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestVerifyUnknownKey(t *testing.T) {
+	issuer := newMockIssuer(t)
+	p, doc := issuer.provider(t)
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, "RS256", "rotated", rotated, issuer.claims("n"))
+
+	// The first lookup loads the JWKS, which doesn't have the key yet
+	_, err = p.verify(context.Background(), doc, token, "n")
+	if err == nil || !strings.Contains(err.Error(), `unknown signing key "rotated"`) {
+		t.Fatalf("expected an unknown key, got %v", err)
+	}
+	if fetches := issuer.fetches(); fetches != 1 {
+		t.Fatalf("JWKS fetched %d times, expected once", fetches)
+	}
+
+	// Unknown keys don't trigger another fetch until the refresh delay has passed
+	issuer.mutex.Lock()
+	issuer.keys = append(issuer.keys, rsaJWK("rotated", &rotated.PublicKey))
+	issuer.mutex.Unlock()
+	if _, err := p.verify(context.Background(), doc, token, "n"); err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("expected an unknown key before the refresh delay, got %v", err)
+	}
+	if fetches := issuer.fetches(); fetches != 1 {
+		t.Fatalf("JWKS fetched %d times within the refresh delay", fetches)
+	}
+
+	// Once it has, the rotated key is fetched and accepted
+	p.mutex.Lock()
+	p.keysLoaded = time.Now().Add(-keyRefreshDelay - time.Second)
+	p.mutex.Unlock()
+	if _, err := p.verify(context.Background(), doc, token, "n"); err != nil {
+		t.Fatalf("rotated key refused after the refresh: %v", err)
+	}
+	if fetches := issuer.fetches(); fetches != 2 {
+		t.Fatalf("JWKS fetched %d times, expected twice", fetches)
+	}
+
+	// A key the provider never published is still refused after the refresh
+	forged := sign(t, "RS256", "forged", rotated, issuer.claims("n"))
+	if _, err := p.verify(context.Background(), doc, forged, "n"); err == nil || !strings.Contains(err.Error(), `unknown signing key "forged"`) {
+		t.Fatalf("expected an unknown key after the refresh, got %v", err)
+	}
+	if fetches := issuer.fetches(); fetches != 2 {
+		t.Errorf("JWKS fetched %d times; the refresh delay should limit fetches", fetches)
+	}
+}
@@ -0,0 +1,49 @@
+package provision
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"minecrap_hoster/internal/mods"
+)
+
+var (
+	// paper-1.20.4-496.jar, minecraft_server.1.20.1.jar and similar
+	jarVersionPattern = regexp.MustCompile(`(?:^|[-_.])(1\.[0-9]+(?:\.[0-9]+)?)(?:[-_.]|$)`)
+	// libraries/net/neoforged/neoforge/20.4.237/unix_args.txt
+	neoForgeVersionPattern = regexp.MustCompile(`^([0-9]+)\.([0-9]+)\.`)
+)
+
+// Works out which Minecraft version the launch file in dir runs, from the
+// provision record, the Fabric launcher or the file's name. Returns "" when
+// it cannot be told.
+func DetectMinecraftVersion(dir, jar string) string {
+	if record, err := LoadRecord(dir); err == nil && record != nil && record.Jar == filepath.Base(jar) {
+		return record.Minecraft
+	}
+	if game := mods.DetectGameVersions(jar); game.Minecraft != "" {
+		return game.Minecraft
+	}
+
+	// Forge and NeoForge keep the version in the argument file's directory
+	if filepath.Base(jar) == "unix_args.txt" {
+		version := filepath.Base(filepath.Dir(jar))
+		if strings.Contains(filepath.ToSlash(jar), "/neoforged/") {
+			if match := neoForgeVersionPattern.FindStringSubmatch(version); match != nil {
+				if match[2] == "0" {
+					return "1." + match[1]
+				}
+				return "1." + match[1] + "." + match[2]
+			}
+			return ""
+		}
+		minecraft, _, _ := strings.Cut(version, "-")
+		return minecraft
+	}
+
+	if match := jarVersionPattern.FindStringSubmatch(filepath.Base(jar)); match != nil {
+		return match[1]
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package provision
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type fabricGameVersion struct {
+	Version string `json:"version"`
+	Stable  bool   `json:"stable"`
+}
+
+type fabricLoaderEntry struct {
+	Loader struct {
+		Version string `json:"version"`
+		Stable  bool   `json:"stable"`
+	} `json:"loader"`
+}
+
+func (p *Provisioner) fabricVersions(ctx context.Context) ([]string, error) {
+	var games []fabricGameVersion
+	if err := p.getJSON(ctx, p.sources.Fabric+"/v2/versions/game", &games); err != nil {
+		return nil, err
+	}
+	return stableVersions(games), nil
+}
+
+func stableVersions(games []fabricGameVersion) []string {
+	var versions []string
+	for _, game := range games {
+		if game.Stable {
+			versions = append(versions, game.Version)
+		}
+	}
+	return versions
+}
+
+// Fabric serves a small launcher jar that downloads the game on first start.
+// Its meta API publishes no checksum, so the jar's install.properties is
+// checked against the requested versions instead.
+func (p *Provisioner) resolveFabric(ctx context.Context, req Request) (*artifact, error) {
+	game := req.Minecraft
+	if game == "" {
+		versions, err := p.fabricVersions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("no Fabric game versions available")
+		}
+		game = versions[0]
+	}
+	if err := validVersion(game); err != nil {
+		return nil, err
+	}
+
+	loader := req.Loader
+	if loader == "" {
+		var loaders []fabricLoaderEntry
+		if err := p.getJSON(ctx, fmt.Sprintf("%s/v2/versions/loader/%s", p.sources.Fabric, game), &loaders); err != nil {
+			return nil, err
+		}
+		for _, entry := range loaders {
+			if entry.Loader.Stable {
+				loader = entry.Loader.Version
+				break
+			}
+		}
+		if loader == "" {
+			return nil, fmt.Errorf("no stable Fabric loader for Minecraft %s", game)
+		}
+	}
+	if err := validVersion(loader); err != nil {
+		return nil, err
+	}
+
+	var installers []fabricGameVersion
+	if err := p.getJSON(ctx, p.sources.Fabric+"/v2/versions/installer", &installers); err != nil {
+		return nil, err
+	}
+	stable := stableVersions(installers)
+	if len(stable) == 0 {
+		return nil, fmt.Errorf("no stable Fabric installer available")
+	}
+	installer := stable[0]
+	if err := validVersion(installer); err != nil {
+		return nil, err
+	}
+
+	return &artifact{
+		url: fmt.Sprintf("%s/v2/versions/loader/%s/%s/%s/server/jar", p.sources.Fabric, game, loader, installer),
+		record: Installed{
+			Flavor:    Fabric,
+			Minecraft: game,
+			Loader:    loader,
+			Build:     installer,
+			// Same naming as Fabric's own download, which the mod check reads versions from
+			Jar: fmt.Sprintf("fabric-server-mc.%s-loader.%s-launcher.%s.jar", game, loader, installer),
+		},
+	}, nil
+}
+
+func verifyFabricLauncher(path string, record Installed) error {
+	jar, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("downloaded Fabric launcher is not a jar: %v", err)
+	}
+	defer jar.Close()
+
+	file, err := jar.Open("install.properties")
+	if err != nil {
+		return fmt.Errorf("downloaded Fabric launcher has no install.properties")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, 64<<10))
+	if err != nil {
+		return err
+	}
+
+	props := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		props[key] = value
+	}
+	if props["game-version"] != record.Minecraft || props["fabric-loader-version"] != record.Loader {
+		return fmt.Errorf("downloaded Fabric launcher is for Minecraft %s loader %s, expected %s loader %s",
+			props["game-version"], props["fabric-loader-version"], record.Minecraft, record.Loader)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+type paperProject struct {
+	Versions []string `json:"versions"` // Oldest first
+}
+
+type paperBuilds struct {
+	Builds []struct {
+		Build     int    `json:"build"`
+		Channel   string `json:"channel"`
+		Downloads map[string]struct {
+			Name   string `json:"name"`
+			SHA256 string `json:"sha256"`
+		} `json:"downloads"`
+	} `json:"builds"` // Oldest first
+}
+
+func (p *Provisioner) paperVersions(ctx context.Context) ([]string, error) {
+	var project paperProject
+	if err := p.getJSON(ctx, p.sources.Paper+"/v2/projects/paper", &project); err != nil {
+		return nil, err
+	}
+
+	versions := slices.Clone(project.Versions)
+	slices.Reverse(versions)
+	return versions, nil
+}
+
+func (p *Provisioner) resolvePaper(ctx context.Context, req Request) (*artifact, error) {
+	version := req.Minecraft
+	if version == "" {
+		versions, err := p.paperVersions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("no Paper versions available")
+		}
+		version = versions[0]
+	}
+	if err := validVersion(version); err != nil {
+		return nil, err
+	}
+
+	var builds paperBuilds
+	base := fmt.Sprintf("%s/v2/projects/paper/versions/%s", p.sources.Paper, version)
+	if err := p.getJSON(ctx, base+"/builds", &builds); err != nil {
+		return nil, err
+	}
+
+	// Newest stable build; the loader field selects a specific build number
+	for i := len(builds.Builds) - 1; i >= 0; i-- {
+		build := builds.Builds[i]
+		if req.Loader != "" && fmt.Sprint(build.Build) != req.Loader {
+			continue
+		}
+		if req.Loader == "" && build.Channel != "default" {
+			continue
+		}
+
+		app, ok := build.Downloads["application"]
+		if !ok {
+			continue
+		}
+		if err := validVersion(app.Name); err != nil {
+			return nil, fmt.Errorf("invalid Paper download name %q", app.Name)
+		}
+
+		return &artifact{
+			url:       fmt.Sprintf("%s/builds/%d/downloads/%s", base, build.Build, app.Name),
+			algorithm: "sha256",
+			checksum:  app.SHA256,
+			record: Installed{
+				Flavor:    Paper,
+				Minecraft: version,
+				Build:     fmt.Sprint(build.Build),
+				Jar:       app.Name,
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("no matching Paper build for %s", version)
+}
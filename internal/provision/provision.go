@@ -0,0 +1,281 @@
+package provision
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Returned when an install is started while another one is still running
+var ErrBusy = errors.New("a server install is already running")
+
+// Provisioner downloads server jars and loaders into a server directory
+type Provisioner struct {
+	sources  Sources
+	client   *http.Client
+	javaPath string // Used to run installers that must build the server themselves
+	busy     atomic.Bool
+	reserved atomic.Bool // Held by a caller for the steps around an install
+}
+
+// Creates a provisioner reading manifests from the given sources
+func NewProvisioner(sources Sources, javaPath string, client *http.Client) *Provisioner {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	return &Provisioner{sources: sources, client: client, javaPath: javaPath}
+}
+
+// Reports whether an install is in progress or reserved
+func (p *Provisioner) Busy() bool {
+	return p.busy.Load() || p.reserved.Load()
+}
+
+// Claims the provisioner for an install and the steps before it, such as a
+// backup, so Busy reports true throughout; false if it is already claimed.
+// The caller must Release it.
+func (p *Provisioner) Reserve() bool {
+	return p.reserved.CompareAndSwap(false, true)
+}
+
+// Ends a reservation taken with Reserve
+func (p *Provisioner) Release() {
+	p.reserved.Store(false)
+}
+
+// Returns the record of the jar provisioned in dir, or nil if there is none
+func LoadRecord(dir string) (*Installed, error) {
+	data, err := os.ReadFile(filepath.Join(dir, recordFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record Installed
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", recordFile, err)
+	}
+	return &record, nil
+}
+
+// Parses "flavor[:minecraft[:loader]]", as used by the -provision flag
+func ParseRequest(text string) (Request, error) {
+	parts := strings.Split(text, ":")
+	if len(parts) > 3 {
+		return Request{}, fmt.Errorf("invalid provision request %q", text)
+	}
+	req := Request{Flavor: strings.ToLower(parts[0])}
+	if len(parts) > 1 {
+		req.Minecraft = parts[1]
+	}
+	if len(parts) > 2 {
+		req.Loader = parts[2]
+	}
+	return req, validateFlavor(req.Flavor)
+}
+
+func validateFlavor(flavor string) error {
+	switch flavor {
+	case Vanilla, Fabric, Quilt, Paper:
+		return nil
+	}
+	return fmt.Errorf("unknown server flavor %q", flavor)
+}
+
+// Lists the Minecraft versions a flavor can be installed for, newest first
+func (p *Provisioner) Versions(ctx context.Context, flavor string) ([]string, error) {
+	switch flavor {
+	case Vanilla:
+		return p.vanillaVersions(ctx)
+	case Fabric:
+		return p.fabricVersions(ctx)
+	case Quilt:
+		return p.quiltVersions(ctx)
+	case Paper:
+		return p.paperVersions(ctx)
+	}
+	return nil, validateFlavor(flavor)
+}
+
+// Downloads and verifies the requested server into dir and records it. The
+// previous jar is left in place so an upgrade can be rolled back by hand.
+func (p *Provisioner) Install(ctx context.Context, dir string, req Request) (*Installed, error) {
+	if err := validateFlavor(req.Flavor); err != nil {
+		return nil, err
+	}
+	if !p.busy.CompareAndSwap(false, true) {
+		return nil, ErrBusy
+	}
+	defer p.busy.Store(false)
+
+	var record *Installed
+	var err error
+	if req.Flavor == Quilt {
+		record, err = p.installQuilt(ctx, dir, req)
+	} else {
+		record, err = p.installJar(ctx, dir, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record.Installed = time.Now().UTC()
+	if err := saveRecord(dir, *record); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Provisioned %s %s into %s as %s", record.Flavor, record.Minecraft, dir, record.Jar)
+	return record, nil
+}
+
+func (p *Provisioner) installJar(ctx context.Context, dir string, req Request) (*Installed, error) {
+	var art *artifact
+	var err error
+	switch req.Flavor {
+	case Vanilla:
+		art, err = p.resolveVanilla(ctx, req)
+	case Fabric:
+		art, err = p.resolveFabric(ctx, req)
+	case Paper:
+		art, err = p.resolvePaper(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dest := filepath.Join(dir, art.record.Jar)
+	sum, err := p.download(ctx, art, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Flavor == Fabric {
+		if err := verifyFabricLauncher(dest, art.record); err != nil {
+			os.Remove(dest)
+			return nil, err
+		}
+	}
+
+	art.record.SHA256 = sum
+	return &art.record, nil
+}
+
+// Fetches an artifact to dest, checking the published checksum, and returns its SHA-256
+func (p *Provisioner) download(ctx context.Context, art *artifact, dest string) (string, error) {
+	log.Printf("Downloading %s", art.url)
+	resp, err := p.get(ctx, art.url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".provision-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	local := sha256.New()
+	writers := []io.Writer{tmp, local}
+	var published hash.Hash
+	switch art.algorithm {
+	case "sha1":
+		published = sha1.New()
+	case "sha256":
+		published = local
+	case "sha512":
+		published = sha512.New()
+	}
+	if published != nil && published != local {
+		writers = append(writers, published)
+	}
+
+	size, err := io.Copy(io.MultiWriter(writers...), io.LimitReader(resp.Body, maxDownloadSize+1))
+	tmp.Close()
+	if err != nil {
+		return "", fmt.Errorf("download failed: %v", err)
+	}
+	if size > maxDownloadSize {
+		return "", fmt.Errorf("download is larger than %d MB", maxDownloadSize>>20)
+	}
+	if art.size > 0 && size != art.size {
+		return "", fmt.Errorf("size mismatch: got %d bytes, expected %d", size, art.size)
+	}
+	if published != nil {
+		if got := hex.EncodeToString(published.Sum(nil)); !strings.EqualFold(got, art.checksum) {
+			return "", fmt.Errorf("%s mismatch for %s: got %s, expected %s", art.algorithm, filepath.Base(dest), got, art.checksum)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(local.Sum(nil)), nil
+}
+
+func (p *Provisioner) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "minecrap_hoster")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s returned HTTP %d", url, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (p *Provisioner) getJSON(ctx context.Context, url string, result interface{}) error {
+	resp, err := p.get(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 32<<20)).Decode(result); err != nil {
+		return fmt.Errorf("invalid response from %s: %v", url, err)
+	}
+	return nil
+}
+
+func saveRecord(dir string, record Installed) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, recordFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save provision record: %v", err)
+	}
+	return os.Rename(tmp, filepath.Join(dir, recordFile))
+}
+
+// Rejects versions that could escape the URL path or file name they are placed in
+func validVersion(version string) error {
+	if version == "" || strings.ContainsAny(version, "/\\?#% ") || strings.Contains(version, "..") {
+		return fmt.Errorf("invalid version %q", version)
+	}
+	return nil
+}
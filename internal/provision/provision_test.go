@@ -0,0 +1,356 @@
+package provision
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Serves canned responses by path, standing in for the manifest and download hosts
+type manifestServer struct {
+	*httptest.Server
+	files map[string]string
+}
+
+func newManifestServer(t *testing.T) *manifestServer {
+	t.Helper()
+	server := &manifestServer{files: make(map[string]string)}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := server.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Serves value encoded as JSON at path
+func (s *manifestServer) json(t *testing.T, path string, value interface{}) {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.files[path] = string(data)
+}
+
+// Returns a provisioner reading every flavor from the test server
+func (s *manifestServer) provisioner(javaPath string) *Provisioner {
+	sources := Sources{
+		Vanilla:    s.URL + "/mc/version_manifest_v2.json",
+		Fabric:     s.URL + "/fabric",
+		Quilt:      s.URL + "/quilt",
+		QuiltMaven: s.URL + "/maven",
+		Paper:      s.URL + "/paper",
+	}
+	return NewProvisioner(sources, javaPath, s.Client())
+}
+
+func sha1Hex(content string) string {
+	sum := sha1.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Builds a jar holding only the given install.properties
+func launcherJar(t *testing.T, properties string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	writer, err := archive.Create("install.properties")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte(properties))
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func serveVanilla(t *testing.T, s *manifestServer, jar, sha1 string) {
+	s.json(t, "/mc/version_manifest_v2.json", map[string]interface{}{
+		"latest": map[string]string{"release": "1.20.1"},
+		"versions": []map[string]string{
+			{"id": "1.20.2-pre1", "type": "snapshot", "url": s.URL + "/mc/1.20.2-pre1.json"},
+			{"id": "1.20.1", "type": "release", "url": s.URL + "/mc/1.20.1.json"},
+		},
+	})
+	s.json(t, "/mc/1.20.1.json", map[string]interface{}{
+		"downloads": map[string]interface{}{
+			"server": map[string]interface{}{"sha1": sha1, "size": len(jar), "url": s.URL + "/mc/server.jar"},
+		},
+	})
+	s.files["/mc/server.jar"] = jar
+}
+
+func serveFabric(t *testing.T, s *manifestServer, jar string) {
+	s.json(t, "/fabric/v2/versions/game", []map[string]interface{}{
+		{"version": "23w31a", "stable": false},
+		{"version": "1.20.1", "stable": true},
+	})
+	s.json(t, "/fabric/v2/versions/loader/1.20.1", []map[string]interface{}{
+		{"loader": map[string]interface{}{"version": "0.15.0-beta.1", "stable": false}},
+		{"loader": map[string]interface{}{"version": "0.14.22", "stable": true}},
+	})
+	s.json(t, "/fabric/v2/versions/installer", []map[string]interface{}{
+		{"version": "0.11.2", "stable": true},
+	})
+	s.files["/fabric/v2/versions/loader/1.20.1/0.14.22/0.11.2/server/jar"] = jar
+}
+
+func servePaper(t *testing.T, s *manifestServer, jar, sha256 string) {
+	s.json(t, "/paper/v2/projects/paper", map[string]interface{}{"versions": []string{"1.19.4", "1.20.1"}})
+	s.json(t, "/paper/v2/projects/paper/versions/1.20.1/builds", map[string]interface{}{
+		"builds": []map[string]interface{}{
+			{"build": 195, "channel": "default", "downloads": map[string]interface{}{
+				"application": map[string]string{"name": "paper-1.20.1-195.jar", "sha256": sha256},
+			}},
+			{"build": 196, "channel": "experimental", "downloads": map[string]interface{}{
+				"application": map[string]string{"name": "paper-1.20.1-196.jar", "sha256": sha256},
+			}},
+		},
+	})
+	s.files["/paper/v2/projects/paper/versions/1.20.1/builds/195/downloads/paper-1.20.1-195.jar"] = jar
+}
+
+func serveQuilt(t *testing.T, s *manifestServer, installer, sha1 string) {
+	s.json(t, "/quilt/v3/versions/game", []map[string]interface{}{{"version": "1.20.1", "stable": true}})
+	s.json(t, "/quilt/v3/versions/loader/1.20.1", []map[string]interface{}{
+		{"loader": map[string]string{"version": "0.20.0-beta.1"}},
+		{"loader": map[string]string{"version": "0.19.2"}},
+	})
+	base := "/maven/" + quiltInstallerPath
+	s.files[base+"/maven-metadata.xml"] = "<metadata><versioning><release>0.9.1</release></versioning></metadata>"
+	s.files[base+"/0.9.1/quilt-installer-0.9.1.jar"] = installer
+	s.files[base+"/0.9.1/quilt-installer-0.9.1.jar.sha1"] = sha1 + "  quilt-installer-0.9.1.jar\n"
+}
+
+// Checks that a failed install left neither a jar nor a record behind
+func assertNothingInstalled(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("%s left in the server directory", entry.Name())
+	}
+}
+
+// Checks the returned record against the one saved in dir and the jar on disk
+func assertRecorded(t *testing.T, dir string, record *Installed, want Installed) {
+	t.Helper()
+	if record.Flavor != want.Flavor || record.Minecraft != want.Minecraft || record.Loader != want.Loader ||
+		record.Build != want.Build || record.Jar != want.Jar {
+		t.Errorf("installed %+v, expected %+v", *record, want)
+	}
+	if record.Installed.IsZero() {
+		t.Error("install time not recorded")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, record.Jar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.SHA256 != sha256Hex(string(data)) {
+		t.Errorf("recorded SHA-256 %s does not match the installed jar", record.SHA256)
+	}
+
+	saved, err := LoadRecord(dir)
+	if err != nil || saved == nil {
+		t.Fatalf("no saved record: %v", err)
+	}
+	if !saved.Installed.Equal(record.Installed) || saved.SHA256 != record.SHA256 || saved.Jar != record.Jar {
+		t.Errorf("saved record %+v differs from %+v", *saved, *record)
+	}
+}
+
+func TestInstallVanilla(t *testing.T) {
+	server := newManifestServer(t)
+	serveVanilla(t, server, "vanilla server", sha1Hex("vanilla server"))
+	dir := t.TempDir()
+
+	record, err := server.provisioner("").Install(context.Background(), dir, Request{Flavor: Vanilla})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRecorded(t, dir, record, Installed{Flavor: Vanilla, Minecraft: "1.20.1", Jar: "minecraft_server.1.20.1.jar"})
+}
+
+func TestReservationKeepsBusyAroundInstall(t *testing.T) {
+	server := newManifestServer(t)
+	serveVanilla(t, server, "vanilla server", sha1Hex("vanilla server"))
+	p := server.provisioner("")
+
+	if !p.Reserve() {
+		t.Fatal("reservation refused on an idle provisioner")
+	}
+	if p.Reserve() {
+		t.Error("second reservation taken")
+	}
+	if _, err := p.Install(context.Background(), t.TempDir(), Request{Flavor: Vanilla}); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Busy() {
+		t.Error("not busy after the install while still reserved")
+	}
+	p.Release()
+	if p.Busy() {
+		t.Error("busy after release")
+	}
+}
+
+func TestInstallVanillaRejectsChecksumMismatch(t *testing.T) {
+	server := newManifestServer(t)
+	serveVanilla(t, server, "tampered server", sha1Hex("vanilla server"))
+	dir := t.TempDir()
+
+	_, err := server.provisioner("").Install(context.Background(), dir, Request{Flavor: Vanilla, Minecraft: "1.20.1"})
+	if err == nil || !strings.Contains(err.Error(), "sha1 mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	assertNothingInstalled(t, dir)
+}
+
+func TestInstallPaper(t *testing.T) {
+	server := newManifestServer(t)
+	servePaper(t, server, "paper server", sha256Hex("paper server"))
+	dir := t.TempDir()
+
+	record, err := server.provisioner("").Install(context.Background(), dir, Request{Flavor: Paper})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRecorded(t, dir, record, Installed{Flavor: Paper, Minecraft: "1.20.1", Build: "195", Jar: "paper-1.20.1-195.jar"})
+}
+
+func TestInstallPaperRejectsChecksumMismatch(t *testing.T) {
+	server := newManifestServer(t)
+	servePaper(t, server, "tampered server", sha256Hex("paper server"))
+	dir := t.TempDir()
+
+	_, err := server.provisioner("").Install(context.Background(), dir, Request{Flavor: Paper, Minecraft: "1.20.1"})
+	if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	assertNothingInstalled(t, dir)
+}
+
+func TestInstallFabric(t *testing.T) {
+	server := newManifestServer(t)
+	serveFabric(t, server, launcherJar(t, "fabric-loader-version=0.14.22\ngame-version=1.20.1\n"))
+	dir := t.TempDir()
+
+	record, err := server.provisioner("").Install(context.Background(), dir, Request{Flavor: Fabric})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRecorded(t, dir, record, Installed{
+		Flavor:    Fabric,
+		Minecraft: "1.20.1",
+		Loader:    "0.14.22",
+		Build:     "0.11.2",
+		Jar:       "fabric-server-mc.1.20.1-loader.0.14.22-launcher.0.11.2.jar",
+	})
+}
+
+// Fabric publishes no checksum, so the launcher's own properties are all that
+// catch a wrong download
+func TestInstallFabricRejectsMismatchedLauncher(t *testing.T) {
+	tests := []struct {
+		name string
+		jar  string
+		want string
+	}{
+		{name: "wrong game", jar: launcherJar(t, "fabric-loader-version=0.14.22\ngame-version=1.19.4\n"), want: "is for Minecraft 1.19.4 loader 0.14.22"},
+		{name: "wrong loader", jar: launcherJar(t, "fabric-loader-version=0.14.21\ngame-version=1.20.1\n"), want: "is for Minecraft 1.20.1 loader 0.14.21"},
+		{name: "no properties", jar: launcherJar(t, ""), want: "is for Minecraft  loader "},
+		{name: "not a jar", jar: "<html>error</html>", want: "is not a jar"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newManifestServer(t)
+			serveFabric(t, server, test.jar)
+			dir := t.TempDir()
+
+			_, err := server.provisioner("").Install(context.Background(), dir, Request{Flavor: Fabric, Minecraft: "1.20.1"})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("expected an error containing %q, got %v", test.want, err)
+			}
+			assertNothingInstalled(t, dir)
+		})
+	}
+}
+
+func TestInstallQuilt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake Java is a shell script")
+	}
+
+	// Stands in for Java running the installer: writes the launch jar into --install-dir
+	java := filepath.Join(t.TempDir(), "java")
+	script := "#!/bin/sh\nfor arg; do case $arg in --install-dir=*) dir=${arg#--install-dir=};; esac; done\n" +
+		"printf 'quilt launcher' > \"$dir/" + quiltLaunchJar + "\"\n"
+	if err := os.WriteFile(java, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newManifestServer(t)
+	serveQuilt(t, server, "quilt installer", sha1Hex("quilt installer"))
+	dir := t.TempDir()
+
+	record, err := server.provisioner(java).Install(context.Background(), dir, Request{Flavor: Quilt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRecorded(t, dir, record, Installed{Flavor: Quilt, Minecraft: "1.20.1", Loader: "0.19.2", Build: "0.9.1", Jar: quiltLaunchJar})
+}
+
+func TestInstallQuiltRejectsChecksumMismatch(t *testing.T) {
+	server := newManifestServer(t)
+	serveQuilt(t, server, "tampered installer", sha1Hex("quilt installer"))
+	dir := t.TempDir()
+
+	// The installer must be refused before it is run, so no Java is needed
+	_, err := server.provisioner(filepath.Join(t.TempDir(), "no-java")).Install(context.Background(), dir, Request{Flavor: Quilt})
+	if err == nil || !strings.Contains(err.Error(), "sha1 mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	assertNothingInstalled(t, dir)
+}
+
+func TestInstallRejectsSizeMismatch(t *testing.T) {
+	server := newManifestServer(t)
+	serveVanilla(t, server, "vanilla server", sha1Hex("vanilla server"))
+	server.json(t, "/mc/1.20.1.json", map[string]interface{}{
+		"downloads": map[string]interface{}{
+			"server": map[string]interface{}{"sha1": sha1Hex("vanilla server"), "size": 1, "url": server.URL + "/mc/server.jar"},
+		},
+	})
+	dir := t.TempDir()
+
+	_, err := server.provisioner("").Install(context.Background(), dir, Request{Flavor: Vanilla})
+	if err == nil || !strings.Contains(err.Error(), "size mismatch") {
+		t.Fatalf("expected a size mismatch, got %v", err)
+	}
+	assertNothingInstalled(t, dir)
+}
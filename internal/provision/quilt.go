@@ -0,0 +1,168 @@
+package provision
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Jar the Quilt installer writes into the server directory
+const quiltLaunchJar = "quilt-server-launch.jar"
+
+const quiltInstallerPath = "org/quiltmc/quilt-installer"
+
+type quiltLoaderEntry struct {
+	Loader struct {
+		Version string `json:"version"`
+	} `json:"loader"`
+}
+
+func (p *Provisioner) quiltVersions(ctx context.Context) ([]string, error) {
+	var games []fabricGameVersion
+	if err := p.getJSON(ctx, p.sources.Quilt+"/v3/versions/game", &games); err != nil {
+		return nil, err
+	}
+	return stableVersions(games), nil
+}
+
+// Quilt has no standalone server jar; its installer assembles the server
+// and its libraries, so it is downloaded, verified and run with Java.
+func (p *Provisioner) installQuilt(ctx context.Context, dir string, req Request) (*Installed, error) {
+	game := req.Minecraft
+	if game == "" {
+		versions, err := p.quiltVersions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("no Quilt game versions available")
+		}
+		game = versions[0]
+	}
+	if err := validVersion(game); err != nil {
+		return nil, err
+	}
+
+	loader := req.Loader
+	if loader == "" {
+		var loaders []quiltLoaderEntry
+		if err := p.getJSON(ctx, fmt.Sprintf("%s/v3/versions/loader/%s", p.sources.Quilt, game), &loaders); err != nil {
+			return nil, err
+		}
+		for _, entry := range loaders {
+			if !strings.Contains(entry.Loader.Version, "-") { // Skip betas
+				loader = entry.Loader.Version
+				break
+			}
+		}
+		if loader == "" {
+			return nil, fmt.Errorf("no stable Quilt loader for Minecraft %s", game)
+		}
+	}
+	if err := validVersion(loader); err != nil {
+		return nil, err
+	}
+
+	installer, version, err := p.fetchQuiltInstaller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(installer)
+
+	args := []string{"-jar", installer, "install", "server", game, loader, "--download-server", "--install-dir=" + dir}
+	log.Printf("Running Quilt installer %s: %s %v", version, p.javaPath, args)
+	cmd := exec.CommandContext(ctx, p.javaPath, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("Quilt installer failed: %v\n%s", err, strings.TrimSpace(string(output)))
+	}
+
+	sum, err := sha256File(filepath.Join(dir, quiltLaunchJar))
+	if err != nil {
+		return nil, fmt.Errorf("Quilt installer did not produce %s: %v", quiltLaunchJar, err)
+	}
+
+	return &Installed{
+		Flavor:    Quilt,
+		Minecraft: game,
+		Loader:    loader,
+		Build:     version,
+		Jar:       quiltLaunchJar,
+		SHA256:    sum,
+	}, nil
+}
+
+// Downloads the latest installer release, verified against the Maven SHA-1 file
+func (p *Provisioner) fetchQuiltInstaller(ctx context.Context) (string, string, error) {
+	base := p.sources.QuiltMaven + "/" + quiltInstallerPath
+
+	resp, err := p.get(ctx, base+"/maven-metadata.xml")
+	if err != nil {
+		return "", "", err
+	}
+	var metadata struct {
+		Versioning struct {
+			Release string `xml:"release"`
+		} `xml:"versioning"`
+	}
+	err = xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&metadata)
+	resp.Body.Close()
+	if err != nil {
+		return "", "", fmt.Errorf("invalid Quilt installer metadata: %v", err)
+	}
+
+	version := metadata.Versioning.Release
+	if err := validVersion(version); err != nil {
+		return "", "", fmt.Errorf("invalid Quilt installer release: %v", err)
+	}
+	jarURL := fmt.Sprintf("%s/%s/quilt-installer-%s.jar", base, version, version)
+
+	resp, err = p.get(ctx, jarURL+".sha1")
+	if err != nil {
+		return "", "", err
+	}
+	checksum, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	if err != nil {
+		return "", "", err
+	}
+
+	fields := strings.Fields(string(checksum))
+	if len(fields) == 0 {
+		return "", "", fmt.Errorf("empty checksum for Quilt installer %s", version)
+	}
+
+	tmp, err := os.CreateTemp("", "quilt-installer-*.jar")
+	if err != nil {
+		return "", "", err
+	}
+	tmp.Close()
+
+	art := &artifact{url: jarURL, algorithm: "sha1", checksum: fields[0]}
+	if _, err := p.download(ctx, art, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	return tmp.Name(), version, nil
+}
+
+func sha256File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
@@ -0,0 +1,64 @@
+package provision
+
+import "time"
+
+// Server flavors that can be provisioned
+const (
+	Vanilla = "vanilla"
+	Fabric  = "fabric"
+	Quilt   = "quilt"
+	Paper   = "paper"
+)
+
+// Name of the record kept in the server directory after an install
+const recordFile = "provision.json"
+
+// Largest server jar or installer accepted
+const maxDownloadSize = 512 << 20
+
+// Sources are the manifest and download endpoints for each flavor
+type Sources struct {
+	Vanilla    string // Mojang version manifest (version_manifest_v2.json)
+	Fabric     string // Fabric meta API base URL
+	Quilt      string // Quilt meta API base URL
+	QuiltMaven string // Maven repository hosting the Quilt installer
+	Paper      string // PaperMC downloads API base URL
+}
+
+// Returns the official endpoints
+func DefaultSources() Sources {
+	return Sources{
+		Vanilla:    "https://piston-meta.mojang.com/mc/game/version_manifest_v2.json",
+		Fabric:     "https://meta.fabricmc.net",
+		Quilt:      "https://meta.quiltmc.org",
+		QuiltMaven: "https://maven.quiltmc.org/repository/release",
+		Paper:      "https://api.papermc.io",
+	}
+}
+
+// Request selects what to install. Empty versions mean the latest stable one.
+type Request struct {
+	Flavor    string `json:"flavor"`
+	Minecraft string `json:"minecraft,omitempty"`
+	Loader    string `json:"loader,omitempty"`
+}
+
+// Installed records the server jar provisioned into a directory
+type Installed struct {
+	Flavor    string    `json:"flavor"`
+	Minecraft string    `json:"minecraft"`
+	Loader    string    `json:"loader,omitempty"` // Fabric or Quilt loader version
+	Build     string    `json:"build,omitempty"`  // Paper build or Fabric installer version
+	Jar       string    `json:"jar"`              // Jar file name, relative to the server directory
+	SHA256    string    `json:"sha256"`
+	Installed time.Time `json:"installed"`
+}
+
+// A server jar to download and the checksum it must match
+type artifact struct {
+	url       string
+	algorithm string // sha1, sha256 or sha512; empty when the source publishes none
+	checksum  string
+	size      int64
+	record    Installed
+}
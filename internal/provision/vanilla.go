@@ -0,0 +1,93 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+)
+
+type vanillaManifest struct {
+	Latest struct {
+		Release string `json:"release"`
+	} `json:"latest"`
+	Versions []struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	} `json:"versions"`
+}
+
+type vanillaVersion struct {
+	Downloads struct {
+		Server *struct {
+			SHA1 string `json:"sha1"`
+			Size int64  `json:"size"`
+			URL  string `json:"url"`
+		} `json:"server"`
+	} `json:"downloads"`
+}
+
+func (p *Provisioner) vanillaManifest(ctx context.Context) (*vanillaManifest, error) {
+	var manifest vanillaManifest
+	if err := p.getJSON(ctx, p.sources.Vanilla, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func (p *Provisioner) vanillaVersions(ctx context.Context) ([]string, error) {
+	manifest, err := p.vanillaManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, v := range manifest.Versions {
+		if v.Type == "release" {
+			versions = append(versions, v.ID)
+		}
+	}
+	return versions, nil
+}
+
+func (p *Provisioner) resolveVanilla(ctx context.Context, req Request) (*artifact, error) {
+	manifest, err := p.vanillaManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	version := req.Minecraft
+	if version == "" {
+		version = manifest.Latest.Release
+	}
+	if err := validVersion(version); err != nil {
+		return nil, err
+	}
+
+	for _, v := range manifest.Versions {
+		if v.ID != version {
+			continue
+		}
+
+		var details vanillaVersion
+		if err := p.getJSON(ctx, v.URL, &details); err != nil {
+			return nil, err
+		}
+		server := details.Downloads.Server
+		if server == nil {
+			return nil, fmt.Errorf("Minecraft %s has no server download", version)
+		}
+
+		return &artifact{
+			url:       server.URL,
+			algorithm: "sha1",
+			checksum:  server.SHA1,
+			size:      server.Size,
+			record: Installed{
+				Flavor:    Vanilla,
+				Minecraft: version,
+				Jar:       fmt.Sprintf("minecraft_server.%s.jar", version),
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("Minecraft version %s not found", version)
+}
@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout blocks a key after repeated failures for a time that doubles with
+// every further failure
+type Lockout struct {
+	free    int           // Failures allowed before the first lock
+	base    time.Duration // Length of the first lock
+	max     time.Duration // Longest lock
+	forget  time.Duration // Failures are forgotten after this long without one
+	mutex   sync.Mutex
+	entries map[string]*lockEntry
+	swept   time.Time
+}
+
+type lockEntry struct {
+	failures int
+	last     time.Time
+	until    time.Time
+}
+
+// Creates a lockout allowing free failures, then locking for base, twice
+// base and so on up to max
+func NewLockout(free int, base, max time.Duration) *Lockout {
+	return &Lockout{
+		free:    free,
+		base:    base,
+		max:     max,
+		forget:  24 * time.Hour,
+		entries: make(map[string]*lockEntry),
+	}
+}
+
+// Returns how long key stays locked, or zero if it isn't
+func (l *Lockout) Locked(key string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if entry, ok := l.entries[key]; ok {
+		if wait := time.Until(entry.until); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// Counts a failure for key, returning the lock it starts, if any
+func (l *Lockout) Fail(key string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+	entry, ok := l.entries[key]
+	if !ok || now.Sub(entry.last) > l.forget {
+		entry = &lockEntry{}
+		l.entries[key] = entry
+	}
+	entry.failures++
+	entry.last = now
+
+	over := entry.failures - l.free
+	if over <= 0 {
+		return 0
+	}
+	lock := l.max
+	if over <= 30 && l.base<<(over-1) < l.max {
+		lock = l.base << (over - 1)
+	}
+	entry.until = now.Add(lock)
+	return lock
+}
+
+// Clears the failures of key after a success
+func (l *Lockout) Reset(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.entries, key)
+}
+
+// Drops entries whose failures were forgotten. Must be called with the mutex held.
+func (l *Lockout) cleanup(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for key, entry := range l.entries {
+		if now.Sub(entry.last) > l.forget && now.After(entry.until) {
+			delete(l.entries, key)
+		}
+	}
+}
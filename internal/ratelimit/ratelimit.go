@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often buckets that have refilled are dropped
+const sweepInterval = time.Minute
+
+// Rate is how many requests may be made per period; up to Count may come at once
+type Rate struct {
+	Count  int
+	Period time.Duration
+}
+
+// Parses a rate such as "10/m", "5/s" or "600/h"; "0" or "off" means no limit
+func ParseRate(value string) (Rate, error) {
+	value = strings.TrimSpace(value)
+	if value == "0" || value == "off" {
+		return Rate{}, nil
+	}
+	count, unit, ok := strings.Cut(value, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q (expected a count per s, m or h, like 10/m)", value)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return Rate{}, fmt.Errorf("invalid count in rate %q", value)
+	}
+	periods := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	period, ok := periods[unit]
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid unit in rate %q (expected s, m or h)", value)
+	}
+	return Rate{Count: n, Period: period}, nil
+}
+
+func (r Rate) String() string {
+	if r.Count == 0 {
+		return "off"
+	}
+	units := map[time.Duration]string{time.Second: "s", time.Minute: "m", time.Hour: "h"}
+	if unit, ok := units[r.Period]; ok {
+		return fmt.Sprintf("%d/%s", r.Count, unit)
+	}
+	return fmt.Sprintf("%d per %s", r.Count, r.Period)
+}
+
+// Limiter is a set of token buckets, one per key, that each hold up to
+// Count tokens and refill at Count per Period
+type Limiter struct {
+	rate Rate
+
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limited bool // Whether the last request was refused
+}
+
+// Creates a limiter; a zero rate allows everything
+func New(rate Rate) *Limiter {
+	return &Limiter{rate: rate, buckets: make(map[string]*bucket)}
+}
+
+// Takes a token for key. Returns how long to wait when there is none, and
+// whether this is the first refusal since the key was last allowed.
+func (l *Limiter) Allow(key string) (time.Duration, bool) {
+	if l == nil || l.rate.Count == 0 {
+		return 0, false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.Count), updated: now}
+		l.buckets[key] = b
+	}
+	perToken := l.rate.Period / time.Duration(l.rate.Count)
+	b.tokens = math.Min(float64(l.rate.Count), b.tokens+float64(now.Sub(b.updated))/float64(perToken))
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		return 0, false
+	}
+	wait := time.Duration((1 - b.tokens) * float64(perToken))
+	tripped := !b.limited
+	b.limited = true
+	return wait, tripped
+}
+
+// Drops buckets that are full again, which behave like new ones. Must be
+// called with the mutex held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= l.rate.Period {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package scheduler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"minecrap_hoster/internal/backup"
+	"minecrap_hoster/internal/minecraft"
+)
+
+// Scheduler runs persisted cron jobs against a Minecraft server
+type Scheduler struct {
+	mutex   sync.Mutex
+	path    string
+	jobs    map[string]*Job
+	history []Run
+	active  map[string]bool // Jobs currently executing
+
+	server  *minecraft.MinecraftServer
+	backups *backup.Manager
+	wake    chan struct{}
+}
+
+// Loads jobs and run history from path; a missing file starts empty
+func NewScheduler(path string, server *minecraft.MinecraftServer, backups *backup.Manager) (*Scheduler, error) {
+	if server == nil {
+		panic("Server must not be nil.")
+	}
+
+	s := &Scheduler{
+		path:    path,
+		jobs:    make(map[string]*Job),
+		active:  make(map[string]bool),
+		server:  server,
+		backups: backups,
+		wake:    make(chan struct{}, 1),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedules: %v", err)
+	}
+
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse schedules: %v", err)
+	}
+
+	now := time.Now()
+	for i := range saved.Jobs {
+		job := saved.Jobs[i]
+		if err := validateJob(&job); err != nil {
+			return nil, fmt.Errorf("job %q: %v", job.Name, err)
+		}
+		// Runs missed while the hoster was down are skipped
+		job.NextRun = nextRun(&job, now)
+		s.jobs[job.ID] = &job
+	}
+	s.history = saved.History
+
+	return s, nil
+}
+
+// Starts the scheduling loop in the background
+func (s *Scheduler) Start() {
+	log.Printf("Scheduler started with %d jobs", len(s.Jobs()))
+	go s.loop()
+}
+
+func (s *Scheduler) loop() {
+	for {
+		timer := time.NewTimer(s.untilNextRun())
+		select {
+		case <-timer.C:
+			s.runDue(time.Now())
+		case <-s.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// Returns how long to sleep before the earliest enabled job is due
+func (s *Scheduler) untilNextRun() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	wait := time.Minute
+	now := time.Now()
+	for _, job := range s.jobs {
+		if !job.Enabled || job.NextRun.IsZero() {
+			continue
+		}
+		if d := job.NextRun.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) runDue(now time.Time) {
+	s.mutex.Lock()
+	var due []Job
+	for _, job := range s.jobs {
+		if !job.Enabled || job.NextRun.IsZero() || job.NextRun.After(now) {
+			continue
+		}
+		job.LastRun = now
+		job.NextRun = nextRun(job, now)
+		due = append(due, *job)
+	}
+	if len(due) > 0 {
+		s.persistLocked()
+	}
+	s.mutex.Unlock()
+
+	for _, job := range due {
+		go s.execute(job, false)
+	}
+}
+
+// Runs a job immediately, outside its schedule
+func (s *Scheduler) RunNow(id string) error {
+	job, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	go s.execute(job, true)
+	return nil
+}
+
+func (s *Scheduler) execute(job Job, manual bool) {
+	s.mutex.Lock()
+	if s.active[job.ID] {
+		s.mutex.Unlock()
+		log.Printf("Skipping job %q: previous run still in progress", job.Name)
+		return
+	}
+	s.active[job.ID] = true
+	s.mutex.Unlock()
+
+	run := Run{
+		JobID:   job.ID,
+		JobName: job.Name,
+		Task:    job.Task.Type,
+		Started: time.Now().UTC(),
+		Manual:  manual,
+	}
+
+	log.Printf("Running scheduled job %q (%s)", job.Name, job.Task.Type)
+	err := s.runTask(job.Task)
+
+	run.Finished = time.Now().UTC()
+	run.Success = err == nil
+	if err != nil {
+		run.Error = err.Error()
+		log.Printf("Scheduled job %q failed: %v", job.Name, err)
+	}
+
+	s.mutex.Lock()
+	delete(s.active, job.ID)
+	s.history = append(s.history, run)
+	if len(s.history) > maxHistory {
+		s.history = s.history[len(s.history)-maxHistory:]
+	}
+	s.persistLocked()
+	s.mutex.Unlock()
+}
+
+func (s *Scheduler) runTask(task Task) error {
+	switch task.Type {
+	case TaskRestart:
+		if s.server.Status == minecraft.Stopped {
+			return s.server.Start()
+		}
+		return s.server.Restart()
+
+	case TaskBackup:
+		if s.backups == nil {
+			return fmt.Errorf("backups are not configured")
+		}
+		target := task.Target
+		if target == "" {
+			target = backup.DefaultTarget().Name
+		}
+		archive, err := s.backups.BackupWorld(s.server, target)
+		if err == nil {
+			s.server.AddLog(fmt.Sprintf("Scheduled backup %s created in %s", archive.Name, archive.Target))
+		}
+		return err
+
+	case TaskCommand:
+		return s.server.ExecuteCommand(task.Command)
+
+	case TaskBroadcast:
+		return s.server.Broadcast(task.Message)
+
+	case TaskStart:
+		if s.server.Status != minecraft.Stopped {
+			log.Printf("Scheduled start skipped: server is not stopped")
+			return nil
+		}
+		return s.server.Start()
+
+	case TaskStop:
+		if s.server.Status == minecraft.Stopped {
+			log.Printf("Scheduled stop skipped: server is already stopped")
+			return nil
+		}
+		return s.server.Stop()
+	}
+	return fmt.Errorf("unknown task type %q", task.Type)
+}
+
+// Returns all jobs ordered by name
+func (s *Scheduler) Jobs() []Job {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs
+}
+
+// Returns a single job by ID
+func (s *Scheduler) Get(id string) (Job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("job %q not found", id)
+	}
+	return *job, nil
+}
+
+// Validates and adds a new job
+func (s *Scheduler) Create(job Job) (Job, error) {
+	if err := validateJob(&job); err != nil {
+		return Job{}, err
+	}
+
+	job.ID = newID()
+	job.Created = time.Now().UTC()
+	job.LastRun = time.Time{}
+	job.NextRun = nextRun(&job, time.Now())
+
+	s.mutex.Lock()
+	s.jobs[job.ID] = &job
+	err := s.persistLocked()
+	s.mutex.Unlock()
+
+	s.notify()
+	return job, err
+}
+
+// Replaces an existing job's definition, keeping its history
+func (s *Scheduler) Update(job Job) (Job, error) {
+	if err := validateJob(&job); err != nil {
+		return Job{}, err
+	}
+
+	s.mutex.Lock()
+	existing, ok := s.jobs[job.ID]
+	if !ok {
+		s.mutex.Unlock()
+		return Job{}, fmt.Errorf("job %q not found", job.ID)
+	}
+	job.Created = existing.Created
+	job.LastRun = existing.LastRun
+	job.NextRun = nextRun(&job, time.Now())
+	s.jobs[job.ID] = &job
+	err := s.persistLocked()
+	s.mutex.Unlock()
+
+	s.notify()
+	return job, err
+}
+
+// Removes a job
+func (s *Scheduler) Delete(id string) error {
+	s.mutex.Lock()
+	if _, ok := s.jobs[id]; !ok {
+		s.mutex.Unlock()
+		return fmt.Errorf("job %q not found", id)
+	}
+	delete(s.jobs, id)
+	err := s.persistLocked()
+	s.mutex.Unlock()
+
+	s.notify()
+	return err
+}
+
+// Returns recorded runs, newest first, optionally filtered by job
+func (s *Scheduler) History(jobID string, limit int) []Run {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	runs := make([]Run, 0, len(s.history))
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if jobID != "" && s.history[i].JobID != jobID {
+			continue
+		}
+		runs = append(runs, s.history[i])
+		if limit > 0 && len(runs) >= limit {
+			break
+		}
+	}
+	return runs
+}
+
+func (s *Scheduler) persistLocked() error {
+	saved := state{
+		Jobs:    make([]Job, 0, len(s.jobs)),
+		History: s.history,
+	}
+	for _, job := range s.jobs {
+		saved.Jobs = append(saved.Jobs, *job)
+	}
+	sort.Slice(saved.Jobs, func(i, j int) bool { return saved.Jobs[i].Created.Before(saved.Jobs[j].Created) })
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Failed to save schedules: %v", err)
+		return fmt.Errorf("failed to save schedules: %v", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func validateJob(job *Job) error {
+	if job.Name == "" {
+		return fmt.Errorf("job name must be non-empty")
+	}
+	if _, err := ParseSchedule(job.Schedule); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(job.TimeZone); err != nil {
+		return fmt.Errorf("unknown time zone %q", job.TimeZone)
+	}
+
+	switch job.Task.Type {
+	case TaskRestart, TaskBackup, TaskStart, TaskStop:
+	case TaskCommand:
+		if job.Task.Command == "" {
+			return fmt.Errorf("command tasks need a command")
+		}
+	case TaskBroadcast:
+		if job.Task.Message == "" {
+			return fmt.Errorf("broadcast tasks need a message")
+		}
+	default:
+		return fmt.Errorf("unknown task type %q", job.Task.Type)
+	}
+	return nil
+}
+
+// Computes a job's next run after now in the job's time zone
+func nextRun(job *Job, now time.Time) time.Time {
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(job.TimeZone)
+	if err != nil {
+		return time.Time{}
+	}
+	return schedule.Next(now.In(loc))
+}
+
+func newID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import "time"
+
+// Task types a job can run
+const (
+	TaskRestart   = "restart"
+	TaskBackup    = "backup"
+	TaskCommand   = "command"
+	TaskBroadcast = "broadcast"
+	TaskStart     = "start"
+	TaskStop      = "stop"
+)
+
+// Number of runs kept in the persisted history
+const maxHistory = 500
+
+// Task describes what a job does when it fires
+type Task struct {
+	Type    string `json:"type"`
+	Command string `json:"command,omitempty"` // Console command for "command" tasks
+	Message string `json:"message,omitempty"` // Chat message for "broadcast" tasks
+	Target  string `json:"target,omitempty"`  // Backup target for "backup" tasks
+}
+
+// Job is a task bound to a cron schedule
+type Job struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	TimeZone string    `json:"time_zone,omitempty"` // IANA zone such as "Europe/Berlin"; empty means UTC
+	Task     Task      `json:"task"`
+	Enabled  bool      `json:"enabled"`
+	Created  time.Time `json:"created"`
+	LastRun  time.Time `json:"last_run,omitempty"`
+	NextRun  time.Time `json:"next_run,omitempty"`
+}
+
+// Run records one execution of a job
+type Run struct {
+	JobID    string    `json:"job_id"`
+	JobName  string    `json:"job_name"`
+	Task     string    `json:"task"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Success  bool      `json:"success"`
+	Error    string    `json:"error,omitempty"`
+	Manual   bool      `json:"manual,omitempty"`
+}
+
+// Persisted scheduler state
+type state struct {
+	Jobs    []Job `json:"jobs"`
+	History []Run `json:"history"`
+}
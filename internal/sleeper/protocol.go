@@ -0,0 +1,174 @@
+package sleeper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Handshake next-state values
+const (
+	stateStatus   = 1
+	stateLogin    = 2
+	stateTransfer = 3
+)
+
+// Upper bound on a packet from a client that has not logged in
+const maxPacketSize = 1 << 16
+
+var errLegacyPing = errors.New("legacy server list ping")
+
+type handshake struct {
+	protocol  int32
+	address   string
+	port      uint16
+	nextState int32
+}
+
+func readVarInt(r io.ByteReader) (int32, error) {
+	var value uint32
+	for i := 0; i < 5; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint32(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return int32(value), nil
+		}
+	}
+	return 0, fmt.Errorf("varint too long")
+}
+
+func appendVarInt(buf []byte, value int32) []byte {
+	v := uint32(value)
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendVarInt(buf, int32(len(s)))
+	return append(buf, s...)
+}
+
+// Reads one uncompressed packet and returns its ID and payload
+func readPacket(r *bufio.Reader) (int32, *bytes.Reader, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length <= 0 || length > maxPacketSize {
+		return 0, nil, fmt.Errorf("bad packet length %d", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+
+	payload := bytes.NewReader(data)
+	id, err := readVarInt(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, payload, nil
+}
+
+func writePacket(w io.Writer, id int32, payload []byte) error {
+	body := appendVarInt(nil, id)
+	body = append(body, payload...)
+
+	packet := appendVarInt(nil, int32(len(body)))
+	packet = append(packet, body...)
+	_, err := w.Write(packet)
+	return err
+}
+
+func readString(r *bytes.Reader, max int) (string, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if length < 0 || int(length) > max*4 {
+		return "", fmt.Errorf("string too long")
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func readHandshake(r *bufio.Reader) (*handshake, error) {
+	// Pre-1.7 clients open with 0xFE instead of a length-prefixed packet
+	if first, err := r.Peek(1); err == nil && first[0] == 0xFE {
+		return nil, errLegacyPing
+	}
+
+	id, payload, err := readPacket(r)
+	if err != nil {
+		return nil, err
+	}
+	if id != 0x00 {
+		return nil, fmt.Errorf("expected handshake, got packet 0x%02x", id)
+	}
+
+	h := &handshake{}
+	if h.protocol, err = readVarInt(payload); err != nil {
+		return nil, err
+	}
+	if h.address, err = readString(payload, 255); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(payload, binary.BigEndian, &h.port); err != nil {
+		return nil, err
+	}
+	if h.nextState, err = readVarInt(payload); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+type statusResponse struct {
+	Version struct {
+		Name     string `json:"name"`
+		Protocol int32  `json:"protocol"`
+	} `json:"version"`
+	Players struct {
+		Max    int `json:"max"`
+		Online int `json:"online"`
+	} `json:"players"`
+	Description struct {
+		Text string `json:"text"`
+	} `json:"description"`
+}
+
+func writeStatus(w io.Writer, protocol int32, versionName, motd string) error {
+	var status statusResponse
+	status.Version.Name = versionName
+	// Echo the client's protocol so it doesn't show "outdated server"
+	status.Version.Protocol = protocol
+	status.Description.Text = motd
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return writePacket(w, 0x00, appendString(nil, string(data)))
+}
+
+func writeLoginDisconnect(w io.Writer, message string) error {
+	data, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return err
+	}
+	return writePacket(w, 0x00, appendString(nil, string(data)))
+}
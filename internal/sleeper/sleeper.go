@@ -0,0 +1,251 @@
+package sleeper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"minecrap_hoster/internal/minecraft"
+)
+
+// Config controls idle shutdown and the wake-on-connect listener
+type Config struct {
+	IdleTimeout time.Duration // Stop after this long with no players; zero disables
+	ListenAddr  string        // Address to listen on while asleep; empty uses server.properties
+	MOTD        string        // Shown in the server list while asleep
+	WakeMessage string        // Shown to players who join to wake the server
+}
+
+// Returns the default sleeper configuration with idle shutdown disabled
+func DefaultConfig() Config {
+	return Config{
+		MOTD:        "Sleeping — join to wake",
+		WakeMessage: "The server is starting up, reconnect in a minute",
+	}
+}
+
+// Sleeper stops an idle server and wakes it when a player tries to join
+type Sleeper struct {
+	mutex    sync.Mutex
+	server   *minecraft.MinecraftServer
+	config   Config
+	listener net.Listener
+	asleep   bool
+	starts   uint64 // Counts server starts, so a sleep racing one can tell it lost
+}
+
+// Creates a sleeper for the server and registers its start hook
+func New(server *minecraft.MinecraftServer, config Config) *Sleeper {
+	if server == nil {
+		panic("Server must not be nil.")
+	}
+
+	s := &Sleeper{server: server, config: config}
+	// The real server needs the port as soon as its process starts
+	server.OnStart(s.release)
+	return s
+}
+
+// Starts watching for idleness in the background
+func (s *Sleeper) Start() {
+	if s.config.IdleTimeout <= 0 {
+		return
+	}
+	log.Printf("Idle shutdown enabled after %v without players", s.config.IdleTimeout)
+	go s.watch()
+}
+
+// Reports whether the server was stopped for idleness and is waiting for a player
+func (s *Sleeper) Asleep() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.asleep
+}
+
+func (s *Sleeper) watch() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.server.Status != minecraft.Running || s.server.IdleFor() < s.config.IdleTimeout {
+			continue
+		}
+		if s.server.CountdownRemaining() > 0 {
+			continue
+		}
+
+		log.Printf("No players for %v, stopping server", s.config.IdleTimeout)
+		s.server.AddLog(fmt.Sprintf("No players for %s, putting server to sleep", s.config.IdleTimeout))
+		starts := s.startCount()
+		if err := s.server.Stop(); err != nil {
+			log.Printf("Idle stop failed: %v", err)
+			continue
+		}
+
+		if err := s.sleep(starts); err != nil {
+			log.Printf("Failed to start wake listener: %v", err)
+			s.server.AddLog(fmt.Sprintf("Failed to start wake listener: %v", err))
+		}
+	}
+}
+
+func (s *Sleeper) startCount() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.starts
+}
+
+// Waits for the server to exit, then takes over its port, unless the server
+// was started again since the start count was taken
+func (s *Sleeper) sleep(starts uint64) error {
+	for s.server.Status != minecraft.Stopped {
+		if s.startCount() != starts {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	addr := s.listenAddr()
+
+	// The port can linger briefly after the server process exits
+	var listener net.Listener
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		if s.startCount() != starts {
+			return nil
+		}
+		listener, err = net.Listen("tcp", addr)
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	// Someone started the server while we were waiting for the port. The start
+	// hook bumps the count under this mutex, so it either ran already and is
+	// seen here, or runs after and closes the listener.
+	if s.starts != starts {
+		s.mutex.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.listener = listener
+	s.asleep = true
+	s.mutex.Unlock()
+
+	log.Printf("Server asleep, listening for players on %s", addr)
+	s.server.AddLog("Server is asleep; it will wake when a player joins")
+	go s.accept(listener)
+	return nil
+}
+
+func (s *Sleeper) listenAddr() string {
+	if s.config.ListenAddr != "" {
+		return s.config.ListenAddr
+	}
+
+	props, _ := s.server.Properties()
+	port := props["server-port"]
+	if _, err := strconv.Atoi(port); err != nil {
+		port = "25565"
+	}
+	return net.JoinHostPort(props["server-ip"], port)
+}
+
+// Closes the wake listener so the real server can bind the port
+func (s *Sleeper) release() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.starts++
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+		log.Printf("Wake listener closed, handing port back to the server")
+	}
+	s.asleep = false
+	return nil
+}
+
+func (s *Sleeper) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Wake listener error: %v", err)
+			}
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Sleeper) handleConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	r := bufio.NewReader(conn)
+	hs, err := readHandshake(r)
+	if err != nil {
+		if !errors.Is(err, errLegacyPing) {
+			log.Printf("Bad handshake from %s: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+
+	switch hs.nextState {
+	case stateStatus:
+		s.answerStatus(conn, r, hs)
+	case stateLogin, stateTransfer:
+		s.answerLogin(conn, r)
+	}
+}
+
+// Answers a server list ping with the sleeping MOTD
+func (s *Sleeper) answerStatus(conn net.Conn, r *bufio.Reader, hs *handshake) {
+	id, _, err := readPacket(r)
+	if err != nil || id != 0x00 {
+		return
+	}
+	if err := writeStatus(conn, hs.protocol, "Sleeping", s.config.MOTD); err != nil {
+		return
+	}
+
+	// Echo the ping so the client can show latency
+	id, payload, err := readPacket(r)
+	if err != nil || id != 0x01 {
+		return
+	}
+	body := make([]byte, payload.Len())
+	payload.Read(body)
+	writePacket(conn, 0x01, body)
+}
+
+// Disconnects a joining player with the wake message and starts the server
+func (s *Sleeper) answerLogin(conn net.Conn, r *bufio.Reader) {
+	name := "unknown"
+	if id, payload, err := readPacket(r); err == nil && id == 0x00 {
+		if player, err := readString(payload, 16); err == nil {
+			name = player
+		}
+	}
+
+	writeLoginDisconnect(conn, s.config.WakeMessage)
+
+	log.Printf("Player %s is waking the server from %s", name, conn.RemoteAddr())
+	s.server.AddLog(fmt.Sprintf("%s tried to join, waking server", name))
+	go func() {
+		if err := s.server.Start(); err != nil {
+			log.Printf("Wake start failed: %v", err)
+		}
+	}()
+}
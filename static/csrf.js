@@ -0,0 +1,5 @@
+// The CSRF token the server expects on POSTs, from the cookie set at login
+function csrfToken() {
+  const match = document.cookie.match(/(?:^|; )mch_csrf=([^;]*)/);
+  return match ? decodeURIComponent(match[1]) : '';
+}